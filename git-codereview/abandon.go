@@ -0,0 +1,77 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var (
+	abandonForce  bool // -f flag, do not ask for confirmation
+	abandonGerrit bool // -gerrit flag, also abandon changes on Gerrit
+)
+
+func cmdAbandon(args []string) {
+	flags.BoolVar(&abandonForce, "f", false, "abandon without asking for confirmation")
+	flags.BoolVar(&abandonGerrit, "gerrit", false, "also abandon the pending changes on Gerrit")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s abandon %s [-f] [-gerrit]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	b := CurrentBranch()
+	b.checkAttached("abandon")
+	if !b.IsLocalOnly() {
+		exitf(exitWrongBranch, "cannot abandon %s branch (only work branches can be abandoned)", b.Name)
+	}
+
+	// Changing branches would carry uncommitted work along
+	// or fail part way through, so insist on a clean tree.
+	checkStaged("abandon")
+	checkUnstaged("abandon")
+
+	work := b.Pending()
+	if !abandonForce && !confirm(fmt.Sprintf("abandon branch %s with %d pending change%s", b.Name, len(work), suffix(len(work), "s"))) {
+		dief("abandon canceled")
+	}
+
+	if abandonGerrit {
+		for _, c := range work {
+			if c.ChangeID == "" {
+				continue
+			}
+			printf("abandoning %s %s on Gerrit", c.ShortHash, c.Subject)
+			if *noRun {
+				continue
+			}
+			if err := gerritAPI("/a/changes/"+fullChangeID(b, c)+"/abandon", []byte(`{}`), nil); err != nil {
+				dief("cannot abandon %s on Gerrit: %v", c.ShortHash, err)
+			}
+		}
+	}
+
+	cleanupBranch(b.Name, true)
+}
+
+// cleanupBranch changes to the upstream branch of the named branch
+// and then deletes the named branch. If force is false, the deletion
+// uses 'git branch -d', which refuses to delete a branch with unmerged
+// commits; otherwise it uses 'git branch -D'.
+func cleanupBranch(name string, force bool) {
+	b := &Branch{Name: name}
+	_, upstream := splitOriginBranch(b.OriginBranch())
+	checkoutOrCreate(upstream)
+	del := "-d"
+	if force {
+		del = "-D"
+	}
+	run("git", "branch", "-q", del, name)
+	printf("deleted branch %s.", name)
+}
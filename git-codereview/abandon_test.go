@@ -0,0 +1,52 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestAbandon(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "abandon", "-f")
+	testPrintedStderr(t, "cannot abandon master branch")
+
+	gt.work(t)
+
+	write(t, gt.client+"/file", "unstaged")
+	testMainDied(t, "abandon", "-f")
+	testPrintedStderr(t, "cannot abandon: unstaged changes exist")
+	trun(t, gt.client, "git", "checkout", "file")
+
+	testMain(t, "abandon", "-f")
+	testRan(t,
+		"git checkout -q master",
+		"git branch -q -D work")
+	testPrintedStderr(t, "deleted branch work.")
+	checkLocalBranches(t, "master")
+}
+
+func TestAbandonGerrit(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	gt.work(t)
+
+	abandoned := false
+	srv.setReply("/a/changes/proj~master~I123456789/abandon", gerritReply{f: func() gerritReply {
+		abandoned = true
+		return gerritReply{body: ")]}'\n{}"}
+	}})
+
+	testMain(t, "abandon", "-f", "-gerrit")
+	if !abandoned {
+		t.Fatalf("change was not abandoned on Gerrit")
+	}
+	testRan(t,
+		"git checkout -q master",
+		"git branch -q -D work")
+}
@@ -35,7 +35,9 @@ var auth struct {
 	password    string
 }
 
-// loadGerritOrigin loads the Gerrit host name from the origin remote.
+// loadGerritOrigin loads the Gerrit host name from the origin remote,
+// or from the .gitreview file if neither codereview.cfg nor the origin
+// remote names a server.
 // If the origin remote does not appear to be a Gerrit server
 // (is missing, is GitHub, is not https, has too many path elements),
 // loadGerritOrigin dies.
@@ -44,10 +46,20 @@ func loadGerritOrigin() {
 		return
 	}
 
-	// Gerrit must be set, either explicitly via the code review config or
-	// implicitly as Git's origin remote.
+	// Gerrit must be set, either explicitly via the code review config,
+	// implicitly as Git's origin remote, or, when origin is not a Gerrit
+	// server but perhaps a mirror, by a .gitreview file.
 	origin := config()["gerrit"]
-	originUrl := trim(cmdOutput("git", "config", "remote.origin.url"))
+	originUrl, _ := trimErr(cmdOutputErr("git", "config", "remote.origin.url"))
+	if origin == "" && !haveGerritInternal("", originUrl) {
+		if gr := loadGitReview(); gr != nil {
+			// The web and API server is the ssh host over https.
+			auth.host = gr.host
+			auth.url = "https://" + gr.host
+			auth.project = strings.TrimSuffix(gr.project, ".git")
+			return
+		}
+	}
 
 	err := loadGerritOriginInternal(origin, originUrl)
 	if err != nil {
@@ -71,7 +83,7 @@ func loadGerritOriginInternal(origin, remoteOrigin string) error {
 		origin = remoteOrigin
 	}
 	if strings.Contains(origin, "github.com") {
-		return fmt.Errorf("git origin must be a Gerrit host, not GitHub: %s", origin)
+		return fmt.Errorf("git origin must be a Gerrit host, not GitHub: %s (set codereview.backend to github to mail pull requests)", origin)
 	}
 
 	if !isAllowedScheme(originUrl.Scheme) {
@@ -159,8 +171,16 @@ func netrcName() string {
 // loadAuth loads the authentication tokens for making API calls to
 // the Gerrit origin host.
 func loadAuth() {
+	if err := loadAuthErr(); err != nil {
+		dief("%v", err)
+	}
+}
+
+// loadAuthErr is like loadAuth but returns an error
+// instead of dying when there is no authentication info.
+func loadAuthErr() error {
 	if auth.user != "" || auth.cookieName != "" {
-		return
+		return nil
 	}
 
 	loadGerritOrigin()
@@ -181,7 +201,7 @@ func loadAuth() {
 			}
 		}
 		if maxMatch > 0 {
-			return
+			return nil
 		}
 	}
 
@@ -193,7 +213,7 @@ func loadAuth() {
 	if homeDir == "" {
 		usr, err := user.Current()
 		if err != nil {
-			dief("failed to get current user home directory to look for %q: %v", netrc, err)
+			return fmt.Errorf("failed to get current user home directory to look for %q: %v", netrc, err)
 		}
 		homeDir = usr.HomeDir
 	}
@@ -206,11 +226,11 @@ func loadAuth() {
 		if len(f) >= 6 && f[0] == "machine" && f[1] == auth.host && f[2] == "login" && f[4] == "password" {
 			auth.user = f[3]
 			auth.password = f[5]
-			return
+			return nil
 		}
 	}
 
-	dief("cannot find authentication info for %s", auth.host)
+	return fmt.Errorf("cannot find authentication info for %s", auth.host)
 }
 
 // gerritError is an HTTP error response served by Gerrit.
@@ -305,7 +325,8 @@ func gerritAPI(path string, requestBody []byte, target interface{}) error {
 // See https://gerrit-review.googlesource.com/Documentation/rest-api-changes.html#change-id for details.
 func fullChangeID(b *Branch, c *Commit) string {
 	loadGerritOrigin()
-	return auth.project + "~" + strings.TrimPrefix(b.OriginBranch(), "origin/") + "~" + c.ChangeID
+	_, branch := splitOriginBranch(b.OriginBranch())
+	return auth.project + "~" + branch + "~" + c.ChangeID
 }
 
 // readGerritChange reads the metadata about a change from the Gerrit server.
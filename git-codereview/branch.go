@@ -7,9 +7,12 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 )
 
 // Branch describes a Git branch.
@@ -19,8 +22,10 @@ type Branch struct {
 	originBranch  string    // upstream origin branch
 	commitsAhead  int       // number of commits ahead of origin branch
 	commitsBehind int       // number of commits behind origin branch
-	branchpoint   string    // latest commit hash shared with origin branch
+	branchpoint   string    // latest commit hash shared with origin branch (or parent branch)
 	pending       []*Commit // pending commits, newest first (children before parents)
+	loadedParent  bool      // parent is valid
+	parent        string    // local work branch this branch is stacked on
 }
 
 // A Commit describes a single pending commit on a Git branch.
@@ -40,9 +45,20 @@ type Commit struct {
 }
 
 // CurrentBranch returns the current branch.
+// In detached HEAD mode, the returned branch has Name == "HEAD".
 func CurrentBranch() *Branch {
-	name := strings.TrimPrefix(trim(cmdOutput("git", "rev-parse", "--abbrev-ref", "HEAD")), "heads/")
-	return &Branch{Name: name}
+	// Unlike git rev-parse --abbrev-ref HEAD, git symbolic-ref
+	// also works on a branch with no commits yet.
+	// It fails quietly with status 1 when HEAD is detached.
+	out, err := cmdOutputErr("git", "symbolic-ref", "-q", "--short", "HEAD")
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 && trim(out) == "" {
+			return &Branch{Name: "HEAD"}
+		}
+		dief("checking current branch: %v\n%s", err, out)
+	}
+	// If a tag has the same name, the short name is heads/name.
+	return &Branch{Name: strings.TrimPrefix(trim(out), "heads/")}
 }
 
 // DetachedHead reports whether branch b corresponds to a detached HEAD
@@ -51,6 +67,16 @@ func (b *Branch) DetachedHead() bool {
 	return b.Name == "HEAD"
 }
 
+// checkAttached dies if b is a detached HEAD, where commands that work
+// with the pending changes of a branch would do something surprising.
+// The action is used in the error message, as in "cannot action".
+func (b *Branch) checkAttached(action string) {
+	if b.DetachedHead() {
+		exitf(exitWrongBranch, "cannot %s: you are in detached HEAD state, not on a branch\n"+
+			"\trun 'git checkout <branch>' or '%s change <branch>' to switch to a branch", action, os.Args[0])
+	}
+}
+
 // OriginBranch returns the name of the origin branch that branch b tracks.
 // The returned name is like "origin/master" or "origin/dev.garbage" or
 // "origin/release-branch.go1.4".
@@ -76,7 +102,22 @@ func (b *Branch) OriginBranch() string {
 	// Have seen both "No upstream configured" and "no upstream configured".
 	if strings.Contains(string(out), "upstream configured") {
 		// Assume branch was created before we set upstream correctly.
-		b.originBranch = "origin/master"
+		// Use the branch that the local upstream branch tracks, which in
+		// a fork can be on another remote, like upstream/main.
+		upstream := upstreamBranch()
+		if origin, err := trimErr(cmdOutputErr("git", "rev-parse", "--abbrev-ref", "-q", upstream+"@{upstream}")); err == nil && origin != "" {
+			b.originBranch = origin
+			return b.originBranch
+		}
+		// If the upstream branch was configured explicitly, make sure it exists,
+		// rather than failing deep inside some later git command.
+		origin := "origin/" + upstream
+		if config()["branch"] != "" {
+			if _, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", "refs/remotes/"+origin); err != nil {
+				dief("cannot find upstream branch %s (set by branch in %s)", origin, configPath)
+			}
+		}
+		b.originBranch = origin
 		return b.originBranch
 	}
 	fmt.Fprintf(stderr(), "%v\n%s\n", commandString(argv[0], argv[1:]), out)
@@ -93,7 +134,71 @@ func (b *Branch) FullName() string {
 
 // IsLocalOnly reports whether b is a local work branch (only local, not known to remote server).
 func (b *Branch) IsLocalOnly() bool {
-	return "origin/"+b.Name != b.OriginBranch()
+	_, name := splitOriginBranch(b.OriginBranch())
+	return name != b.Name
+}
+
+// splitOriginBranch splits an origin branch name like "origin/master"
+// or "upstream/main" into its remote and branch names.
+func splitOriginBranch(origin string) (remote, branch string) {
+	i := strings.Index(origin, "/")
+	if i < 0 {
+		return "origin", origin
+	}
+	return origin[:i], origin[i+1:]
+}
+
+// Parent returns the name of the local work branch that b is stacked on,
+// as recorded by 'change -on', or "" if b is not stacked on a branch
+// or that branch no longer exists.
+func (b *Branch) Parent() string {
+	if b.loadedParent {
+		return b.parent
+	}
+	b.loadedParent = true
+	if b.DetachedHead() {
+		return ""
+	}
+	name, _ := trimErr(cmdOutputErr("git", "config", "--get", "branch."+b.Name+".codereviewparent"))
+	if name == "" {
+		return ""
+	}
+	if _, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/"+name); err != nil {
+		verbosef("ignoring parent branch %s of %s: branch does not exist", name, b.Name)
+		return ""
+	}
+	b.parent = name
+	return b.parent
+}
+
+// parentForkPoint returns the commit at which b forked from its parent
+// branch, which must be set. If the parent's changes have been amended
+// since, the fork point is the parent's old commit, as found in the
+// parent's reflog, not the merge base.
+func (b *Branch) parentForkPoint() string {
+	parent := "refs/heads/" + b.Parent()
+	if out, err := cmdOutputErr("git", "merge-base", "--fork-point", parent, b.FullName()); err == nil {
+		return trim(out)
+	}
+	return trim(cmdOutput("git", "merge-base", parent, b.FullName()))
+}
+
+// parentSubmitted reports whether the changes in b's parent branch,
+// which must be set, have all been submitted to the origin branch,
+// so that b no longer depends on any pending change. A parent branch
+// without pending changes counts as submitted, unless it is stacked too.
+func (b *Branch) parentSubmitted() bool {
+	p := &Branch{Name: b.Parent()}
+	work := p.Pending()
+	if len(work) == 0 {
+		return p.Parent() == ""
+	}
+	for _, c := range work {
+		if !p.Submitted(c.ChangeID) {
+			return false
+		}
+	}
+	return true
 }
 
 // HasPendingCommit reports whether b has any pending commits.
@@ -120,6 +225,7 @@ func (b *Branch) loadPending() {
 		return
 	}
 	b.loadedPending = true
+	b.pending = nil // in case of reload
 
 	// In case of early return.
 	b.branchpoint = trim(cmdOutput("git", "rev-parse", "HEAD"))
@@ -129,9 +235,15 @@ func (b *Branch) loadPending() {
 	}
 
 	// Note: --topo-order means child first, then parent.
+	// The changes of a branch stacked on another work branch
+	// are only the ones since it forked from that branch.
 	origin := b.OriginBranch()
+	base := origin
+	if b.Parent() != "" {
+		base = b.parentForkPoint()
+	}
 	const numField = 5
-	all := trim(cmdOutput("git", "log", "--topo-order", "--format=format:%H%x00%h%x00%P%x00%B%x00%s%x00", origin+".."+b.FullName(), "--"))
+	all := trim(cmdOutput("git", "log", "--topo-order", "--format=format:%H%x00%h%x00%P%x00%B%x00%s%x00", base+".."+b.FullName(), "--"))
 	fields := strings.Split(all, "\x00")
 	if len(fields) < numField {
 		return // nothing pending
@@ -194,7 +306,7 @@ func (b *Branch) Submitted(id string) bool {
 		return false
 	}
 	line := "Change-Id: " + id
-	out := cmdOutput("git", "log", "-n", "1", "-F", "--grep", line, b.Name+".."+b.OriginBranch(), "--")
+	out := cmdOutput("git", "log", "-n", "1", "-F", "--grep", line, b.FullName()+".."+b.OriginBranch(), "--")
 	return strings.Contains(out, line)
 }
 
@@ -247,41 +359,66 @@ func LocalChanges() (staged, unstaged, untracked []string) {
 	return
 }
 
+// inParallel calls f(i) for each i from 0 to n-1, running up to
+// GOMAXPROCS calls at once (but at least 4, since the calls mostly
+// wait for git commands and the network), and waits for them all.
+// Callers collect results by index, so output order stays the same.
+//
+// The calls must only read the repository state: git commands that read
+// refs and objects can run at once, but ones that change the index or
+// the work tree cannot. Nor may they be the first to load a cached value,
+// such as the configuration, which would race between the calls.
+func inParallel(n int, f func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers < 4 {
+		workers = 4
+	}
+	if workers > n {
+		workers = n
+	}
+	work := make(chan int, n)
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 // LocalBranches returns a list of all known local branches.
 // If the current directory is in detached HEAD mode, one returned
 // branch will have Name == "HEAD" and DetachedHead() == true.
 func LocalBranches() []*Branch {
 	var branches []*Branch
-	current := CurrentBranch()
-	for _, s := range nonBlankLines(cmdOutput("git", "branch", "-q")) {
-		s = strings.TrimSpace(s)
-		if strings.HasPrefix(s, "* ") {
-			// * marks current branch in output.
-			// Normally the current branch has a name like any other,
-			// but in detached HEAD mode the branch listing shows
-			// a localized (translated) textual description instead of
-			// a branch name. Avoid language-specific differences
-			// by using CurrentBranch().Name for the current branch.
-			// It detects detached HEAD mode in a more portable way.
-			// (git rev-parse --abbrev-ref HEAD returns 'HEAD').
-			s = current.Name
-		}
-		branches = append(branches, &Branch{Name: s})
+	// In detached HEAD mode, git branch lists a localized (translated)
+	// description instead of a branch name, so use git for-each-ref,
+	// whose output is meant for scripts, and add the detached HEAD
+	// ourselves. Listing full ref names avoids the heads/ prefix that
+	// short names get when a tag has the same name.
+	if current := CurrentBranch(); current.DetachedHead() {
+		branches = append(branches, current)
+	}
+	for _, ref := range nonBlankLines(cmdOutput("git", "for-each-ref", "--format=%(refname)", "refs/heads/")) {
+		branches = append(branches, &Branch{Name: strings.TrimPrefix(ref, "refs/heads/")})
 	}
 	return branches
 }
 
+// OriginBranches returns the names of the branches on origin,
+// like "origin/master", as of the last fetch.
 func OriginBranches() []string {
 	var branches []string
-	for _, line := range nonBlankLines(cmdOutput("git", "branch", "-a", "-q")) {
-		line = strings.TrimSpace(line)
-		if i := strings.Index(line, " -> "); i >= 0 {
-			line = line[:i]
-		}
-		name := strings.TrimSpace(strings.TrimPrefix(line, "* "))
-		if strings.HasPrefix(name, "remotes/origin/") {
-			branches = append(branches, strings.TrimPrefix(name, "remotes/"))
-		}
+	for _, ref := range nonBlankLines(cmdOutput("git", "for-each-ref", "--format=%(refname)", "refs/remotes/origin/")) {
+		branches = append(branches, strings.TrimPrefix(ref, "refs/remotes/"))
 	}
 	return branches
 }
@@ -340,7 +477,7 @@ func (b *Branch) CommitByRev(action, rev string) *Commit {
 func (b *Branch) DefaultCommit(action, extra string) *Commit {
 	work := b.Pending()
 	if len(work) == 0 {
-		dief("cannot %s: no changes pending", action)
+		exitf(exitNoChanges, "cannot %s: no changes pending", action)
 	}
 	if len(work) >= 2 {
 		var buf bytes.Buffer
@@ -372,7 +509,34 @@ func cmdRebaseWork(args []string) {
 		dief("cannot rebase with uncommitted work")
 	}
 	if len(b.Pending()) == 0 {
-		dief("no pending work")
+		exitf(exitNoChanges, "no pending work")
 	}
-	run("git", "rebase", "-i", b.Branchpoint())
+	run("git", "rebase", "-i", "--autosquash", b.Branchpoint())
+}
+
+func cmdSquash(args []string) {
+	expectZeroArgs(args, "squash")
+	b := CurrentBranch()
+	b.checkAttached("squash")
+	if !b.IsLocalOnly() {
+		exitf(exitWrongBranch, "cannot squash on %s branch (use '%s change branchname').", b.Name, os.Args[0])
+	}
+	// Staged changes would be folded into the squashed commit.
+	checkStaged("squash")
+
+	work := b.Pending()
+	if len(work) < 2 {
+		printf("nothing to squash")
+		return
+	}
+
+	if !confirm(fmt.Sprintf("squash %d commits on branch %s into one", len(work), b.Name)) {
+		dief("squash canceled")
+	}
+
+	// Keep the message (and so the Change-Id) of the first pending commit.
+	first := work[len(work)-1]
+	run("git", "reset", "-q", "--soft", b.Branchpoint())
+	run("git", "commit", "-q", "-C", first.Hash)
+	printf("squashed %d commits into one change.", len(work))
 }
@@ -7,6 +7,7 @@ package main
 import (
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -44,6 +45,27 @@ func TestCurrentBranch(t *testing.T) {
 	checkCurrentBranch(t, "HEAD", "origin/HEAD", false, false, "", "")
 }
 
+func TestCurrentBranchUpstreamConfig(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.server+"/codereview.cfg", "branch: dev.branch\n")
+	trun(t, gt.server, "git", "add", "codereview.cfg")
+	trun(t, gt.server, "git", "commit", "-m", "add branch config")
+	trun(t, gt.client, "git", "pull", "-r")
+
+	t.Logf("on newbranch without upstream")
+	trun(t, gt.client, "git", "checkout", "--no-track", "-b", "newbranch")
+	cachedConfig = nil
+	checkCurrentBranch(t, "newbranch", "origin/dev.branch", true, true, "", "add branch config")
+
+	t.Logf("missing upstream branch")
+	write(t, gt.client+"/codereview.cfg", "branch: nonexistent\n")
+	cachedConfig = nil
+	testMainDied(t, "branchpoint")
+	testPrintedStderr(t, "cannot find upstream branch origin/nonexistent")
+}
+
 func checkCurrentBranch(t *testing.T, name, origin string, isLocal, hasPending bool, changeID, subject string) {
 	b := CurrentBranch()
 	if b.Name != name {
@@ -149,12 +171,56 @@ func TestRebaseWork(t *testing.T) {
 	for i := 0; i < 4; i++ {
 		testMain(t, "rebase-work", "-n")
 		t.Logf("numCommits=%d", i)
-		testPrintedStderr(t, "git rebase -i "+hash)
+		testPrintedStderr(t, "git rebase -i --autosquash "+hash)
 
 		gt.work(t)
 	}
 }
 
+func TestSquash(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "squash")
+	testPrintedStderr(t, "cannot squash on master branch")
+
+	gt.work(t)
+	testMain(t, "squash")
+	testPrintedStderr(t, "nothing to squash")
+	testRan(t)
+
+	gt.work(t)
+	gt.work(t)
+	b := CurrentBranch()
+	bp := b.Branchpoint()
+	first := b.Pending()[2].Hash
+
+	write(t, gt.client+"/file", "staged")
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "squash")
+	testPrintedStderr(t, "cannot squash: staged changes exist")
+	trun(t, gt.client, "git", "reset", "-q", "--hard")
+
+	// Standard input is not a terminal, so the confirmation needs -y.
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+	testMainDied(t, "squash")
+	testPrintedStderr(t, "squash 3 commits on branch work into one: not confirmed", "use -y", "squash canceled")
+	testRan(t)
+
+	testMain(t, "squash", "-y")
+	testRan(t,
+		"git reset -q --soft "+bp,
+		"git commit -q -C "+first)
+	checkCurrentBranch(t, "work", "origin/master", true, true, "I123456789", "msg")
+	if n := len(CurrentBranch().Pending()); n != 1 {
+		t.Fatalf("have %d pending commits after squash, want 1", n)
+	}
+	if got := string(read(t, gt.client+"/file")); got != "new content 3" {
+		t.Fatalf("file = %q after squash, want %q", got, "new content 3")
+	}
+}
+
 func TestBranchpointMerge(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -182,3 +248,60 @@ func TestBranchpointMerge(t *testing.T) {
 		t.Fatalf("branchpoint=%q, want %q", bp, hash)
 	}
 }
+
+func TestCurrentBranchUnborn(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.client, "git", "checkout", "-q", "--orphan", "fresh")
+	if b := CurrentBranch(); b.Name != "fresh" {
+		t.Fatalf("CurrentBranch() = %q on branch with no commits, want fresh", b.Name)
+	}
+}
+
+func TestDetachedHead(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	trun(t, gt.client, "git", "checkout", "-q", "--detach")
+	var names []string
+	for _, b := range LocalBranches() {
+		names = append(names, b.Name)
+	}
+	if s := strings.Join(names, " "); s != "HEAD master work" {
+		t.Errorf("LocalBranches() = %s, want HEAD master work", s)
+	}
+
+	write(t, gt.client+"/file", "staged")
+	trun(t, gt.client, "git", "add", "file")
+	for _, cmd := range []string{"change", "mail", "submit", "sync"} {
+		testMainDied(t, cmd)
+		testPrintedStderr(t, "cannot "+cmd+": you are in detached HEAD state", "change <branch>")
+		if exitCode != exitWrongBranch {
+			t.Errorf("%s: exit code = %d, want %d", cmd, exitCode, exitWrongBranch)
+		}
+	}
+	testRan(t)
+
+	// Switching to a branch still works.
+	testMain(t, "change", "work")
+	testRan(t, "git checkout -q work")
+}
+
+func TestInParallel(t *testing.T) {
+	for _, n := range []int{0, 1, 3, 100} {
+		var mu sync.Mutex
+		calls := make([]int, n)
+		inParallel(n, func(i int) {
+			mu.Lock()
+			calls[i]++
+			mu.Unlock()
+		})
+		for i, c := range calls {
+			if c != 1 {
+				t.Errorf("inParallel(%d, f): f(%d) called %d times, want 1", n, i, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var (
+	branchFromChangeName  string // -b flag
+	branchFromChangeForce bool   // -f flag
+)
+
+func cmdBranchFromChange(args []string) {
+	flags.StringVar(&branchFromChangeName, "b", "", "name the new branch `name` instead of change-<number>")
+	flags.BoolVar(&branchFromChangeForce, "f", false, "reset the branch if it already exists")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s branch-from-change %s [-b name] [-f] change[/patchset]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	cl, ps, ok := parseCL(flags.Arg(0))
+	if !ok {
+		exitf(exitUsage, "invalid change %q: want a change number, optionally followed by /patchset", flags.Arg(0))
+	}
+	if !haveGerrit() {
+		dief("cannot fetch a change without gerrit")
+	}
+	if HasStagedChanges() || HasUnstagedChanges() {
+		dief("cannot fetch a change with uncommitted work")
+	}
+
+	name := branchFromChangeName
+	if name == "" {
+		name = "change-" + cl
+	}
+	checkBranchName(name, true)
+	_, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/"+name)
+	exists := err == nil
+	if exists && !branchFromChangeForce {
+		dief("cannot create branch %s: branch already exists; use -f to reset it to the change", name)
+	}
+
+	change, ps := fetchCL("fetch", cl, ps)
+	origin := "origin/" + change.Branch
+	if change.Branch == "" {
+		origin = "origin/" + upstreamBranch()
+	}
+	run("git", "checkout", "-q", "-B", name, "FETCH_HEAD")
+	if err := runErr("git", "branch", "-q", "--set-upstream-to", origin); err != nil {
+		// The change's branch may not have been fetched yet.
+		printf("warning: cannot track %s: %v", origin, err)
+	}
+	verb := "created"
+	if exists {
+		verb = "reset"
+	}
+	printf("%s branch %s at CL %s/%s.\n\t%s", verb, name, cl, ps, change.Subject)
+}
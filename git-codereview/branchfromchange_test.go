@@ -0,0 +1,59 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestBranchFromChange(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	write(t, gt.server+"/codereview.cfg", "gerrit: on")
+	trun(t, gt.server, "git", "add", "codereview.cfg")
+	trun(t, gt.server, "git", "commit", "-m", "codereview.cfg on master")
+	trun(t, gt.client, "git", "pull")
+
+	hash1 := trim(trun(t, gt.server, "git", "rev-parse", "dev.branch"))
+	hash2 := trim(trun(t, gt.server, "git", "rev-parse", "release.branch"))
+	trun(t, gt.server, "git", "update-ref", "refs/changes/00/100/1", hash1)
+	trun(t, gt.server, "git", "update-ref", "refs/changes/00/100/2", hash2)
+	srv.setReply("/a/changes/100", gerritReply{json: GerritChange{
+		Branch:          "master",
+		Subject:         "foo: a change",
+		CurrentRevision: hash2,
+		Revisions:       map[string]*GerritRevision{hash2: {Number: 2}},
+	}})
+
+	testMainDied(t, "branch-from-change", "abc")
+	testPrintedStderr(t, `invalid change "abc"`)
+	testMainDied(t, "branch-from-change", "101")
+	testPrintedStderr(t, "cannot fetch CL 101: change not found on Gerrit server")
+
+	testMain(t, "branch-from-change", "100")
+	testRan(t, "git fetch -q origin refs/changes/00/100/2",
+		"git checkout -q -B change-100 FETCH_HEAD",
+		"git branch -q --set-upstream-to origin/master")
+	testPrintedStderr(t, "created branch change-100 at CL 100/2.\n\tfoo: a change")
+	if hash := trim(trun(t, gt.client, "git", "rev-parse", "HEAD")); hash != hash2 {
+		t.Fatalf("HEAD = %s, want %s", hash, hash2)
+	}
+
+	// The fetched commits come from other branches, without codereview.cfg.
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+	testMainDied(t, "branch-from-change", "100/1")
+	testPrintedStderr(t, "branch already exists; use -f")
+	testMain(t, "branch-from-change", "-f", "100/1")
+	testPrintedStderr(t, "reset branch change-100 at CL 100/1.")
+	if hash := trim(trun(t, gt.client, "git", "rev-parse", "change-100")); hash != hash1 {
+		t.Fatalf("change-100 = %s, want %s", hash, hash1)
+	}
+
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+	testMain(t, "branch-from-change", "-b", "review", "100")
+	testPrintedStderr(t, "created branch review at CL 100/2.")
+}
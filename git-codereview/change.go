@@ -6,28 +6,79 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var changeAuto bool
 var changeQuick bool
+var changeMessage string
+var changeFile string
+var changeBase string
+var changeSignoff bool
+var changeKeepDate bool
+var changeResetDate bool
+var changeFiles bool
+var changePaths []string // files given with -files
+var changeAs string
+var changeOn string
 
 func cmdChange(args []string) {
+	changePaths = nil
 	flags.BoolVar(&changeAuto, "a", false, "add changes to any tracked files")
 	flags.BoolVar(&changeQuick, "q", false, "do not edit pending commit msg")
+	flags.StringVar(&changeMessage, "m", "", "use `msg` as the commit message")
+	flags.StringVar(&changeFile, "F", "", "read the commit message from `file` (- for standard input)")
+	flags.StringVar(&changeBase, "base", "", "create the new branch at `ref` instead of HEAD")
+	flags.StringVar(&changeOn, "on", "", "create the new branch stacked on the work `branch`")
+	flags.BoolVar(&changeSignoff, "s", gitConfigBool("signoff", false), "add a Signed-off-by trailer to the commit message")
+	flags.BoolVar(&changeKeepDate, "keep-date", false, "keep the author and committer dates when amending")
+	flags.BoolVar(&changeResetDate, "reset-date", false, "set the author and committer dates to now when amending")
+	flags.BoolVar(&changeFiles, "files", false, "amend the pending change with only the named files")
+	flags.StringVar(&changeAs, "as", "", "commit as `identity` \"Name <email>\" instead of the git user")
 	flags.Parse(args)
-	if len(flags.Args()) > 1 {
-		fmt.Fprintf(stderr(), "Usage: %s change %s [branch]\n", os.Args[0], globalFlags)
-		os.Exit(2)
+	if !changeFiles && len(flags.Args()) > 1 || (changeBase != "" || changeOn != "") && len(flags.Args()) == 0 ||
+		changeFiles && (len(flags.Args()) == 0 || changeAuto || changeBase != "" || changeOn != "") || changeBase != "" && changeOn != "" {
+		fmt.Fprintf(stderr(), "Usage: %s change %s [-a] [-q] [-s] [-as identity] [-keep-date | -reset-date] [-m msg | -F file] [-base ref | -on branch] [branch]\n", os.Args[0], globalFlags)
+		fmt.Fprintf(stderr(), "       %s change %s -files [-q] [-s] [-as identity] [-keep-date | -reset-date] [-m msg | -F file] path...\n", os.Args[0], globalFlags)
+		os.Exit(exitUsage)
 	}
+	if changeMessage != "" && changeFile != "" {
+		exitf(exitUsage, "cannot use both -m and -F")
+	}
+	if changeKeepDate && changeResetDate {
+		exitf(exitUsage, "cannot use both -keep-date and -reset-date")
+	}
+	if changeFile != "" {
+		readChangeFile()
+	}
+	if changeMessage != "" {
+		changeMessage = normalizeMessage(changeMessage)
+		if changeMessage == "" {
+			dief("cannot use commit message: message is empty")
+		}
+		checkSubject(changeMessage)
+	}
+	defer useIdentity(changeAs)()
 
 	// Checkout or create branch, if specified.
 	target := flags.Arg(0)
+	if changeFiles {
+		target = ""
+	}
 	if target != "" {
-		checkoutOrCreate(target)
+		if changeOn != "" {
+			createStackedBranch(target, changeOn)
+		} else if changeBase != "" {
+			createWorkBranchAt(target, changeBase)
+		} else {
+			checkoutOrCreate(target)
+		}
 		b := CurrentBranch()
 		if HasStagedChanges() && b.IsLocalOnly() && !b.HasPendingCommit() {
 			commitChanges(false)
@@ -38,17 +89,39 @@ func cmdChange(args []string) {
 
 	// Create or amend change commit.
 	b := CurrentBranch()
+	b.checkAttached("change")
 	if !b.IsLocalOnly() {
-		dief("can't commit to %s branch (use '%s change branchname').", b.Name, os.Args[0])
+		exitf(exitWrongBranch, "can't commit to %s branch (use '%s change branchname').", b.Name, os.Args[0])
 	}
 
 	amend := b.HasPendingCommit()
+	if !amend && (changeKeepDate || changeResetDate) {
+		dief("cannot use -keep-date or -reset-date: no pending change to amend")
+	}
+	if changeFiles {
+		if !amend {
+			dief("cannot use -files: no pending change to amend")
+		}
+		stageFiles(flags.Args())
+	}
+	var old *Commit
 	if amend {
 		// Dies if there is not exactly one commit.
-		b.DefaultCommit("amend change", "")
+		old = b.DefaultCommit("amend change", "")
 	}
 	commitChanges(amend)
 	b.loadedPending = false // force reload after commitChanges
+	if amend && !*noRun {
+		c := b.Pending()[0]
+		verbosef("amended %s to %s: %s", old.ShortHash, c.ShortHash, c.Subject)
+		if c.Message == old.Message && commitTree(c) == commitTree(old) {
+			if changeKeepDate {
+				printf("warning: no changes added and commit message unchanged; amend did nothing.")
+			} else {
+				printf("warning: no changes added and commit message unchanged; amend only reset the commit date.")
+			}
+		}
+	}
 	b.check()
 }
 
@@ -57,11 +130,10 @@ func (b *Branch) check() {
 	staged, unstaged, _ := LocalChanges()
 	if len(staged) == 0 && len(unstaged) == 0 {
 		// No staged changes, no unstaged changes.
-		// If the branch is behind upstream, now is a good time to point that out.
+		// If the branch is far behind upstream, now is a good time to point that out.
 		// This applies to both local work branches and tracking branches.
-		// TODO(rsc): Test.
 		b.loadPending()
-		if b.commitsBehind > 0 {
+		if n := staleThreshold(); n > 0 && b.commitsBehind > n {
 			printf("warning: %d commit%s behind %s; run 'git sync' to update.", b.commitsBehind, suffix(b.commitsBehind, "s"), b.OriginBranch())
 		}
 	}
@@ -72,16 +144,67 @@ func (b *Branch) check() {
 	}
 }
 
+// staleThreshold returns the number of commits a branch may be behind
+// its upstream branch before the change command warns about it,
+// as set by codereview.stalethreshold (default 50; 0 means never warn).
+func staleThreshold() int {
+	s := gitConfigDefault("stalethreshold")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		dief("invalid codereview.stalethreshold setting %q: must be a non-negative integer", s)
+	}
+	return n
+}
+
 var testCommitMsg string
 
+// commitTree returns the hash of the tree of commit c.
+func commitTree(c *Commit) string {
+	return trim(cmdOutput("git", "rev-parse", c.Hash+"^{tree}"))
+}
+
 func commitChanges(amend bool) {
 	// git commit will run the gofmt hook.
 	// Run it now to give a better error (won't show a git commit command failing).
 	hookGofmt()
 
-	if HasUnstagedChanges() && !HasStagedChanges() && !changeAuto {
+	if HasUnstagedChanges() && !HasStagedChanges() && !changeAuto && changePaths == nil {
 		printf("warning: unstaged changes and no staged changes; use 'git add' or 'git change -a'")
 	}
+	// By default, git commit --amend keeps the author date
+	// but sets the committer date to now.
+	var authorDate, committerDate string
+	if amend && changeKeepDate {
+		dates := lines(cmdOutput("git", "log", "-n", "1", "--date=raw", "--format=%ad%n%cd", "HEAD"))
+		if len(dates) != 2 {
+			dief("cannot read dates of HEAD commit")
+		}
+		authorDate, committerDate = dates[0], dates[1]
+		if old, ok := os.LookupEnv("GIT_COMMITTER_DATE"); ok {
+			defer os.Setenv("GIT_COMMITTER_DATE", old)
+		} else {
+			defer os.Unsetenv("GIT_COMMITTER_DATE")
+		}
+		os.Setenv("GIT_COMMITTER_DATE", committerDate)
+	}
+	if changeFile == "" && changeMessage == "" && testCommitMsg == "" && !(amend && changeQuick) {
+		checkEditor("commit change")
+	}
+	if amend && (changeFile != "" || changeMessage != "") {
+		// The new message replaces the old one entirely,
+		// so carry over its Change-Id and other trailers.
+		msg := changeMessage
+		if changeFile != "" {
+			data, err := ioutil.ReadFile(changeFile)
+			if err != nil {
+				dief("reading commit message: %v", err)
+			}
+			msg = string(data)
+		}
+		if kept := keepTrailers(msg, cmdOutput("git", "log", "-n", "1", "--format=%B", "HEAD")); kept != msg {
+			changeMessage, changeFile = kept, ""
+		}
+	}
 	commit := func(amend bool) {
 		args := []string{"commit", "-q", "--allow-empty"}
 		if amend {
@@ -89,13 +212,29 @@ func commitChanges(amend bool) {
 			if changeQuick {
 				args = append(args, "--no-edit")
 			}
+			if authorDate != "" {
+				args = append(args, "--date="+authorDate)
+			} else if changeResetDate {
+				args = append(args, "--date=now")
+			}
 		}
-		if testCommitMsg != "" {
+		if changeFile != "" {
+			args = append(args, "-F", changeFile)
+		} else if changeMessage != "" {
+			args = append(args, "-m", changeMessage)
+		} else if testCommitMsg != "" {
 			args = append(args, "-m", testCommitMsg)
 		}
 		if changeAuto {
 			args = append(args, "-a")
 		}
+		if changeSignoff {
+			args = append(args, "-s")
+		}
+		if changePaths != nil {
+			// Commit only these paths, leaving any other staged changes staged.
+			args = append(append(args, "--"), changePaths...)
+		}
 		run("git", args...)
 	}
 	commit(amend)
@@ -109,6 +248,138 @@ func commitChanges(amend bool) {
 	printf("change updated.")
 }
 
+// stageFiles stages the changes to the given paths for change -files,
+// recording in changePaths the ones to commit. It warns about each path
+// that has no changes and dies if none of them do.
+func stageFiles(paths []string) {
+	for _, path := range paths {
+		if trim(cmdOutput("git", "status", "--porcelain", "--untracked-files=all", "--", path)) == "" {
+			printf("warning: %s has no changes; skipping.", path)
+			continue
+		}
+		changePaths = append(changePaths, path)
+	}
+	if len(changePaths) == 0 {
+		dief("cannot amend change: none of the files have changes")
+	}
+	run("git", append([]string{"add", "--"}, changePaths...)...)
+}
+
+// readChangeFile checks that the -F file holds a commit message,
+// so that a mistyped file name fails before creating any branch.
+// Standard input can only be read once, so a message read from it
+// is passed on to git commit with -m instead, as is a message that
+// normalizeMessage would change.
+func readChangeFile() {
+	var data []byte
+	var err error
+	if changeFile == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(changeFile)
+	}
+	if err != nil {
+		dief("reading commit message: %v", err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		name := changeFile
+		if name == "-" {
+			name = "standard input"
+		}
+		dief("cannot use commit message from %s: message is empty", name)
+	}
+	msg := string(data)
+	if changeFile == "-" || normalizeMessage(msg) != strings.TrimSuffix(msg, "\n") {
+		changeMessage = msg
+		changeFile = ""
+		return
+	}
+	checkSubject(msg)
+}
+
+// normalizeMessage tidies a commit message given on the command line
+// with -m or -F, which does not go through the editor: it removes
+// trailing spaces from each line and blank lines from the start and end,
+// and it adds the blank line that should separate the subject from the body.
+func normalizeMessage(msg string) string {
+	var lines []string
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" && len(lines) == 0 {
+			continue
+		}
+		if len(lines) == 1 && line != "" {
+			lines = append(lines, "")
+		}
+		lines = append(lines, line)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trailers returns the trailer lines, like "Change-Id: I1234…",
+// that make up the final paragraph of the commit message msg.
+func trailers(msg string) []string {
+	msg = strings.TrimSpace(msg)
+	if !endsInTrailers([]byte(msg)) {
+		return nil
+	}
+	return lines(msg[strings.LastIndex(msg, "\n\n")+2:])
+}
+
+// keepTrailers returns the new commit message msg with the trailers
+// of the old message old added to it, so that amending a commit
+// with a new message keeps its Change-Id and with it the Gerrit change.
+// It skips trailers that msg already has, and the old Change-Id line
+// if msg has a Change-Id line of its own.
+func keepTrailers(msg, old string) string {
+	have := trailers(msg)
+	haveChangeID := false
+	for _, t := range have {
+		if strings.HasPrefix(t, "Change-Id: ") {
+			haveChangeID = true
+		}
+	}
+	var add []string
+Trailers:
+	for _, t := range trailers(old) {
+		if haveChangeID && strings.HasPrefix(t, "Change-Id: ") {
+			continue
+		}
+		for _, h := range have {
+			if t == h {
+				continue Trailers
+			}
+		}
+		add = append(add, t)
+	}
+	if len(add) == 0 {
+		return msg
+	}
+	msg = strings.TrimRight(msg, "\n")
+	if len(have) > 0 {
+		return msg + "\n" + strings.Join(add, "\n")
+	}
+	return msg + "\n\n" + strings.Join(add, "\n")
+}
+
+// maxSubjectLen is the length beyond which checkSubject warns about
+// a commit subject, which many tools show only the start of.
+const maxSubjectLen = 72
+
+// checkSubject warns if the subject of the commit message msg is too long.
+func checkSubject(msg string) {
+	subject := strings.TrimSpace(msg)
+	if i := strings.Index(subject, "\n"); i >= 0 {
+		subject = subject[:i]
+	}
+	if n := utf8.RuneCountInString(subject); n > maxSubjectLen {
+		printf("warning: commit subject is %d characters long; keep it to %d or fewer.", n, maxSubjectLen)
+	}
+}
+
 func checkoutOrCreate(target string) {
 	// If it's a valid Gerrit number, checkout the CL.
 	cl, ps, isCL := parseCL(target)
@@ -123,12 +394,7 @@ func checkoutOrCreate(target string) {
 		return
 	}
 
-	if strings.ToUpper(target) == "HEAD" {
-		// Git gets very upset and confused if you 'git change head'
-		// on systems with case-insensitive file names: the branch
-		// head conflicts with the usual HEAD.
-		dief("invalid branch name %q: ref name HEAD is reserved for git.", target)
-	}
+	checkBranchName(target, false)
 
 	// If local branch exists, check it out.
 	for _, b := range LocalBranches() {
@@ -149,10 +415,13 @@ func checkoutOrCreate(target string) {
 	}
 
 	// Otherwise, this is a request to create a local work branch.
-	// Check for reserved names. We take everything with a dot.
-	if strings.Contains(target, ".") {
-		dief("invalid branch name %v: branch names with dots are reserved for git-codereview.", target)
-	}
+	createWorkBranch(target)
+}
+
+// createWorkBranch creates and checks out the new local work branch target
+// at HEAD, tracking the same origin branch as the current branch.
+func createWorkBranch(target string) {
+	checkBranchName(target, true)
 
 	// If the current branch has a pending commit, building
 	// on top of it will not help. Don't allow that.
@@ -162,27 +431,150 @@ func checkoutOrCreate(target string) {
 		if !b.IsLocalOnly() {
 			dief("bad repo state: branch %s is ahead of origin/%s", b.Name, b.Name)
 		}
-		dief("cannot branch from work branch; change back to %v first.", strings.TrimPrefix(b.OriginBranch(), "origin/"))
+		_, upstream := splitOriginBranch(b.OriginBranch())
+		dief("cannot branch from work branch; change back to %v first.", upstream)
 	}
 
 	origin := b.OriginBranch()
 
 	// NOTE: This is different from git checkout -q -t -b branch. It does not move HEAD.
 	run("git", "checkout", "-q", "-b", target)
-	run("git", "branch", "-q", "--set-upstream-to", origin)
+	setWorkUpstream(target, origin)
+}
+
+// createWorkBranchAt creates and checks out the new local work branch target
+// at base, which can be any commit: an origin branch, another work branch,
+// or a commit hash. The new branch tracks base itself if base is an origin
+// branch, the origin branch of base if base is a local branch, and otherwise
+// the origin branch of the current branch.
+func createWorkBranchAt(target, base string) {
+	checkBranchName(target, true)
+	if _, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/"+target); err == nil {
+		dief("cannot create branch %v: branch already exists (-base applies only to new branches)", target)
+	}
+	for _, name := range OriginBranches() {
+		if name == "origin/"+target {
+			dief("cannot create branch %v: %s already exists (-base applies only to new branches)", target, name)
+		}
+	}
+
+	start := base
+	origin := CurrentBranch().OriginBranch()
+	for _, name := range OriginBranches() {
+		if name == base || name == "origin/"+base {
+			origin = name
+		}
+	}
+	for _, b := range LocalBranches() {
+		if b.Name == base {
+			// Spell out the branch, in case a tag has the same name.
+			start = "refs/heads/" + base
+			origin = b.OriginBranch()
+		}
+	}
+	if _, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", start+"^{commit}"); err != nil {
+		dief("cannot create branch %v: base %s is not a commit", target, base)
+	}
+
+	run("git", "checkout", "-q", "-b", target, start)
+	setWorkUpstream(target, origin)
+}
+
+// createStackedBranch creates and checks out the new local work branch
+// target on top of the work branch parent, for a change that depends on
+// the changes in parent. Like parent, target tracks an origin branch,
+// so that its changes are mailed for review on the same server branch,
+// but it records parent as the branch it is stacked on, in the git config
+// setting branch.<target>.codereviewparent, so that its pending changes
+// are only those after parent's and sync rebases it onto parent.
+func createStackedBranch(target, parent string) {
+	found := false
+	for _, b := range LocalBranches() {
+		if b.Name == parent {
+			if !b.IsLocalOnly() {
+				dief("cannot stack %v on %s: not a work branch", target, parent)
+			}
+			found = true
+		}
+	}
+	if !found {
+		dief("cannot stack %v on %s: no such branch", target, parent)
+	}
+	createWorkBranchAt(target, parent)
+	run("git", "config", "branch."+target+".codereviewparent", parent)
+	printf("stacked branch %v on %s.", target, parent)
+}
+
+// setWorkUpstream sets the upstream of the new work branch target to origin,
+// deleting target if that fails.
+func setWorkUpstream(target, origin string) {
+	if err := runErr("git", "branch", "-q", "--set-upstream-to", origin); err != nil {
+		colorPrintf(colorError, "cannot create branch %v tracking %s: %v", target, origin, err)
+		// Don't leave a half-created branch behind. It was just
+		// created, so deleting it even unmerged loses nothing.
+		cleanupBranch(target, true)
+		die()
+	}
 	printf("created branch %v tracking %s.", target, origin)
 }
 
+// checkBranchName dies if name cannot be used as a branch name.
+// If work is true, name is for a new local work branch, which must also
+// avoid the names reserved for git-codereview and follow git's rules
+// for new branch names.
+func checkBranchName(name string, work bool) {
+	if strings.ToUpper(name) == "HEAD" {
+		// Git gets very upset and confused if you 'git change head'
+		// on systems with case-insensitive file names: the branch
+		// head conflicts with the usual HEAD.
+		dief("invalid branch name %q: ref name HEAD is reserved for git.", name)
+	}
+	// Check for reserved names. We take everything with a dot.
+	if work && strings.Contains(name, ".") {
+		dief("invalid branch name %v: branch names with dots are reserved for git-codereview.", name)
+	}
+	if !work {
+		return
+	}
+	// Catch names git would reject before creating anything,
+	// to give a clearer error than a failing git checkout -b.
+	// A leading dash would make the name look like a flag.
+	if strings.HasPrefix(name, "-") {
+		dief("invalid branch name %q: branch names cannot begin with a dash.", name)
+	}
+	if _, err := cmdOutputErr("git", "check-ref-format", "--branch", name); err != nil {
+		dief("invalid branch name %q: not allowed by git (see 'git help check-ref-format').", name)
+	}
+}
+
 // Checkout the patch set of the given CL. When patch set is empty, use the latest.
 func checkoutCL(cl, ps string) {
+	_, ps = fetchCL("change to", cl, ps)
+	err := runErr("git", "checkout", "-q", "FETCH_HEAD")
+	if err != nil {
+		dief("cannot change to CL %s/%s: %v", cl, ps, err)
+	}
+	subject, err := trimErr(cmdOutputErr("git", "log", "--format=%s", "-1"))
+	if err != nil {
+		printf("changed to CL %s/%s.", cl, ps)
+		dief("cannot read change subject from git: %v", err)
+	}
+	printf("changed to CL %s/%s.\n\t%s", cl, ps, subject)
+}
+
+// fetchCL fetches the patch set ps of the given CL into FETCH_HEAD,
+// returning the CL as read from Gerrit and the patch set fetched.
+// When ps is empty, it fetches the latest patch set.
+// The action, such as "change to", describes the operation in errors.
+func fetchCL(action, cl, ps string) (*GerritChange, string) {
+	change, err := readGerritChange(cl + "?o=CURRENT_REVISION")
+	if err != nil {
+		dief("cannot %s CL %s: %v", action, cl, err)
+	}
 	if ps == "" {
-		change, err := readGerritChange(cl + "?o=CURRENT_REVISION")
-		if err != nil {
-			dief("cannot change to CL %s: %v", cl, err)
-		}
 		rev, ok := change.Revisions[change.CurrentRevision]
 		if !ok {
-			dief("cannot change to CL %s: invalid current revision from gerrit", cl)
+			dief("cannot %s CL %s: invalid current revision from gerrit", action, cl)
 		}
 		ps = strconv.Itoa(rev.Number)
 	}
@@ -195,20 +587,11 @@ func checkoutCL(cl, ps string) {
 	}
 	ref := fmt.Sprintf("refs/changes/%s/%s/%s", group, cl, ps)
 
-	err := runErr("git", "fetch", "-q", "origin", ref)
+	_, err = runRemoteCaptureErr("git", "fetch", "-q", "origin", ref)
 	if err != nil {
-		dief("cannot change to CL %s/%s: %v", cl, ps, err)
+		dief("cannot %s CL %s/%s: %v", action, cl, ps, err)
 	}
-	err = runErr("git", "checkout", "-q", "FETCH_HEAD")
-	if err != nil {
-		dief("cannot change to CL %s/%s: %v", cl, ps, err)
-	}
-	subject, err := trimErr(cmdOutputErr("git", "log", "--format=%s", "-1"))
-	if err != nil {
-		printf("changed to CL %s/%s.", cl, ps)
-		dief("cannot read change subject from git: %v", err)
-	}
-	printf("changed to CL %s/%s.\n\t%s", cl, ps, subject)
+	return change, ps
 }
 
 var parseCLRE = regexp.MustCompile(`^([0-9]+)(?:/([0-9]+))?$`)
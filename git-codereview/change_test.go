@@ -6,6 +6,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"testing"
 )
@@ -40,6 +41,431 @@ func TestChange(t *testing.T) {
 	testRan(t, "git checkout -q -t -b dev.branch origin/dev.branch")
 }
 
+func TestChangeMessage(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	t.Logf("master -> work with staged changes")
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "change", "-m", "foo: message from flag", "work")
+	testRan(t, "git checkout -q -b work",
+		"git branch -q --set-upstream-to origin/master",
+		"git commit -q --allow-empty -m foo: message from flag")
+
+	t.Logf("amend with new message")
+	write(t, gt.client+"/file", "newer content")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "change", "-m", "foo: amended message")
+	testRan(t, "git commit -q --allow-empty --amend -m foo: amended message")
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%s")); msg != "foo: amended message" {
+		t.Fatalf("commit subject = %q, want %q", msg, "foo: amended message")
+	}
+}
+
+func TestChangeMessageKeepTrailers(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	const changeID = "Change-Id: I123456789"
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "change", "-m", "foo: message\n\nReviewed-by: Gopher <gopher@example.com>\n"+changeID, "work")
+
+	t.Logf("amend with -m")
+	testMain(t, "change", "-m", "foo: amended message\n\nMore detail.")
+	want := "foo: amended message\n\nMore detail.\n\nReviewed-by: Gopher <gopher@example.com>\n" + changeID
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != want {
+		t.Fatalf("commit message = %q, want %q", msg, want)
+	}
+
+	t.Logf("amend with -F and a trailer of its own")
+	write(t, gt.client+"/msg.txt", "foo: message from file\n\nSigned-off-by: Gopher <gopher@example.com>\n")
+	testMain(t, "change", "-F", "msg.txt")
+	want = "foo: message from file\n\nSigned-off-by: Gopher <gopher@example.com>\nReviewed-by: Gopher <gopher@example.com>\n" + changeID
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != want {
+		t.Fatalf("commit message = %q, want %q", msg, want)
+	}
+
+	t.Logf("amend with a new Change-Id")
+	testMain(t, "change", "-m", "foo: new change\n\nChange-Id: I987654321")
+	want = "foo: new change\n\nChange-Id: I987654321\nSigned-off-by: Gopher <gopher@example.com>\nReviewed-by: Gopher <gopher@example.com>"
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != want {
+		t.Fatalf("commit message = %q, want %q", msg, want)
+	}
+}
+
+func TestChangeMessageFile(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.client+"/msg.txt", "foo: message from file\n\nMore detail.\n")
+	write(t, gt.client+"/empty.txt", "\n\n")
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+
+	testMainDied(t, "change", "-m", "foo: message", "-F", "msg.txt", "work")
+	testPrintedStderr(t, "cannot use both -m and -F")
+	testMainDied(t, "change", "-F", "missing.txt", "work")
+	testPrintedStderr(t, "reading commit message:", "missing.txt")
+	testMainDied(t, "change", "-F", "empty.txt", "work")
+	testPrintedStderr(t, "cannot use commit message from empty.txt: message is empty")
+	testRan(t)
+
+	testMain(t, "change", "-F", "msg.txt", "work")
+	testRan(t, "git checkout -q -b work",
+		"git branch -q --set-upstream-to origin/master",
+		"git commit -q --allow-empty -F msg.txt")
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != "foo: message from file\n\nMore detail." {
+		t.Fatalf("commit message = %q, want message from file", msg)
+	}
+}
+
+func TestChangeNormalizeMessage(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "change", "-m", " \n\t", "work")
+	testPrintedStderr(t, "cannot use commit message: message is empty")
+
+	testMain(t, "change", "-m", "\nfoo: tidy  \nMore detail.\t\n\n", "work")
+	testRan(t, "git checkout -q -b work",
+		"git branch -q --set-upstream-to origin/master",
+		"git commit -q --allow-empty -m foo: tidy\n\nMore detail.")
+	testPrintedStderr(t, "!warning: commit subject")
+
+	// A message file that needs tidying is passed on with -m.
+	long := "foo: " + strings.Repeat("long ", 14)
+	write(t, gt.client+"/msg.txt", long+"\nMore detail.\n")
+	testMain(t, "change", "-F", "msg.txt")
+	testRan(t, "git commit -q --allow-empty --amend -m "+strings.TrimSpace(long)+"\n\nMore detail.")
+	testPrintedStderr(t, "warning: commit subject is 74 characters long; keep it to 72 or fewer.")
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	for _, tt := range []struct {
+		in, out string
+	}{
+		{"foo: bar", "foo: bar"},
+		{"foo: bar  \n", "foo: bar"},
+		{"\n\n  \nfoo: bar\n\nbody \nmore\t\n\n", "foo: bar\n\nbody\nmore"},
+		{"foo: bar\nbody\n\nmore", "foo: bar\n\nbody\n\nmore"},
+		{"foo: bar\r\n\r\nbody\r\n", "foo: bar\n\nbody"},
+		{" \n\t\n", ""},
+	} {
+		if out := normalizeMessage(tt.in); out != tt.out {
+			t.Errorf("normalizeMessage(%q) = %q, want %q", tt.in, out, tt.out)
+		}
+	}
+}
+
+func TestChangeSignoff(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "change", "-s", "-m", "foo: signed", "work")
+	testRan(t, "git checkout -q -b work",
+		"git branch -q --set-upstream-to origin/master",
+		"git commit -q --allow-empty -m foo: signed -s")
+
+	msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B"))
+	if !strings.HasSuffix(msg, "\n\nSigned-off-by: gopher <gopher@example.com>") {
+		t.Fatalf("commit message:\n%s\nwant Signed-off-by trailer", msg)
+	}
+
+	// codereview.signoff makes -s the default.
+	trun(t, gt.client, "git", "config", "codereview.signoff", "true")
+	write(t, gt.client+"/file", "newer content")
+	testMain(t, "change", "-a", "-q")
+	testRan(t, "git commit -q --allow-empty --amend --no-edit -m foo: my commit msg -a -s")
+	testMain(t, "change", "-a", "-q", "-s=false")
+	testRan(t, "git commit -q --allow-empty --amend --no-edit -m foo: my commit msg -a")
+}
+
+func TestChangeStale(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	gt.serverWorkUnrelated(t)
+	gt.serverWorkUnrelated(t)
+	trun(t, gt.client, "git", "fetch", "-q")
+
+	// Two commits behind is under the default threshold.
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "!behind")
+
+	trun(t, gt.client, "git", "config", "codereview.stalethreshold", "1")
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "warning: 2 commits behind origin/master; run 'git sync' to update.")
+
+	trun(t, gt.client, "git", "config", "codereview.stalethreshold", "0")
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "!behind")
+
+	trun(t, gt.client, "git", "config", "codereview.stalethreshold", "lots")
+	testMainDied(t, "change", "-q")
+	testPrintedStderr(t, "invalid codereview.stalethreshold setting")
+}
+
+func TestChangeAmendNoop(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	defer func(msg string) { testCommitMsg = msg }(testCommitMsg)
+	testCommitMsg = ""
+
+	write(t, gt.client+"/file", "more")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "change", "-v", "-q")
+	testPrintedStderr(t, "amended ", ": msg", "!amend only reset the commit date")
+
+	testMain(t, "change", "-q")
+	testPrintedStderr(t, "warning: no changes added and commit message unchanged")
+
+	testMain(t, "change", "-m", "foo: new message\n\nChange-Id: I123456789")
+	testPrintedStderr(t, "!amend only reset")
+}
+
+func TestChangeDates(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	defer func(msg string) { testCommitMsg = msg }(testCommitMsg)
+	testCommitMsg = ""
+
+	// Backdate the pending change.
+	const old = "946684800 +0000"
+	os.Setenv("GIT_COMMITTER_DATE", old)
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-edit", "--date="+old)
+	os.Unsetenv("GIT_COMMITTER_DATE")
+	dates := func() string {
+		return trim(trun(t, gt.client, "git", "log", "-n", "1", "--date=raw", "--format=%ad|%cd"))
+	}
+
+	testMainDied(t, "change", "-keep-date", "-reset-date")
+	testPrintedStderr(t, "cannot use both -keep-date and -reset-date")
+
+	write(t, gt.client+"/file", "keep")
+	testMain(t, "change", "-a", "-q", "-keep-date")
+	if d := dates(); d != old+"|"+old {
+		t.Fatalf("after change -keep-date, dates = %s, want %s for both", d, old)
+	}
+	head := CurrentBranch().Pending()[0].Hash
+	testMain(t, "change", "-q", "-keep-date")
+	testPrintedStderr(t, "amend did nothing")
+	if hash := trim(trun(t, gt.client, "git", "rev-parse", "HEAD")); hash != head {
+		t.Fatalf("change -keep-date without changes made new commit %s, want %s", hash, head)
+	}
+	if _, ok := os.LookupEnv("GIT_COMMITTER_DATE"); ok {
+		t.Fatalf("change -keep-date left GIT_COMMITTER_DATE set")
+	}
+
+	// By default, only the committer date changes.
+	write(t, gt.client+"/file", "default")
+	testMain(t, "change", "-a", "-q")
+	if d := dates(); !strings.HasPrefix(d, old+"|") || strings.HasSuffix(d, "|"+old) {
+		t.Fatalf("after change, dates = %s, want only author date %s", d, old)
+	}
+
+	write(t, gt.client+"/file", "reset")
+	testMain(t, "change", "-a", "-q", "-reset-date")
+	testRan(t, "git commit -q --allow-empty --amend --no-edit --date=now -a")
+	if d := dates(); strings.Contains(d, old) {
+		t.Fatalf("after change -reset-date, dates = %s, want both reset", d)
+	}
+
+	testMain(t, "change", "master")
+	testMain(t, "change", "other")
+	testMainDied(t, "change", "-keep-date", "-q")
+	testPrintedStderr(t, "no pending change to amend")
+}
+
+func TestChangeNoTerminal(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	defer func(msg string) { testCommitMsg = msg }(testCommitMsg)
+	testCommitMsg = ""
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+	if old, ok := os.LookupEnv("GIT_EDITOR"); ok {
+		defer os.Setenv("GIT_EDITOR", old)
+	} else {
+		defer os.Unsetenv("GIT_EDITOR")
+	}
+	os.Unsetenv("GIT_EDITOR")
+
+	testMain(t, "change", "work")
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "change")
+	testPrintedStderr(t, "cannot commit change: no message provided and no terminal for the editor", "$GIT_EDITOR")
+	testRan(t)
+
+	testMain(t, "change", "-m", "foo: new content")
+	testMainDied(t, "edit-message")
+	testPrintedStderr(t, "cannot edit commit message: no message provided and no terminal")
+
+	// An amend with -q does not edit the message.
+	write(t, gt.client+"/file", "newer content")
+	testMain(t, "change", "-a", "-q")
+
+	// $GIT_EDITOR allows the editor anyway.
+	os.Setenv("GIT_EDITOR", "true")
+	write(t, gt.client+"/file", "newest content")
+	testMain(t, "change", "-a")
+	testRan(t, "git commit -q --allow-empty --amend -a")
+}
+
+func TestChangeFiles(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	defer func(msg string) { testCommitMsg = msg }(testCommitMsg)
+	testCommitMsg = ""
+
+	write(t, gt.client+"/file", "amended")
+	write(t, gt.client+"/newfile", "new")
+	write(t, gt.client+"/staged", "staged")
+	trun(t, gt.client, "git", "add", "staged")
+
+	testMain(t, "change", "-q", "-files", "file", "newfile", "otherfile")
+	testPrintedStderr(t, "warning: otherfile has no changes; skipping.")
+	testRan(t,
+		"git add -- file newfile",
+		"git commit -q --allow-empty --amend --no-edit -- file newfile")
+	files := strings.Join(ListFiles(CurrentBranch().Pending()[0]), " ")
+	if files != "file newfile" {
+		t.Fatalf("amended change has files %q, want %q", files, "file newfile")
+	}
+	if staged := trim(trun(t, gt.client, "git", "diff", "--cached", "--name-only")); staged != "staged" {
+		t.Fatalf("after change -files, staged = %q, want %q", staged, "staged")
+	}
+
+	testMainDied(t, "change", "-files", "file", "otherfile")
+	testPrintedStderr(t, "cannot amend change: none of the files have changes")
+
+	trun(t, gt.client, "git", "reset", "-q", "--hard")
+	testMain(t, "change", "master")
+	write(t, gt.client+"/file", "master")
+	testMainDied(t, "change", "-files", "file")
+	testPrintedStderr(t, "can't commit to master branch")
+
+	testMain(t, "change", "other")
+	testMainDied(t, "change", "-files", "file")
+	testPrintedStderr(t, "cannot use -files: no pending change to amend")
+}
+
+func TestChangeBase(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	work := CurrentBranch().Pending()[0].Hash
+
+	t.Logf("from a work branch")
+	testMain(t, "change", "-base", "work", "stacked")
+	testRan(t,
+		"git checkout -q -b stacked refs/heads/work",
+		"git branch -q --set-upstream-to origin/master")
+	checkCurrentBranch(t, "stacked", "origin/master", true, true, "I123456789", "msg")
+
+	t.Logf("from an origin branch")
+	testMain(t, "change", "-base", "origin/dev.branch", "devwork")
+	testRan(t,
+		"git checkout -q -b devwork origin/dev.branch",
+		"git branch -q --set-upstream-to origin/dev.branch")
+	checkCurrentBranch(t, "devwork", "origin/dev.branch", true, false, "", "")
+
+	t.Logf("from a commit")
+	testMain(t, "change", "-base", work[:7]+"^", "fromhash")
+	testRan(t,
+		"git checkout -q -b fromhash "+work[:7]+"^",
+		"git branch -q --set-upstream-to origin/dev.branch")
+
+	testMainDied(t, "change", "-base", "master", "work")
+	testPrintedStderr(t, "cannot create branch work: branch already exists")
+	testMainDied(t, "change", "-base", "master", "dev.branch")
+	testPrintedStderr(t, "branch names with dots are reserved")
+	testMainDied(t, "change", "-base", "nosuchref", "fresh")
+	testPrintedStderr(t, "cannot create branch fresh: base nosuchref is not a commit")
+	checkLocalBranches(t, "devwork", "fromhash", "master", "stacked", "work")
+}
+
+func TestChangeUpstreamFails(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// A locked configuration file keeps git from recording the upstream
+	// of the new branch, which is then deleted again.
+	write(t, gt.client+"/.git/config.lock", "")
+	testMainDied(t, "change", "work")
+	os.Remove(gt.client + "/.git/config.lock")
+	testPrintedStderr(t, "cannot create branch work tracking origin/master", "deleted branch work.")
+	if i, j := strings.Index(testStderr.String(), "cannot create branch"), strings.Index(testStderr.String(), "deleted branch"); i > j {
+		t.Errorf("cleanup reported before the error:\n%s", testStderr)
+	}
+	checkLocalBranches(t, "master")
+}
+
+func TestChangeOn(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	work := CurrentBranch().Pending()[0].Hash
+
+	testMain(t, "change", "-on", "work", "second")
+	testRan(t,
+		"git checkout -q -b second refs/heads/work",
+		"git branch -q --set-upstream-to origin/master",
+		"git config branch.second.codereviewparent work")
+	testPrintedStderr(t, "stacked branch second on work.")
+	b := CurrentBranch()
+	if b.Parent() != "work" || b.HasPendingCommit() || b.Branchpoint() != work {
+		t.Fatalf("new stacked branch: parent %q, pending %d, branchpoint %s; want work, 0, %s", b.Parent(), len(b.Pending()), b.Branchpoint(), work)
+	}
+
+	// Only the stacked branch's own commit is pending.
+	write(t, gt.client+"/file2", "second content")
+	trun(t, gt.client, "git", "add", "file2")
+	testMain(t, "change", "-m", "foo: second")
+	b = CurrentBranch()
+	if len(b.Pending()) != 1 || b.Pending()[0].Parent != work {
+		t.Fatalf("stacked branch has %d pending; want 1 on top of work", len(b.Pending()))
+	}
+	testMain(t, "pending", "-c", "-l", "-s")
+	testPrintedStdout(t, "second", "stacked on work")
+
+	testMainDied(t, "change", "-on", "master", "third")
+	testPrintedStderr(t, "cannot stack third on master: not a work branch")
+	testMainDied(t, "change", "-on", "nosuch", "third")
+	testPrintedStderr(t, "cannot stack third on nosuch: no such branch")
+	checkLocalBranches(t, "master", "second", "work")
+
+	// Renaming the parent keeps the branch stacked on it.
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	testMain(t, "rename", "first")
+	if p := (&Branch{Name: "second"}).Parent(); p != "first" {
+		t.Fatalf("after renaming parent, second is stacked on %q, want first", p)
+	}
+
+	// Without the parent branch, the branch is no longer stacked.
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+	trun(t, gt.client, "git", "branch", "-q", "-D", "first")
+	if b := (&Branch{Name: "second"}); b.Parent() != "" || len(b.Pending()) != 2 {
+		t.Fatalf("after deleting parent, second is stacked on %q with %d pending, want none and 2", b.Parent(), len(b.Pending()))
+	}
+}
+
 func TestChangeHEAD(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -48,6 +474,20 @@ func TestChangeHEAD(t *testing.T) {
 	testPrintedStderr(t, "invalid branch name \"HeAd\": ref name HEAD is reserved for git")
 }
 
+func TestChangeBadName(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	for _, name := range []string{"has space", "x~1", "a:b", "end/", "bad@{x"} {
+		testMainDied(t, "change", name)
+		testPrintedStderr(t, "invalid branch name \""+name+"\": not allowed by git")
+		testNoStdout(t)
+	}
+	testMainDied(t, "change", "--", "-dash")
+	testPrintedStderr(t, "invalid branch name \"-dash\": branch names cannot begin with a dash")
+	checkLocalBranches(t, "master")
+}
+
 func TestChangeAhead(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
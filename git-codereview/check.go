@@ -0,0 +1,60 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "fmt"
+
+// cmdCheck checks the current branch against the invariants of the
+// one-commit-per-branch workflow, such as that the branch holds exactly
+// one pending commit, which a stray 'git commit' easily breaks.
+func cmdCheck(args []string) {
+	expectZeroArgs(args, "check")
+
+	// Run every check before failing, so that one command
+	// shows all the problems at once.
+	ok := true
+	show := func(pass bool, what, why string) {
+		if pass {
+			fmt.Fprintf(stdout(), "ok   %s\n", what)
+			return
+		}
+		ok = false
+		fmt.Fprintf(stdout(), "FAIL %s (%s)\n", what, why)
+	}
+
+	b := CurrentBranch()
+	onWork := !b.DetachedHead() && b.IsLocalOnly()
+	why := "on " + b.Name + " branch"
+	if b.DetachedHead() {
+		why = "HEAD is detached"
+	}
+	show(onWork, "on a work branch", why)
+
+	var work []*Commit
+	if onWork {
+		work = b.Pending()
+	}
+	show(len(work) == 1, "one commit ahead of "+b.OriginBranch(), fmt.Sprintf("have %d", len(work)))
+
+	var noID, merges []string
+	for _, c := range work {
+		if c.ChangeID == "" {
+			noID = append(noID, c.ShortHash)
+		}
+		if c.Merge != "" {
+			merges = append(merges, c.ShortHash)
+		}
+	}
+	show(len(noID) == 0, "Change-Id present", "missing in "+fmt.Sprint(noID))
+	show(len(merges) == 0, "no merge commits", "merges "+fmt.Sprint(merges))
+
+	staged, unstaged, _ := LocalChanges()
+	show(len(staged) == 0 && len(unstaged) == 0, "clean working tree",
+		fmt.Sprintf("%d staged, %d unstaged", len(staged), len(unstaged)))
+
+	if !ok {
+		die()
+	}
+}
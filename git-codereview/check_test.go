@@ -0,0 +1,34 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "check")
+	testPrintedStdout(t, "FAIL on a work branch (on master branch)", "FAIL one commit ahead of origin/master (have 0)")
+
+	gt.work(t)
+	testMain(t, "check")
+	testPrintedStdout(t,
+		"ok   on a work branch",
+		"ok   one commit ahead of origin/master",
+		"ok   Change-Id present",
+		"ok   no merge commits",
+		"ok   clean working tree")
+
+	// A second commit made with plain git commit, without a Change-Id.
+	write(t, gt.client+"/file", "more")
+	trun(t, gt.client, "git", "add", "file")
+	testMainDied(t, "check")
+	testPrintedStdout(t, "ok   one commit ahead", "FAIL clean working tree (1 staged, 0 unstaged)")
+
+	trun(t, gt.client, "git", "commit", "--no-verify", "-m", "stray commit")
+	testMainDied(t, "check")
+	testPrintedStdout(t, "FAIL one commit ahead of origin/master (have 2)", "FAIL Change-Id present (missing in [", "ok   clean working tree")
+}
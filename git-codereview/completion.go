@@ -0,0 +1,146 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// cmdCompletion prints a shell completion script.
+// It is not listed in the help text; the script's header says how to use it.
+func cmdCompletion(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s completion %s bash|zsh\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	var header string
+	switch shell := flags.Arg(0); shell {
+	case "bash":
+		header = bashCompletionHeader
+	case "zsh":
+		header = zshCompletionHeader
+	default:
+		dief("unknown shell %q (want bash or zsh)", shell)
+	}
+
+	cmds, cmdFlags := completionWords()
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	fmt.Fprintf(&buf, "_git_codereview_commands=%q\n", strings.Join(cmds, " "))
+	buf.WriteString("_git_codereview_flags() {\n\tcase $1 in\n")
+	for _, cmd := range cmds {
+		if f := cmdFlags[cmd]; len(f) > 0 {
+			fmt.Fprintf(&buf, "\t%s) echo %q ;;\n", cmd, strings.Join(f, " "))
+		}
+	}
+	buf.WriteString("\tesac\n}\n")
+	buf.WriteString(completionScript)
+	stdout().Write(buf.Bytes())
+}
+
+// Matches a command line in the "Available commands" section of help.
+var helpCommandRE = regexp.MustCompile(`^\t([a-z][a-z-]*)(.*)$`)
+
+// Matches a flag in a command line in help.
+var helpFlagRE = regexp.MustCompile(`(^|[\s\[|])(-[a-z][a-z-]*)`)
+
+// completionWords returns the sorted command names listed in help,
+// along with the sorted flags of each.
+func completionWords() (cmds []string, cmdFlags map[string][]string) {
+	cmdFlags = map[string][]string{}
+	text := help[strings.Index(help, "Available commands:"):strings.Index(help, "Environment Variables:")]
+	for _, line := range lines(text) {
+		m := helpCommandRE.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		cmd := m[1]
+		if _, ok := cmdFlags[cmd]; !ok {
+			cmds = append(cmds, cmd)
+			cmdFlags[cmd] = nil
+		}
+		for _, f := range helpFlagRE.FindAllStringSubmatch(m[2], -1) {
+			cmdFlags[cmd] = appendNew(cmdFlags[cmd], f[2])
+		}
+	}
+	sort.Strings(cmds)
+	for _, f := range cmdFlags {
+		sort.Strings(f)
+	}
+	return cmds, cmdFlags
+}
+
+// appendNew appends s to list if it is not already there.
+func appendNew(list []string, s string) []string {
+	for _, x := range list {
+		if x == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+const bashCompletionHeader = `# bash completion for git-codereview.
+#
+# To use it in the current shell, run
+#
+#	source <(git-codereview completion bash)
+#
+# or add that line to ~/.bashrc to use it in every shell.
+# Completion works for both "git-codereview" and "git codereview"
+# (the latter when git's own bash completion is loaded).
+
+`
+
+const zshCompletionHeader = `# zsh completion for git-codereview.
+#
+# To use it in the current shell, run
+#
+#	source <(git-codereview completion zsh)
+#
+# or add that line to ~/.zshrc to use it in every shell.
+# The script uses zsh's emulation of bash completion.
+
+autoload -U +X bashcompinit && bashcompinit
+
+`
+
+const completionScript = `
+_git_codereview() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd= i
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-* | codereview) ;;
+		*) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+	if [ -z "$cmd" ]; then
+		COMPREPLY=($(compgen -W "$_git_codereview_commands -n -v -no-color -quiet -trace -y" -- "$cur"))
+		return
+	fi
+	case $cur in
+	-*)
+		COMPREPLY=($(compgen -W "$(_git_codereview_flags "$cmd") -n -v -no-color -quiet -trace -y" -- "$cur"))
+		return
+		;;
+	esac
+	case $cmd in
+	change)
+		COMPREPLY=($(compgen -W "$(git for-each-ref --format='%(refname:short)' refs/heads 2>/dev/null)" -- "$cur"))
+		;;
+	esac
+}
+
+complete -o default -F _git_codereview git-codereview
+`
@@ -0,0 +1,66 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompletionWords(t *testing.T) {
+	cmds, cmdFlags := completionWords()
+	for _, cmd := range []string{"abandon", "change", "hooks", "mail", "pending", "sync"} {
+		if _, ok := cmdFlags[cmd]; !ok {
+			t.Errorf("command %s missing from %v", cmd, cmds)
+		}
+	}
+	wantFlags := map[string][]string{
+		"hooks":  {"-reinstall", "-status", "-uninstall"},
+		"mail":   {"-against", "-as", "-cc", "-color-words", "-diff", "-f", "-for", "-force", "-label", "-m", "-name-only", "-no-verify", "-open", "-owners", "-r", "-ready", "-remote", "-staged", "-stat", "-topic", "-trybot", "-wip", "-word-diff", "-worktree"},
+		"change": {"-a", "-as", "-base", "-files", "-keep-date", "-m", "-on", "-q", "-reset-date", "-s"},
+		"sync":   {"-abort", "-all", "-continue", "-fetch-only", "-i", "-merge", "-no-autostash", "-onto", "-preserve-chain", "-stat"},
+	}
+	for cmd, want := range wantFlags {
+		if got := cmdFlags[cmd]; !reflect.DeepEqual(got, want) {
+			t.Errorf("flags for %s = %v, want %v", cmd, got, want)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	for _, shell := range []string{"bash", "zsh"} {
+		testMain(t, "completion", shell)
+		out := testStdout.String()
+		for _, want := range []string{
+			"completion " + shell + ")",
+			`_git_codereview_commands="abandon branch-from-change change`,
+			`mail) echo "-`,
+			` -cc -color-words -diff `,
+			"for-each-ref",
+			"complete -o default -F _git_codereview git-codereview",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%s completion missing %q:\n%s", shell, want, out)
+			}
+		}
+		if shell == "bash" {
+			if _, err := exec.LookPath("bash"); err == nil {
+				cmd := exec.Command("bash", "-n")
+				cmd.Stdin = strings.NewReader(out)
+				if msg, err := cmd.CombinedOutput(); err != nil {
+					t.Errorf("bash -n: %v\n%s", err, msg)
+				}
+			}
+		}
+	}
+
+	testMainDied(t, "completion", "fish")
+	testPrintedStderr(t, `unknown shell "fish"`)
+}
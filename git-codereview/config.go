@@ -7,13 +7,19 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
-	configPath   string
-	cachedConfig map[string]string
+	configPath      string
+	cachedConfig    map[string]string
+	cachedGitConfig map[string]string // personal settings, by lower-case name
+	gitConfigOnce   sync.Once         // loads cachedGitConfig
 )
 
 // Config returns the code review config.
@@ -40,12 +46,237 @@ func config() map[string]string {
 	return cachedConfig
 }
 
+// loadGitConfig returns the personal codereview.<name> settings from the
+// git configuration, keyed by lower-case name, as git treats names without
+// regard to case. It reads them all with a single git command the first
+// time it is called, rather than running git config for each setting.
+// It is safe to call from multiple goroutines.
+func loadGitConfig() map[string]string {
+	gitConfigOnce.Do(readGitConfig)
+	return cachedGitConfig
+}
+
+func readGitConfig() {
+	cachedGitConfig = make(map[string]string)
+	// With -z, each entry is the key, a newline, and the value,
+	// or just the key for a setting written without a value,
+	// which git treats as a boolean true.
+	// It fails with status 1 if nothing matches.
+	out, err := cmdOutputErr("git", "config", "-z", "--get-regexp", `^codereview\.`)
+	if err != nil {
+		return
+	}
+	for _, entry := range strings.Split(out, "\x00") {
+		if entry == "" {
+			continue
+		}
+		key, value := entry, "true"
+		if i := strings.Index(entry, "\n"); i >= 0 {
+			key, value = entry[:i], entry[i+1:]
+		}
+		// Like git config --get, let the last value win.
+		cachedGitConfig[strings.ToLower(strings.TrimPrefix(key, "codereview."))] = value
+	}
+}
+
+// gitConfig returns the value of the git config setting codereview.<key>,
+// or "" if it is not set. Unlike the project-wide settings in codereview.cfg,
+// these settings are personal: they live in the user's git configuration.
+func gitConfig(key string) string {
+	return trim(loadGitConfig()[strings.ToLower(key)])
+}
+
+// gitConfigDefault is like gitConfig, but if the setting is not set,
+// it returns the default value listed in settings.
+func gitConfigDefault(key string) string {
+	if value := gitConfig(key); value != "" {
+		return value
+	}
+	if s := lookupSetting(key); s != nil {
+		return s.def
+	}
+	return ""
+}
+
+// gitConfigBool returns the boolean git config setting codereview.<key>,
+// or def if it is not set or not a valid boolean.
+func gitConfigBool(key string, def bool) bool {
+	value, ok := loadGitConfig()[strings.ToLower(key)]
+	if !ok {
+		return def
+	}
+	if b, ok := parseGitBool(value); ok {
+		return b
+	}
+	return def
+}
+
+// parseGitBool parses value as a boolean the way git config --bool does:
+// true, yes, on, and any integer other than 0 are true, and false, no,
+// off, 0, and the empty string are false, all without regard to case.
+// The integer may have a k, m, or g suffix, as in git.
+// It returns ok == false if value is not a boolean.
+func parseGitBool(value string) (b, ok bool) {
+	value = strings.ToLower(trim(value))
+	switch value {
+	case "true", "yes", "on":
+		return true, true
+	case "false", "no", "off", "":
+		return false, true
+	}
+	num := value
+	if i := len(num) - 1; strings.IndexByte("kmg", num[i]) >= 0 {
+		num = num[:i]
+	}
+	n, err := strconv.ParseInt(num, 0, 64)
+	if err != nil {
+		return false, false
+	}
+	return n != 0, true
+}
+
+// setGitConfig sets the git config setting codereview.<key> to value,
+// both in the git configuration and in the settings already loaded.
+func setGitConfig(key, value string) {
+	run("git", "config", "codereview."+key, value)
+	if !*noRun {
+		loadGitConfig()[strings.ToLower(key)] = value
+	}
+}
+
+// A setting describes a personal git config setting codereview.<name>.
+type setting struct {
+	name string
+	kind string // "bool", "int" (a non-negative integer), "identity" ("Name <email>"), or "string"
+	def  string // default value
+}
+
+// settings lists the known personal settings, in alphabetical order.
+var settings = []setting{
+	{"autohooks", "bool", "true"},
+	{"backend", "string", "gerrit"},
+	{"branch", "string", "master"},
+	{"identity", "identity", ""},
+	{"postmailhook", "string", ""},
+	{"remote", "string", "origin"},
+	{"signoff", "bool", "false"},
+	{"stalethreshold", "int", "50"},
+	{"timeout", "int", "120"},
+	{"topicfrombranch", "bool", "false"},
+	{"uploadretries", "int", "3"},
+}
+
+func cmdConfig(args []string) {
+	list := flags.Bool("list", false, "list all settings")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s config %s [-list | name [value]]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 2 || *list && len(flags.Args()) > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if len(flags.Args()) == 0 {
+		// Settings from the git configuration, set or not.
+		for _, s := range settings {
+			if value := gitConfig(s.name); value != "" {
+				fmt.Fprintf(stdout(), "codereview.%s=%s\n", s.name, value)
+			} else {
+				fmt.Fprintf(stdout(), "codereview.%s=%s (default)\n", s.name, s.def)
+			}
+		}
+		// Settings from codereview.cfg, which config cannot change.
+		cfg := config()
+		var keys []string
+		for key := range cfg {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(stdout(), "%s=%s (codereview.cfg)\n", key, cfg[key])
+		}
+		return
+	}
+
+	name := strings.TrimPrefix(flags.Arg(0), "codereview.")
+	// A project setting overrides a personal one of the same name.
+	if _, ok := config()[name]; ok {
+		dief("%s is a project setting; edit %s to change it", name, configPath)
+	}
+	s := lookupSetting(name)
+	if s == nil {
+		printf("warning: unknown setting codereview.%s", name)
+	}
+	if len(flags.Args()) == 1 {
+		value := gitConfig(name)
+		if value == "" && s != nil {
+			value = s.def
+		}
+		if value != "" {
+			fmt.Fprintf(stdout(), "%s\n", value)
+		}
+		return
+	}
+
+	value := flags.Arg(1)
+	if s != nil {
+		if err := s.check(value); err != nil {
+			dief("invalid value for codereview.%s: %v", name, err)
+		}
+	}
+	setGitConfig(name, value)
+}
+
+// lookupSetting returns the known setting with the given name, or nil.
+func lookupSetting(name string) *setting {
+	for i := range settings {
+		if settings[i].name == name {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// check reports whether value is valid for the setting s.
+func (s *setting) check(value string) error {
+	switch s.kind {
+	case "bool":
+		if _, ok := parseGitBool(value); !ok {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+	case "int":
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("%q is not a non-negative integer", value)
+		}
+	case "identity":
+		if _, _, err := parseIdentity(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upstreamBranch returns the name of the upstream integration branch
+// for the repository, as set by the "branch" key in codereview.cfg
+// or else by the codereview.branch setting.
+// If no branch is configured, it returns "master".
+func upstreamBranch() string {
+	if branch := config()["branch"]; branch != "" {
+		return branch
+	}
+	return gitConfigDefault("branch")
+}
+
 // haveGerrit returns true if gerrit should be used.
 // To enable gerrit, codereview.cfg must be present with "gerrit" property set to
-// the gerrit https URL or the git origin must be to
-// "https://<project>.googlesource.com/<repo>".
+// the gerrit https URL, or a .gitreview file must name the gerrit host,
+// or the git origin must be to "https://<project>.googlesource.com/<repo>".
 func haveGerrit() bool {
 	gerrit := config()["gerrit"]
+	if gerrit == "" && loadGitReview() != nil {
+		return true
+	}
 	origin := trim(cmdOutput("git", "config", "remote.origin.url"))
 	return haveGerritInternal(gerrit, origin)
 }
@@ -5,7 +5,9 @@
 package main
 
 import (
+	"os"
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -32,3 +34,131 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestConfigCommand(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "config")
+	testPrintedStdout(t,
+		"codereview.autohooks=true (default)\n",
+		"codereview.uploadretries=3 (default)\n")
+
+	testMain(t, "config", "uploadretries", "5")
+	testRan(t, "git config codereview.uploadretries 5")
+	testMain(t, "config", "codereview.uploadretries")
+	testPrintedStdout(t, "5\n")
+	testMain(t, "config", "-list")
+	testPrintedStdout(t, "codereview.uploadretries=5\n", "!codereview.uploadretries=5 (default)")
+
+	testMain(t, "config", "topicfrombranch")
+	testPrintedStdout(t, "false\n")
+
+	testMainDied(t, "config", "autohooks", "maybe")
+	testPrintedStderr(t, `invalid value for codereview.autohooks: "maybe" is not a boolean`)
+	testMainDied(t, "config", "uploadretries", "-1")
+	testPrintedStderr(t, `"-1" is not a non-negative integer`)
+
+	testMain(t, "config", "autohook", "false")
+	testPrintedStderr(t, "warning: unknown setting codereview.autohook")
+	testRan(t, "git config codereview.autohook false")
+
+	t.Logf("project settings")
+	write(t, gt.client+"/codereview.cfg", "branch: dev.branch\n")
+	testMain(t, "config")
+	testPrintedStdout(t, "branch=dev.branch (codereview.cfg)\n")
+	testMainDied(t, "config", "branch", "master")
+	testPrintedStderr(t, "branch is a project setting; edit ", "codereview.cfg to change it")
+}
+
+func TestGitConfig(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.client, "git", "config", "codereview.Remote", "review")
+	trun(t, gt.client, "git", "config", "--add", "codereview.postmailhook", "echo first")
+	trun(t, gt.client, "git", "config", "--add", "codereview.postmailhook", "echo second\nline")
+	trun(t, gt.client, "git", "config", "codereview.signoff", "off")
+	// A setting without a value is a boolean true.
+	f, err := os.OpenFile(gt.client+"/.git/config", os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("[codereview]\n\ttopicfrombranch\n")
+	f.Close()
+
+	cachedGitConfig = nil
+	gitConfigOnce = sync.Once{}
+	for _, tt := range []struct{ key, want string }{
+		{"remote", "review"},
+		{"REMOTE", "review"},
+		{"postmailhook", "echo second\nline"},
+		{"timeout", ""},
+	} {
+		if got := gitConfig(tt.key); got != tt.want {
+			t.Errorf("gitConfig(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+	if got := gitConfigDefault("timeout"); got != "120" {
+		t.Errorf("gitConfigDefault(timeout) = %q, want 120", got)
+	}
+	if !gitConfigBool("topicfrombranch", false) || gitConfigBool("signoff", true) || !gitConfigBool("autohooks", true) {
+		t.Errorf("gitConfigBool = %v, %v, %v, want true, false, true",
+			gitConfigBool("topicfrombranch", false), gitConfigBool("signoff", true), gitConfigBool("autohooks", true))
+	}
+
+	// Like git config --bool, accept git's other spellings of booleans.
+	loadGitConfig()["signoff"] = "2"
+	if !gitConfigBool("signoff", false) {
+		t.Errorf("gitConfigBool with codereview.signoff=2 = false, want true")
+	}
+	delete(loadGitConfig(), "signoff")
+
+	// Settings are read once and then kept up to date.
+	*trace = true
+	defer func() { *trace = false; traceLog = nil }()
+	traceLog = nil
+	gitConfig("remote")
+	gitConfigBool("autohooks", true)
+	if len(traceLog) != 0 {
+		t.Errorf("reading loaded settings ran %d commands, want none", len(traceLog))
+	}
+	setGitConfig("timeout", "30")
+	if got := gitConfig("timeout"); got != "30" {
+		t.Errorf("after setGitConfig, gitConfig(timeout) = %q, want 30", got)
+	}
+	if got := trim(trun(t, gt.client, "git", "config", "codereview.timeout")); got != "30" {
+		t.Errorf("after setGitConfig, git config codereview.timeout = %q, want 30", got)
+	}
+}
+
+func TestParseGitBool(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		b, ok bool
+	}{
+		{"true", true, true},
+		{"True", true, true},
+		{"YES", true, true},
+		{"on", true, true},
+		{"1", true, true},
+		{"2", true, true},
+		{"-1", true, true},
+		{"1k", true, true},
+		{"0x10", true, true},
+		{"false", false, true},
+		{"Off", false, true},
+		{"no", false, true},
+		{"0", false, true},
+		{"0m", false, true},
+		{"", false, true},
+		{"maybe", false, false},
+		{"1kk", false, false},
+		{"k", false, false},
+	} {
+		b, ok := parseGitBool(tt.value)
+		if b != tt.b || ok != tt.ok {
+			t.Errorf("parseGitBool(%q) = %v, %v, want %v, %v", tt.value, b, ok, tt.b, tt.ok)
+		}
+	}
+}
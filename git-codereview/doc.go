@@ -30,13 +30,25 @@ This document uses the first form for clarity but most users install these
 aliases in their .gitconfig file:
 
 	[alias]
+		abandon = codereview abandon
+		branch-from-change = codereview branch-from-change
 		change = codereview change
+		check = codereview check
+		edit-message = codereview edit-message
+		fixup = codereview fixup
 		gofmt = codereview gofmt
+		import = codereview import
 		mail = codereview mail
+		open = codereview open
 		pending = codereview pending
+		prune = codereview prune
 		rebase-work = codereview rebase-work
+		rename = codereview rename
+		replant = codereview replant
+		squash = codereview squash
 		submit = codereview submit
 		sync = codereview sync
+		whoami = codereview whoami
 
 Single-Commit Work Branches
 
@@ -67,7 +79,7 @@ multiple pending changes.
 
 The ``git codereview change'' command amends the top commit in the stack (HEAD).
 To amend a commit further down the stack, use Git's rebase support,
-for example by using ``git commit --fixup'' followed by ``git codereview rebase-work.''
+for example by using ``git codereview fixup'' followed by ``git codereview rebase-work.''
 
 The ``git codereview mail'' command requires an explicit revision argument,
 but note that since ``git codereview mail'' is implemented as a ``git push,''
@@ -83,6 +95,28 @@ the implied ``git rebase'' may conflict with the remaining pending commits.
 Instead it is necessary to run ``git codereview sync'' explicitly
 (when ready) after ``git codereview submit.''
 
+Stacked Work Branches
+
+A sequence of changes that build on one another can also be kept one change
+per branch, with each branch stacked on the one before it:
+
+	git codereview change -on first second
+
+creates the branch second on top of the work branch first and records that
+second depends on first. The pending change of the stacked branch is then
+only its own commit, not the ones in first, so that change, mail -diff, and
+pending operate on it as in a single-commit work branch. The sync command
+rebases a stacked branch onto its parent branch instead of the upstream
+branch; sync the parent first, or use ``git codereview sync -all'', which
+syncs each parent before the branches stacked on it, or, to sync only
+one chain, ``git codereview sync -preserve-chain'' on the branch at its base.
+When the changes in a parent branch are submitted, sync rebases the branches
+stacked on it onto the upstream branch.
+
+Mailing a stacked branch pushes its change for review on the upstream branch
+as usual. The push carries the changes in the parent branches along, so that
+Gerrit shows the changes as a relation chain.
+
 Reusing Work Branches
 
 Although one common practice is to create a new branch for each pending change,
@@ -96,12 +130,59 @@ Command Details
 
 All commands accept these global flags:
 
-The -v flag prints all commands that make changes.
+The -v flag prints all commands that make changes. Their output is marked
+with the command name, as in ``git| ...'', to tell it apart from the messages
+of git-codereview itself, except for git commands that may start an editor,
+which keep direct access to the terminal.
 
 The -n flag prints all commands that would be run, but does not run them.
 
+The -no-color flag disables colored output. By default, errors, verbose
+messages, and commands printed by -v and -n are shown in color when
+standard error is a terminal and the NO_COLOR environment variable is not set.
+
+The -quiet flag suppresses informational messages and warnings, including the
+command echoed before reporting that it failed, so that only errors are printed.
+It is meant for scripts. Output from the git commands being run, and the
+commands printed by -v and -n, are still shown.
+
+The -trace flag prints, at the end of the command, a table of all the
+commands that were run, including those that only read the repository state
+and so are not shown by -v, with the wall-clock time each took and its exit
+status, followed by the total. It helps find out why a command is slow.
+
+The -y flag answers yes to the confirmation prompts of commands that discard
+work, such as abandon and squash. When standard input is not a terminal,
+those prompts are declined unless -y is given.
+
+Commands exit with status 0 on success and with these statuses on failure,
+so that scripts can tell failures apart:
+
+	1  any failure not listed below
+	2  invalid command line
+	3  not in a git repository
+	4  no pending change to operate on, or the change is empty
+	5  command not allowed on the current branch, such as change on master
+	6  a git command run by git-codereview failed
+
 Descriptions of each command follow.
 
+Abandon
+
+The abandon command discards the current work branch.
+
+	git codereview abandon [-f] [-gerrit]
+
+It changes to the upstream branch and deletes the work branch, including
+any pending changes. The command fails if there are modified files (staged
+or unstaged), and it refuses to delete branches that track a branch on
+the server directly, such as master.
+
+Because abandoning a branch discards its pending changes, the command asks
+for confirmation first. The -f flag, like the global -y flag, skips the confirmation.
+
+The -gerrit flag also abandons the pending changes on the Gerrit server.
+
 Branchpoint
 
 	git codereview branchpoint
@@ -112,12 +193,27 @@ where local work branched from the published tree. The command is intended
 mainly for use in scripts. For example, ``git diff $(git codereview branchpoint)''
 or ``git log $(git codereview branchpoint)..HEAD''.
 
+Branch-From-Change
+
+The branch-from-change command fetches a change from Gerrit into a local
+branch, for reviewing or testing someone else's change.
+
+	git codereview branch-from-change [-b name] [-f] <change>[/<patchset>]
+
+It looks up the change on Gerrit, fetches the given patch set, by default
+the latest one, and checks it out as a new branch named change-<number>
+that tracks the change's target branch. The -b option names the branch
+instead. The command refuses to replace an existing branch unless the
+-f option is given, which makes it easy to pick up a new patch set of the
+same change. Like change with a CL number, it fails if there are modified
+files, and it reports changes that do not exist on Gerrit.
+
 Change
 
 The change command creates and moves between Git branches and maintains the
 pending changes on work branches.
 
-	git codereview change [-a] [-q] [branchname]
+	git codereview change [-a] [-q] [-s] [-as identity] [-keep-date | -reset-date] [-m msg | -F file] [-base ref | -on branch] [branchname]
 
 Given a branch name as an argument, the change command switches to the named
 branch, creating it if necessary. If the branch is created and there are staged
@@ -133,6 +229,144 @@ The -q option skips the editing of an extant pending change's commit message.
 The -a option automatically adds any unstaged edits in tracked files during
 commit; it is equivalent to the 'git commit' -a option.
 
+The -m option uses the given message as the commit message instead of
+running the editor; it is equivalent to the 'git commit' -m option.
+When amending a pending change, the message replaces the existing one,
+but the trailer lines at the end of the existing message, such as its
+Change-Id line, are kept unless the new message already has them. A new
+Change-Id line in the message replaces the old one.
+
+The -F option is like -m but reads the message from the named file, or
+from standard input if the file is ``-''; it is equivalent to the 'git commit'
+-F option. The change command fails if the message is empty, and the -m and
+-F options cannot be used together.
+
+Since a message given with -m or -F does not pass through the editor, the
+change command tidies it first: it removes trailing spaces from each line and
+blank lines from the start and end of the message, and it adds a blank line
+after the subject line if the body follows it directly. It also warns if the
+subject is longer than 72 characters. The edit-message command does the same
+with its -m option.
+
+When standard input is not a terminal, as in a script or CI job, the change
+command fails at once instead of running an editor that would wait forever
+for input, unless -m or -F gives the message or $GIT_EDITOR names an editor
+to use anyway, such as ``true''. The same goes for edit-message without -m.
+With a terminal, the editor is chosen as for git commit, from $GIT_EDITOR,
+core.editor, or $EDITOR.
+
+The -s option adds a Signed-off-by trailer for the committer to the commit
+message; it is equivalent to the 'git commit' -s option. The commit-msg hook
+adds the Change-Id line to the same trailer block, as Gerrit expects.
+If the codereview.signoff setting is true, -s is the default; use -s=false
+to leave out the trailer.
+
+The -as option makes the commit's author and committer the given identity,
+of the form ``Name <email>'', instead of the git user.name and user.email
+settings, which may belong to someone else on a shared machine. If the
+codereview.identity setting is set, it is the default.
+
+Like 'git commit --amend', amending a pending change keeps its author date
+but sets its committer date to the current time, so that every amend creates
+a new commit hash even if nothing else changed. The -keep-date option keeps
+both dates, so that amending without changes leaves the commit as it was,
+which helps when reproducible commit hashes matter. The -reset-date option
+sets both dates to the current time instead.
+
+The -base option creates the named branch, which must not already exist,
+starting at the given commit instead of HEAD, so that a change can be built
+on another branch or on a specific commit. If the base is an origin branch,
+the new branch tracks it; if the base is a local branch, the new branch tracks
+the same origin branch as the base does. Otherwise the new branch tracks the
+origin branch of the current branch. Unlike creating a branch without -base,
+this works even when the current branch has a pending change.
+
+The -on option is like -base, but the base must be another work branch,
+and the new branch is stacked on it (see Stacked Work Branches above):
+the dependency is recorded in the git config setting
+branch.<name>.codereviewparent, which the rename command keeps up to date.
+
+The -files option amends the pending change with the changes to only the
+paths given as arguments, instead of with whatever is staged:
+
+	git codereview change -files [-q] [-s] [-as identity] [-keep-date | -reset-date] [-m msg | -F file] path...
+
+It stages the changes to those paths, including new and deleted files,
+and then amends the change with them, leaving any other staged changes staged
+but out of the commit. It warns about and skips any path without changes,
+and it fails if none of the paths have changes or there is no pending change.
+As when amending otherwise, it refuses to commit to a branch like master.
+
+Check
+
+The check command checks that the current branch follows the single-commit
+work branch workflow and is ready to mail.
+
+	git codereview check
+
+It prints a checklist of the following, marking each ``ok'' or ``FAIL'':
+that the current branch is a work branch and not a branch like master,
+that it has exactly one pending commit beyond its upstream branch,
+that the pending commit has a Change-Id line,
+that it is not a merge commit,
+and that there are no staged or unstaged changes, which mail would leave out.
+A stray ``git commit'' on a work branch, for example, adds a second pending
+commit that fails the check.
+The command exits with a non-zero status if any check fails, so that scripts
+can use it to guard a mail.
+
+Config
+
+The config command lists, shows, and sets the personal settings described
+under Configuration below.
+
+	git codereview config [-list | name [value]]
+
+With no arguments or with -list, the config command prints every known
+personal setting with its value, marking the ones left at their defaults,
+followed by the project settings from codereview.cfg.
+Given a setting name, with or without the ``codereview.'' prefix, it prints
+the value of that setting. Given a name and a value, it checks that the value
+makes sense for the setting and then stores it with ``git config''.
+The command warns about names it does not know, to catch typos, and refuses
+to change project settings, which belong in codereview.cfg.
+
+Unlike the other commands, config has no suggested alias, since
+``git config'' is already taken.
+
+Edit-message
+
+The edit-message command edits the commit message of the pending change,
+to fix a typo in the description, say, without touching the change's files.
+
+	git codereview edit-message [-m msg]
+
+It runs ``git commit --amend --only'', which opens the editor on the current
+message. Unlike the change command, it does not need staged changes and
+leaves any that there are out of the commit. The -m option uses the given
+message instead of running the editor, keeping the trailers of the current
+message, such as its Change-Id line, as the change command does when
+amending. Like the change command, it refuses
+to run on a branch like master, which has no pending change.
+
+Fixup
+
+The fixup command commits the staged changes as a fixup commit for a pending
+change, to be folded into that change later.
+
+	git codereview fixup [-a] [commit]
+
+It runs ``git commit --fixup=commit''. By default the commit is the newest
+pending commit that is not itself a fixup commit. The -a flag adds changes to
+any tracked files, as in ``git commit -a''. The commit-msg hook does not add a
+Change-Id line to fixup! and squash! commits, so when the fixup is folded in,
+the change keeps its Change-Id.
+
+The rebase-work command and ``git codereview sync -i'' rebase with
+``git rebase --autosquash'', which moves each fixup commit after the commit
+it fixes and folds it in. The squash command folds fixup commits in too,
+along with all other pending commits.
+
 Gofmt
 
 The gofmt command applies the gofmt program to all files modified in the
@@ -156,7 +390,7 @@ Hooks
 
 The hooks command installs the Git hooks to enforce code review conventions.
 
-	git codereview hooks
+	git codereview hooks [-reinstall | -status | -uninstall]
 
 The pre-commit hook checks that all Go code is formatted with gofmt and that
 the commit is not being made directly to the master branch.
@@ -165,10 +399,28 @@ The commit-msg hook adds the Gerrit ``Change-Id'' line to the commit message if
 not present. It also checks that the message uses the convention established by
 the Go project that the first line has the form, pkg/path: summary.
 
-The hooks command will not overwrite an existing hook.
+The hooks command replaces out-of-date git-codereview hooks, saving
+the old hook file with a .bak suffix, but will not overwrite a
+different existing hook.
 If it is not installing hooks, use ``git codereview hooks -v'' for details.
+The -reinstall flag replaces existing hooks even if they were modified,
+again saving the old hook file with a .bak suffix.
+The -status flag reports whether each hook is current, missing,
+stale (an older git-codereview hook, exactly as installed), or modified
+(anything else, including an edited copy of a git-codereview hook),
+without changing anything.
+The -uninstall flag removes the current and stale hooks installed by
+git-codereview. It does not remove modified hooks, even edited copies of its
+own, which may contain someone else's work, and fails if any are left
+behind. Unless codereview.autohooks is false, other git codereview
+commands reinstall the hooks afterward.
 This hook installation is also done at startup by all other git codereview
-commands, except ``git codereview help''.
+commands, except ``git codereview help'', ``git codereview version'', and the
+internal hook-invoke and completion commands. The help, version, and completion
+commands also work outside a git repository. To manage hooks yourself, turn off
+the automatic installation by running ``git config codereview.autohooks false''.
+With automatic installation turned off, the mail command warns when the
+commit-msg hook, which adds the Change-Id line, is missing.
 
 Hook-Invoke
 
@@ -178,17 +430,83 @@ The hook-invoke command is an internal command that invokes the named Git hook.
 
 It is run by the shell scripts installed by the ``git codereview hooks'' command.
 
+Import
+
+The import command copies a single commit, for example one from the middle of
+a stack of pending changes, into a new work branch of its own.
+
+	git codereview import <commit> [branchname]
+
+It creates the new branch at the upstream branch of the current branch
+(usually origin/master) and cherry-picks the commit onto it. If no branch name
+is given, it is derived from the commit subject: ``cmd/go: fix parse error''
+becomes fix-parse-error. The imported commit keeps its Change-Id line, so
+mailing it updates the same change on Gerrit; a commit without one gets one
+added by the commit-msg hook.
+
+Init
+
+The init command sets up a repository for the code review workflow.
+
+	git codereview init [-branch name]
+
+It installs the hooks (see Hooks above), checks that the remote that the mail
+command pushes to exists, finds the upstream branch that new work branches
+should track, and checks that the repository is reviewed on Gerrit, printing
+a summary of each step. Other commands install the hooks automatically too,
+but init is an explicit first step for a new clone that reports problems early.
+
+The upstream branch comes from the branch setting in codereview.cfg, if present;
+otherwise from the -branch option, the codereview.branch setting, or the
+default branch of origin, in that order. If that is not the default, master,
+init saves it as the codereview.branch setting, so that, for example, new
+branches in a repository whose default branch is main track origin/main.
+Running init again changes nothing that is already set up.
+
+Unlike most other commands, init has no suggested alias, since ``git init''
+is already taken.
+
+If the cherry-pick conflicts, the import command undoes it, deletes the new
+branch, returns to the original branch, and explains how to redo the import
+by hand. It refuses to run with uncommitted changes.
+
 Mail
 
 The mail command starts the code review process for the pending change.
 
-	git codereview mail [-f] [-r email] [-cc email] [revision]
+	git codereview mail [-f] [-r email] [-cc email] [-as identity] [-for branch] [-label vote,...] [-m message] [-force] [-no-verify] [-open] [-owners] [-remote remote] [-topic topic] [-trybot] [-wip | -ready] [revision]
 
 It pushes the pending change commit in the current branch to the Gerrit code
 review server and prints the URL for the change on the server.
+Because the server's URL is easy to miss among the other push output,
+the mail command repeats it as its last line of output.
 If the change already exists on the server, the mail command updates that
 change with a new changeset.
 
+Gerrit identifies changes by the Change-Id line in the commit message.
+If the commit has none, usually because the commit-msg hook was not installed
+when it was made, the mail command offers to amend the commit, which runs the
+hook to add the line. For a commit other than HEAD, or if the offer is
+declined, the command fails and explains how to add the line.
+The -as flag, like the change command's, sets the identity used for that amend.
+
+The -for flag sends the change for review on the named server branch, such as
+``git codereview mail -for release-branch.go1.4'', by pushing to
+refs/for/<branch> instead of refs/for/ followed by the upstream branch.
+It is meant for backports; the other flags work as usual.
+
+The -m flag attaches a message to the new patch set, which Gerrit shows as a
+review comment, such as ``git codereview mail -m "addressed review comments"''.
+The mail command encodes the message for the push, so it may contain spaces,
+commas, and other punctuation.
+
+The -label flag casts votes on the change as it is mailed, such as
+``git codereview mail -label Code-Review+2,Verified+1''. Each vote is a label
+name followed by a signed number, and the flag may be repeated to cast more.
+It is meant for trusted automation, such as continuous integration systems and
+bots that approve their own changes: Gerrit rejects the push if the account
+is not allowed to cast a vote. People should vote in the Gerrit web interface.
+
 The -r and -cc flags identify the email addresses of people to do the code
 review and to be CC'ed about the code review.
 Multiple addresses are given as a comma-separated list.
@@ -198,39 +516,186 @@ The mail command resolves such shortenings by reading the list of past reviewers
 from the git repository log to find email addresses of the form name@somedomain
 and then, in case of ambiguity, using the reviewer who appears most often.
 
+The -owners flag adds the owners of the files in the change as reviewers.
+Owners are listed one email address per line in files named OWNERS.
+The owners of a file are those listed in the OWNERS file in its directory
+and in each parent directory up to the repository root, stopping at an
+OWNERS file containing the line ``set noparent''. Nearer owners come first,
+you are never added as your own reviewer, and at most five owners are added.
+
+The -open flag opens the change in a web browser once it is mailed
+(see Open below).
+
+The mail command pushes to the remote named by the codereview.remote setting
+(see Configuration below), which defaults to origin. The -remote flag names
+a different remote for a single mailing, such as a separate remote for the
+Gerrit server when origin is a mirror. The remote must already be configured.
+If codereview.remote is not set and more than one remote looks like a Gerrit
+server (a *.googlesource.com URL, an ssh URL using port 29418, or a URL under
+the gerrit setting in codereview.cfg), the mail command asks which one to use
+and saves the answer in codereview.remote, rather than assuming origin.
+If codereview.remote is not set, origin does not look like a Gerrit server,
+and the repository has a .gitreview file, as repositories set up for the
+git-review tool do, the mail command pushes to the Gerrit server it names
+instead, at ssh://<host>:<port>/<project>. Like git-review, it logs in as
+the user named by the gitreview.username git setting, if set.
+When standard input is not a terminal, it exits listing those remotes instead.
+
+The -topic flag sets the Gerrit topic of the change, which groups related
+changes together on the server. The topic may not contain commas or spaces.
+If the codereview.topicfrombranch setting (see Configuration below) is true,
+the topic defaults to the name of the current branch.
+
+The -trybot flag asks Gerrit to run the trybots on the change,
+by setting its Run-TryBot label.
+
+The -wip flag marks the change as work in progress, so that Gerrit does not
+notify reviewers about it yet. The -ready flag marks a work-in-progress
+change as ready for review.
+
+If the push fails because of what looks like a temporary network or server
+problem, the mail command tries it again a few times before giving up
+(see codereview.uploadretries below).
+
 The mail command fails if there are staged edits that are not committed.
 The -f flag overrides this behavior.
 
+For a one-off mailing that the usual checks get in the way of, the -no-verify
+flag skips them: the check for staged edits, the check for an empty commit,
+the check for a Change-Id line, and, when no commit is named, the check for a
+single pending commit, mailing all the pending commits instead, which it
+says it is doing. It also passes --no-verify to git push, which skips any
+pre-push hook. With -v, it notes which checks it skipped.
+
 The mail command updates the tag <branchname>.mailed to refer to the
 commit that was most recently mailed, so running ``git diff <branchname>.mailed''
 shows diffs between what is on the Gerrit server and the current directory.
 
+The -diff flag shows the changes that would be mailed, but does not
+upload or mail them. Paths given after a ``--'' argument restrict the
+diff to those paths, as in ``git codereview mail -diff -- file.go''.
+With -diff, the -stat flag shows only a summary of the changes, as in
+``git diff --stat'', and the -name-only flag shows only the names of the
+changed files. The -word-diff and -color-words flags show changed words
+instead of changed lines, which suits prose and configuration files; they are
+the same as git diff's --word-diff and --color-words. The diff normally starts
+at the branchpoint (see Branchpoint); the -against flag makes it start at the
+merge base of the commit and the given ref instead, as in
+``git diff origin/master...HEAD''.
+
+The -staged and -worktree flags show the other changes that the workflow keeps
+track of instead of the change commit: -staged shows the staged changes, which
+the next change command folds into the change commit, as in
+``git diff --cached'', and -worktree shows the changes that are not staged yet,
+as in plain ``git diff''. They do not need a pending change, and they cannot be
+used with -against or a revision.
+
+The mail command wraps only the -stat, -name-only, -word-diff, -color-words,
+-against, -staged, and -worktree flags. Any other git diff option can be passed through by listing
+it after the ``--'', before the paths, as in
+``git codereview mail -diff -- --ignore-all-space -U1 file.go''.
+Each passed-through option must be a single argument, such as -U1 or
+--diff-filter=M. A second ``--'' ends the options, for a path that begins
+with a dash.
+
 If there are multiple pending commits, the revision argument is mandatory.
 If no revision is specified, the mail command prints a short summary of
 the pending commits for use in deciding which to mail.
 
+If the codereview.backend setting (see Configuration below) is github,
+the mail command sends the change for review as a GitHub pull request
+instead. It pushes the commit to the branch of the same name on the remote,
+replacing what was there, and then opens a pull request for that branch
+against the upstream branch, or against the -for branch if given, using the
+commit message as the pull request's title and description. If the branch
+already has an open pull request, mailing updates it instead. The -r flag
+names GitHub users to request reviews from, and the -wip flag opens the pull
+request as a draft.
+
+Because mailing an amended change replaces the commit on the remote branch,
+the push uses ``git push --force-with-lease'', expecting the branch to be
+the commit last mailed, as recorded in the <branchname>.mailed tag, or not
+to exist yet. If someone else has pushed to the branch since, the mail command
+fails rather than discard their work; after checking what changed, the -force
+flag overwrites the branch anyway. The -cc, -owners, -ready, -topic, and -trybot flags are
+Gerrit-specific and cannot be used. The GitHub API token comes from the
+GITHUB_TOKEN environment variable or, if that is not set, from
+``gh auth token''. The owner and name of the repository come from the URL of
+origin, which must be a GitHub URL.
+
+Open
+
+The open command opens the Gerrit page for the pending change in a web browser.
+
+	git codereview open [commit]
+
+If there are multiple pending commits, the commit argument is mandatory.
+The change must already have been mailed. The mail command records the
+change's URL in the git notes ref refs/notes/codereview, keyed by Change-Id,
+so that open (and pending -l) can find the URL without contacting Gerrit,
+even after the commit is amended. The command runs the first browser
+listed in the BROWSER environment variable or, if that is not set, the
+system's usual command for opening URLs: open on macOS, start on Windows,
+and xdg-open elsewhere.
+
 Pending
 
 The pending command prints to standard output the status of all pending changes
 and staged, unstaged, and untracked files in the local repository.
 
-	git codereview pending [-c] [-l] [-s]
+	git codereview pending [-c] [-json] [-l] [-limit n] [-s] [-sort name|date]
+
+It lists the current branch first, followed by the other branches with pending
+changes, most recently committed first. The line for each branch shows its
+range of pending commits and notes whether it is the current branch, whether
+it has uncommitted changes (``dirty''), whether its changes are all mailed or
+all submitted, how many commits it is ahead of and behind its upstream branch,
+and which upstream branch it tracks, if not the usual one.
 
 The -c flag causes the command to show pending changes only on the current branch.
 
+The -json flag causes the command to print the status as a JSON array
+of branches, for use by other programs. Each branch lists its name, its
+upstream branch, how many commits it is ahead of and behind that branch,
+whether there are uncommitted changes, and its pending changes.
+
 The -l flag causes the command to use only locally available information.
 By default, it fetches recent commits and code review information from the
 Gerrit server.
 
+The -limit flag causes the command to show at most n branches, counting the
+current branch, and to note at the end how many more it omitted.
+
 The -s flag causes the command to print abbreviated (short) output.
 
+The -sort flag sets the order of the branches after the current one:
+``date'' (the default) lists the most recently committed first,
+and ``name'' lists them alphabetically.
+
 Common shorter aliases include ``git p'' for ``git pending''
 and ``git pl'' for ``git pending -l'' (notably faster but without Gerrit information).
 
+Prune
+
+The prune command deletes local work branches whose changes have been submitted.
+
+	git codereview prune [-dry-run]
+
+A branch is pruned when it has pending commits and each of them is already on
+its upstream branch, either as an equivalent patch (as reported by
+``git cherry'') or as a commit with the same Change-Id line. The command never
+deletes the current branch or branches that track a branch on the server
+directly, such as master, and it keeps branches with no pending commits,
+which may have just been created. It lists the branches to delete and asks
+for confirmation first; the global -y flag skips the confirmation. The
+<branchname>.mailed tag of each deleted branch (see Mail) is deleted too.
+
+The -dry-run flag lists the branches that would be deleted without deleting them.
+
 Rebase-work
 
 The rebase-work command runs git rebase in interactive mode over pending changes.
-It is shorthand for ``git rebase -i $(git codereview branchpoint)''.
+It is shorthand for ``git rebase -i --autosquash $(git codereview branchpoint)''.
 It differs from plain ``git rebase -i'' in that the latter will try to incorporate
 new commits from the origin branch during the rebase;
 ``git codereview rebase-work'' does not.
@@ -238,10 +703,54 @@ new commits from the origin branch during the rebase;
 In multiple-commit workflows, rebase-work is used so often
 that it can be helpful to alias it to ``git rw''.
 
+Rename
+
+The rename command renames the current work branch.
+
+	git codereview rename newname
+
+The command refuses to rename branches that track a branch on the server
+directly, such as master, and fails if a branch named newname already exists.
+If changes on the branch were mailed, the <branchname>.mailed tag (see Mail)
+is renamed along with it.
+
+Replant
+
+The replant command moves the pending change on the current work branch onto
+the latest upstream branch, leaving behind the history of earlier rebases.
+
+	git codereview replant [-d] [newname]
+
+It fetches from the remote repository and cherry-picks the pending change onto
+the upstream branch (usually origin/master). The change keeps its commit
+message, including its Change-Id line, so mailing it updates the same change on
+Gerrit. Without a branch name, the current branch is moved to the new commit.
+With one, the change is copied to a new work branch named newname, and the -d
+flag deletes the old branch afterward, moving any branches stacked on it to the
+new one. If the cherry-pick conflicts, the command puts everything back the
+way it was; use sync to resolve the conflicts instead.
+
+The command works only on a work branch with a single pending change and
+without staged or unstaged changes. A branch stacked on another (see Stacked
+Work Branches above) cannot be replanted; use sync instead.
+
+Squash
+
+The squash command combines all pending commits on the current branch
+into a single pending change.
+
+	git codereview squash
+
+The resulting commit uses the commit message, including the Change-Id line,
+of the first (oldest) pending commit. The command fails if there are staged
+changes, which would otherwise be folded into the squashed commit.
+It asks for confirmation first; the global -y flag skips the confirmation.
+To combine only some of the pending commits, use ``git codereview rebase-work''.
+
 Submit
 
 The submit command pushes the pending change to the Gerrit server and tells
-Gerrit to submit it to the master branch.
+Gerrit to submit it to the upstream branch.
 
 	git codereview submit [-i | revision...]
 
@@ -269,10 +778,135 @@ Sync
 
 The sync command updates the local repository.
 
-	git codereview sync
+	git codereview sync [-no-autostash] [-merge | -i] [-onto ref] [-stat]
+	git codereview sync -all
+	git codereview sync -preserve-chain
+	git codereview sync -fetch-only
 
 It fetches commits from the remote repository and merges them from the
 upstream branch to the current branch, rebasing any pending changes.
+The upstream branch is the one that the current branch tracks. For a branch
+that tracks nothing, it is the one that the local branch named by
+codereview.branch tracks, so that in a fork whose master tracks
+upstream/main, say, the sync command brings in upstream/main.
+Without tracking information there either, it is origin/master.
+The sync command reports how many new commits the branch gained below its
+pending changes, as in ``rebased onto origin/master (3 new upstream commits)'',
+even when there were none, and the -stat flag lists those commits too,
+one per line, as in ``git log --oneline''. This goes for the -onto flag and
+for stacked branches too, where the commits come from the onto ref or the
+parent branch.
+
+If there are staged or unstaged changes, the sync command saves them with
+``git stash push -u'', which also saves untracked files, and restores them
+with ``git stash pop --index'' once the sync is done. If restoring the
+changes conflicts with the new upstream changes, the stash entry is kept:
+resolve the conflicts and then drop it with ``git stash drop''.
+The -no-autostash flag makes the sync command refuse to run with
+uncommitted changes instead.
+
+The -merge flag merges the upstream changes into the current branch
+instead of rebasing the pending changes on top of them.
+
+The -i flag rebases the pending changes interactively, as in
+``git rebase -i --autosquash'', so that fixup commits (see Fixup) are folded in.
+
+The -onto flag rebases the pending changes onto the given commit instead of
+the upstream branch, as in ``git rebase --onto ref $(git codereview branchpoint)''.
+It is useful for building a change on top of another change that is still in
+review, such as ``git codereview sync -onto otherwork''. The sync command
+still fetches from the remote repository first, so that an origin branch
+given as ref is up to date. The branch keeps tracking its upstream branch.
+
+On a stacked work branch (see Stacked Work Branches above), the sync command
+fetches from the remote repository and then rebases the branch's own pending
+changes onto its parent branch, as if -onto named the parent. The -merge flag
+does not work on a stacked branch.
+Once the changes in the parent branch have all been submitted, the sync
+command says so and rebases the branch's own changes directly onto its
+upstream branch instead, and the branch is no longer stacked.
+
+If the rebase stops because of conflicts, the sync command lists the
+conflicting files. After resolving the conflicts and marking them resolved
+with ``git add'', run ``git codereview sync -continue'' to finish the sync,
+or run ``git codereview sync -abort'' to restore the branch to its state
+before the sync. Either one also restores any changes stashed by the sync.
+If a -merge sync stops because of conflicts, the sync command lists them
+too; after resolving them, run ``git commit'' to finish the merge, or run
+``git merge --abort'' to give up, and then ``git stash pop'' to restore
+any changes stashed by the sync.
+
+The -all flag syncs every local work branch, not just the current one.
+It fetches from the remote repository once, then checks out and rebases
+each work branch that is behind its upstream branch, or its parent branch
+for a stacked branch, which it syncs after the parent, reporting the result
+for each, and finally returns to the original branch. Because it changes
+branches, it refuses to run with uncommitted changes. If a rebase stops
+because of conflicts, resolve them and run ``git codereview sync -continue'',
+then run ``git codereview sync -all'' again to sync the remaining branches.
+
+The -preserve-chain flag is like -all, but it syncs only the current branch
+and the branches stacked on it, directly or through other stacked branches.
+Each branch in the chain is rebased onto its rebased parent, so that the
+chain keeps its shape when the changes at its base are amended or its
+upstream branch moves. As with -all, a stacked branch whose parent's changes
+have all been submitted is rebased onto the upstream branch instead.
+
+The -fetch-only flag only fetches from the remote repository and then reports
+how many commits the current branch is ahead of and behind its upstream
+branch. It does not change the branch or the working tree, so it is a safe
+way to see whether a sync is needed.
+
+Whoami
+
+The whoami command shows the identity used when preparing and mailing changes.
+
+	git codereview whoami
+
+It prints the user.name and user.email settings from the git configuration,
+the remote that the mail command pushes to and its URL, the Gerrit server and
+project, where the Gerrit credentials come from, and the Gerrit account that
+they sign in to. Signing in only reads the account information, so the command
+is safe to run at any time. It fails if any of these is missing or if signing
+in to Gerrit fails, which helps explain why the server rejects a mail command.
+
+Worktree
+
+The worktree command creates a work branch in a worktree of its own, so that
+several changes can be worked on at once, each in its own directory, without
+switching branches or stashing.
+
+	git codereview worktree branchname
+	git codereview worktree -remove branchname
+
+It creates the new work branch at the upstream branch of the current branch
+(usually origin/master) and checks it out with ``git worktree add'' in a
+sibling directory of the main worktree, named after the branch: for the branch
+fix-parse in the repository ~/go, the directory is ~/go-fix-parse. The branch
+is ready for the change command, and the hooks, which all worktrees share,
+are already in place.
+
+The -remove flag removes the worktree in which the branch is checked out. It
+refuses if the worktree has staged, unstaged, or untracked changes, which
+would be lost, and it cannot remove the main worktree or the current one.
+The branch itself is kept, along with any pending change on it; prune deletes
+it once the change is submitted.
+
+Unlike most other commands, worktree has no suggested alias, since
+``git worktree'' is already taken.
+
+Version
+
+The version command prints the version of git-codereview, along with the
+commit it was built from and the build date when known.
+
+	git codereview version
+
+It can also be invoked as ``git codereview -version'', and it works outside
+any git repository. Release builds set the version information at link time,
+with ``go build -ldflags "-X main.version=v1.2.0 -X main.commit=... -X main.buildDate=..."'';
+otherwise it comes from the module and version control information that the
+go command records in the binary.
 
 Configuration
 
@@ -284,13 +918,80 @@ of this format:
 
 The ``gerrit'' key sets the Gerrit URL for this project. Git-codereview
 automatically derives the Gerrit URL from repositories hosted in
-*.googlesource.com. If not set or derived, the repository is assumed to
-not have Gerrit, and certain features won't work.
+*.googlesource.com. If the key is not set and origin is not such a repository,
+a .gitreview file at the repository root, in the INI format used by the
+git-review tool, also names the Gerrit server, with host, port, and project
+settings in its [gerrit] section; the Gerrit URL is then https://<host>. If not set or derived,
+the repository is assumed to not have Gerrit, and certain features won't work.
+
+The ``branch'' key sets the upstream integration branch for the project.
+It is used for work branches that were created without tracking
+information. If not set, the upstream branch is ``master''.
 
 The ``issuerepo'' key specifies the GitHub repository to use for issues, if
 different from the source repository. If set to ``golang/go'', for example,
 lines such as ``Fixes #123'' in a commit message will be rewritten to ``Fixes
 golang/go#123''.
 
+Some settings are personal rather than project-wide.
+They are read from the ``codereview'' section of the git configuration
+and can be set with ``git config'' or ``git codereview config'':
+
+The ``codereview.autohooks'' setting, if false, turns off the automatic
+installation of hooks by git-codereview commands.
+
+The ``codereview.backend'' setting selects the kind of code review server that
+the mail command sends changes to: gerrit, the default, or github, to send
+changes as GitHub pull requests (see Mail above).
+
+The ``codereview.branch'' setting names the upstream branch that new work
+branches track, for repositories whose codereview.cfg does not set branch.
+The default is master. The init command sets it when needed.
+
+The ``codereview.identity'' setting, of the form ``Name <email>'', is the
+identity that the change command, and the mail command when it adds a
+Change-Id line, record as the author and committer of the commits they make,
+as if -as were given. It is useful on shared machines, such as build machines,
+where the git user.name and user.email settings are not your own.
+
+The ``codereview.postmailhook'' setting is a shell command for the mail command
+to run after each successful mailing, for example to post the change link
+to a chat room or update an issue tracker. The command runs with the
+environment variables CODEREVIEW_BRANCH, CODEREVIEW_COMMIT,
+CODEREVIEW_CHANGE_URL, and CODEREVIEW_CHANGE (the change or pull request
+number) describing what was mailed; the last two are empty if the server did not
+report the URL. If the command fails, the mail command prints a warning,
+but the mailing still counts as a success.
+
+The ``codereview.remote'' setting names the git remote to which the mail
+command pushes changes for review. The default is origin, unless there are
+multiple Gerrit remotes to choose from (see Mail above).
+
+The ``codereview.uploadretries'' setting is the number of times the mail command
+retries a push that fails with what looks like a temporary network or server
+error, such as a reset connection or an HTTP 503 response. The default is 3.
+Each retry waits twice as long as the one before, starting at two seconds.
+Rejections by the server are never retried.
+
+The ``codereview.topicfrombranch'' setting, if true, makes the mail command
+set the Gerrit topic of mailed changes to the name of the current branch
+when the -topic flag is not given.
+
+The ``codereview.timeout'' setting is the number of seconds that a git push,
+git fetch, or git pull run by the mail, submit, and sync commands may take
+before it is killed, so that a stuck network connection does not hang the
+command forever. The default is 120; 0 means no limit. Other git commands,
+which may wait for the user, for example in an editor, have no limit;
+so does the git pull of sync -i.
+
+The ``codereview.stalethreshold'' setting is the number of commits that the
+current branch may fall behind its upstream branch before the change command
+warns, after committing, that it is time to run sync. The default is 50;
+0 turns the warning off.
+
+The ``codereview.signoff'' setting, if true, makes the change command add a
+Signed-off-by trailer to commit messages, as if -s were given, for projects
+that require a Developer Certificate of Origin sign-off.
+
 */
 package main
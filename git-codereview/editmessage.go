@@ -0,0 +1,70 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func cmdEditMessage(args []string) {
+	message := flags.String("m", "", "use `msg` as the commit message")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s edit-message %s [-m msg]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	b := CurrentBranch()
+	b.checkAttached("edit-message")
+	if !b.IsLocalOnly() {
+		exitf(exitWrongBranch, "can't edit commit message on %s branch (only work branches have pending changes).", b.Name)
+	}
+	// Dies if there is not exactly one commit.
+	old := b.DefaultCommit("edit commit message", "")
+
+	if *message == "" {
+		checkEditor("edit commit message")
+	} else {
+		*message = normalizeMessage(*message)
+		if *message == "" {
+			dief("cannot use commit message: message is empty")
+		}
+		checkSubject(*message)
+		// Keep the Change-Id, so that the commit stays the same Gerrit change.
+		*message = keepTrailers(*message, old.Message)
+	}
+	edit := func(msg string) {
+		// With --only and no paths, git commit leaves the index alone,
+		// so any staged changes stay staged instead of joining the commit.
+		args := []string{"commit", "-q", "--amend", "--only", "--allow-empty"}
+		if msg != "" {
+			args = append(args, "-m", msg)
+		}
+		run("git", args...)
+	}
+	edit(*message)
+	for !commitMessageOK() {
+		fmt.Print("re-edit commit message (y/n)? ")
+		if !scanYes() {
+			break
+		}
+		edit("")
+	}
+	if *noRun {
+		return
+	}
+	b.loadedPending = false // force reload after edit
+	c := b.Pending()[0]
+	if c.Message == old.Message {
+		printf("commit message unchanged.")
+		return
+	}
+	verbosef("new subject: %s", c.Subject)
+	printf("commit message updated.")
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEditMessage(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "edit-message", "-m", "foo: typo")
+	testPrintedStderr(t, "can't edit commit message on master branch")
+
+	gt.work(t)
+	tree := trim(trun(t, gt.client, "git", "rev-parse", "HEAD^{tree}"))
+
+	// Staged changes stay staged and out of the commit.
+	write(t, gt.client+"/file", "staged content")
+	trun(t, gt.client, "git", "add", "file")
+	// The new message keeps the Change-Id of the old one.
+	testMain(t, "edit-message", "-v", "-m", "foo: fix the typo")
+	testRan(t, "git commit -q --amend --only --allow-empty -m foo: fix the typo\n\nChange-Id: I123456789")
+	testPrintedStderr(t, "new subject: foo: fix the typo", "commit message updated.")
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != "foo: fix the typo\n\nChange-Id: I123456789" {
+		t.Fatalf("commit message = %q, want %q", msg, "foo: fix the typo\n\nChange-Id: I123456789")
+	}
+	if got := trim(trun(t, gt.client, "git", "rev-parse", "HEAD^{tree}")); got != tree {
+		t.Fatalf("edit-message changed the commit's files")
+	}
+	if staged := trun(t, gt.client, "git", "diff", "--cached", "--name-only"); !strings.Contains(staged, "file") {
+		t.Fatalf("staged changes lost after edit-message")
+	}
+
+	// Without -m, the editor edits the current message.
+	defer os.Unsetenv("GIT_EDITOR")
+	os.Setenv("GIT_EDITOR", "sed -i.bak s/typo/spelling/")
+	testMain(t, "edit-message")
+	testRan(t, "git commit -q --amend --only --allow-empty")
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != "foo: fix the spelling\n\nChange-Id: I123456789" {
+		t.Fatalf("commit message = %q, want %q", msg, "foo: fix the spelling\n\nChange-Id: I123456789")
+	}
+
+	os.Setenv("GIT_EDITOR", "true")
+	testMain(t, "edit-message")
+	testPrintedStderr(t, "commit message unchanged.")
+
+	// A blank message is an error, not a request for the editor.
+	testMainDied(t, "edit-message", "-m", "  \n ")
+	testPrintedStderr(t, "cannot use commit message: message is empty")
+	testRan(t)
+
+	// Other trailers survive a new message too.
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--only", "-m", "foo: fix the spelling\n\nSigned-off-by: Gopher <gopher@example.com>\nChange-Id: I123456789")
+	testMain(t, "edit-message", "-m", "foo: fix the grammar")
+	want := "foo: fix the grammar\n\nSigned-off-by: Gopher <gopher@example.com>\nChange-Id: I123456789"
+	if msg := trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%B")); msg != want {
+		t.Fatalf("commit message = %q, want %q", msg, want)
+	}
+
+	gt.work(t)
+	testMainDied(t, "edit-message", "-m", "foo: typo")
+	testPrintedStderr(t, "cannot edit commit message: multiple changes pending")
+}
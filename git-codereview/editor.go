@@ -11,6 +11,19 @@ import (
 	"os/exec"
 )
 
+// checkEditor dies if the command cmd is about to run the editor but
+// that would hang, waiting for input that never comes, because standard
+// input is not a terminal, as when running in a script or CI job.
+// Setting $GIT_EDITOR, say to a non-interactive editor like "true",
+// allows running the editor anyway.
+func checkEditor(cmd string) {
+	if stdinIsTerminal() || os.Getenv("GIT_EDITOR") != "" {
+		return
+	}
+	dief("cannot %s: no message provided and no terminal for the editor\n"+
+		"\tuse -m or -F to provide the message, or set $GIT_EDITOR to a non-interactive editor", cmd)
+}
+
 // editor invokes an interactive editor on a temporary file containing
 // initial, blocks until the editor exits, and returns the (possibly
 // edited) contents of the temporary file. It follows the conventions
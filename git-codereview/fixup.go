@@ -0,0 +1,60 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var fixupAuto bool // -a flag, add changes to tracked files
+
+func cmdFixup(args []string) {
+	flags.BoolVar(&fixupAuto, "a", false, "add changes to any tracked files")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s fixup %s [-a] [commit]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	b := CurrentBranch()
+	b.checkAttached("fixup")
+	if !b.IsLocalOnly() {
+		exitf(exitWrongBranch, "cannot fixup on %s branch (use '%s change branchname').", b.Name, os.Args[0])
+	}
+	var c *Commit
+	if len(flags.Args()) == 1 {
+		c = b.CommitByRev("fixup", flags.Arg(0))
+	} else {
+		c = fixupTarget(b)
+	}
+	if !HasStagedChanges() && !(fixupAuto && HasUnstagedChanges()) {
+		dief("cannot fixup: no staged changes\n" +
+			"\trun 'git add' to stage the changes for the fixup commit")
+	}
+
+	args = []string{"commit", "-q", "--fixup=" + c.Hash}
+	if fixupAuto {
+		args = append(args, "-a")
+	}
+	run("git", args...)
+	printf("created fixup commit for %s %s; run '%s rebase-work' to fold it in.", c.ShortHash, c.Subject, os.Args[0])
+}
+
+// fixupTarget returns the commit that fixup amends by default:
+// the newest pending commit on b that is not itself a fixup.
+func fixupTarget(b *Branch) *Commit {
+	for _, c := range b.Pending() {
+		if !isFixup([]byte(c.Subject)) {
+			return c
+		}
+	}
+	exitf(exitNoChanges, "cannot fixup: no pending change on branch %s\n"+
+		"\tuse '%s change' to create one", b.Name, os.Args[0])
+	panic("not reached")
+}
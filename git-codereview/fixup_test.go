@@ -0,0 +1,51 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFixup(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "fixup")
+	testPrintedStderr(t, "cannot fixup on master branch")
+
+	testMain(t, "change", "other")
+	testMainDied(t, "fixup")
+	testPrintedStderr(t, "cannot fixup: no pending change on branch other")
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+
+	gt.work(t)
+	c := CurrentBranch().Pending()[0]
+	testMainDied(t, "fixup")
+	testPrintedStderr(t, "cannot fixup: no staged changes")
+
+	write(t, gt.client+"/file", "fixed")
+	testMain(t, "fixup", "-a")
+	testRan(t, "git commit -q --fixup="+c.Hash+" -a")
+	testPrintedStderr(t, "created fixup commit for "+c.ShortHash)
+
+	// A second fixup still targets the original commit.
+	write(t, gt.client+"/file", "fixed again")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "fixup")
+	testRan(t, "git commit -q --fixup="+c.Hash)
+
+	// rebase-work folds both fixups into the change, keeping its Change-Id.
+	os.Setenv("GIT_SEQUENCE_EDITOR", "true")
+	defer os.Unsetenv("GIT_SEQUENCE_EDITOR")
+	testMain(t, "rebase-work")
+	work := CurrentBranch().Pending()
+	if len(work) != 1 || work[0].ChangeID != c.ChangeID {
+		t.Fatalf("after rebase-work have %d pending commits, Change-Id %q; want 1, %q", len(work), work[0].ChangeID, c.ChangeID)
+	}
+	if got := string(read(t, gt.client+"/file")); got != "fixed again" {
+		t.Fatalf("file = %q after rebase-work, want %q", got, "fixed again")
+	}
+}
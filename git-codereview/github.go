@@ -0,0 +1,208 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// reviewBackend returns the code review server type selected by
+// the codereview.backend setting: "gerrit" (the default) or "github".
+func reviewBackend() string {
+	switch backend := gitConfigDefault("backend"); backend {
+	case "gerrit":
+		return "gerrit"
+	case "github":
+		return "github"
+	default:
+		dief("invalid codereview.backend setting %q: must be gerrit or github", backend)
+		panic("not reached")
+	}
+}
+
+// githubAPIURL is the base URL of the GitHub API.
+// It is a variable so that tests can use a fake server.
+var githubAPIURL = "https://api.github.com"
+
+// githubClient is the HTTP client for the GitHub API.
+var githubClient = &http.Client{Timeout: 60 * time.Second}
+
+// githubRepoRE matches the owner and repository name in the URL of
+// a GitHub repository, in either https or ssh form.
+var githubRepoRE = regexp.MustCompile(`github\.com[:/]([^/]+)/([^/]+?)(\.git)?/?$`)
+
+// githubRepo returns the GitHub owner and repository name of the named remote.
+// It uses the URL as configured, before any url.<base>.insteadOf rewriting.
+func githubRepo(remote string) (owner, repo string) {
+	u, err := trimErr(cmdOutputErr("git", "config", "--get", "remote."+remote+".url"))
+	if err != nil {
+		dief("cannot mail: no remote named %s", remote)
+	}
+	m := githubRepoRE.FindStringSubmatch(u)
+	if m == nil {
+		dief("cannot mail: remote %s (%s) is not a GitHub repository", remote, u)
+	}
+	return m[1], m[2]
+}
+
+// githubToken returns the token for the GitHub API, from $GITHUB_TOKEN
+// or else from the GitHub command-line tool's 'gh auth token'.
+func githubToken() string {
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token
+	}
+	token, err := trimErr(cmdOutputErr("gh", "auth", "token"))
+	if err != nil || token == "" {
+		dief("cannot find GitHub token: set $GITHUB_TOKEN or run 'gh auth login'")
+	}
+	return token
+}
+
+// githubError is an HTTP error response served by GitHub.
+type githubError struct {
+	status string
+	body   string
+}
+
+func (e *githubError) Error() string {
+	var msg struct {
+		Message string
+		Errors  []struct{ Message string }
+	}
+	if json.Unmarshal([]byte(e.body), &msg) == nil && msg.Message != "" {
+		for _, err := range msg.Errors {
+			if err.Message != "" {
+				msg.Message += ": " + err.Message
+			}
+		}
+		return e.status + ": " + msg.Message
+	}
+	return e.status
+}
+
+// githubAPI sends a request with the given method to a GitHub API endpoint.
+// If requestBody is not nil, githubAPI sends it encoded as JSON.
+// If target is not nil, githubAPI decodes the JSON response into target.
+func githubAPI(token, method, path string, requestBody, target interface{}) error {
+	var reader io.Reader
+	if requestBody != nil {
+		data, err := json.Marshal(requestBody)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, githubAPIURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := githubClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return &githubError{resp.Status, string(body)}
+	}
+	if target != nil {
+		if err := json.Unmarshal(body, target); err != nil {
+			return fmt.Errorf("invalid response from GitHub: %v", err)
+		}
+	}
+	return nil
+}
+
+// A githubPull is a GitHub pull request.
+type githubPull struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// mailGitHub publishes c, the newest commit to mail from b, as a GitHub
+// pull request: it pushes c to the branch of the same name on remote and
+// then opens a pull request for that branch against base, or updates the
+// existing one, requesting a review from the given GitHub users.
+// Unless force is true, the push fails if the remote branch is not
+// what was last mailed. It returns the URL of the pull request.
+// With -n, it only prints what it would do and returns "".
+func mailGitHub(b *Branch, c *Commit, remote, base string, reviewers []string, draft, force bool) string {
+	owner, repo := githubRepo("origin")
+	headOwner, _ := githubRepo(remote)
+	var token string
+	if !*noRun {
+		token = githubToken()
+	}
+
+	// Amending the change rewrites the branch, so the push must force it.
+	// To avoid overwriting anyone else's commits, expect the remote branch
+	// to be the commit last mailed, recorded in the .mailed tag, or to be
+	// missing if the branch has not been mailed.
+	ref := "refs/heads/" + b.Name
+	if force {
+		mailPush(remote, "+"+c.Hash+":"+ref)
+	} else {
+		mailed, _ := trimErr(cmdOutputErr("git", "rev-parse", "--verify", "-q", "refs/tags/"+b.Name+".mailed^{commit}"))
+		mailPush(remote, c.Hash+":"+ref, "--force-with-lease="+ref+":"+mailed)
+	}
+	if *noRun {
+		printf("would open or update the pull request for %s:%s against %s/%s %s", headOwner, b.Name, owner, repo, base)
+		if len(reviewers) > 0 {
+			printf("would request reviews from %s", strings.Join(reviewers, ", "))
+		}
+		return ""
+	}
+
+	// The pull request's description is the commit message.
+	pull := map[string]interface{}{
+		"title": c.Subject,
+		"body":  strings.TrimSpace(strings.TrimPrefix(c.Message, c.Subject)),
+	}
+	repoPath := "/repos/" + owner + "/" + repo
+	var open []githubPull
+	query := url.Values{"head": {headOwner + ":" + b.Name}, "state": {"open"}}
+	if err := githubAPI(token, "GET", repoPath+"/pulls?"+query.Encode(), nil, &open); err != nil {
+		dief("cannot find pull request for %s: %v", b.Name, err)
+	}
+	var pr githubPull
+	if len(open) > 0 {
+		pr = open[0]
+		if err := githubAPI(token, "PATCH", fmt.Sprintf("%s/pulls/%d", repoPath, pr.Number), pull, nil); err != nil {
+			dief("cannot update pull request #%d: %v", pr.Number, err)
+		}
+	} else {
+		pull["head"] = headOwner + ":" + b.Name
+		pull["base"] = base
+		pull["draft"] = draft
+		if err := githubAPI(token, "POST", repoPath+"/pulls", pull, &pr); err != nil {
+			dief("cannot create pull request for %s: %v", b.Name, err)
+		}
+	}
+	if len(reviewers) > 0 {
+		req := map[string][]string{"reviewers": reviewers}
+		if err := githubAPI(token, "POST", fmt.Sprintf("%s/pulls/%d/requested_reviewers", repoPath, pr.Number), req, nil); err != nil {
+			dief("cannot request reviews for pull request #%d: %v", pr.Number, err)
+		}
+	}
+	return pr.HTMLURL
+}
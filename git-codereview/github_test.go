@@ -0,0 +1,187 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A fakeGitHub is a fake GitHub API server that records
+// the requests it receives and has a single repository.
+type fakeGitHub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string          // "METHOD path" of each request
+	bodies   []json.RawMessage // body of each request
+	pulls    []githubPull      // open pull requests
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	gh := new(fakeGitHub)
+	gh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gh.mu.Lock()
+		defer gh.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer TOKEN" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message": "Bad credentials"}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		gh.requests = append(gh.requests, r.Method+" "+r.URL.RequestURI())
+		gh.bodies = append(gh.bodies, body)
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/gopher/proj/pulls":
+			json.NewEncoder(w).Encode(gh.pulls)
+		case r.Method == "POST" && r.URL.Path == "/repos/gopher/proj/pulls":
+			pr := githubPull{Number: 7, HTMLURL: "https://github.com/gopher/proj/pull/7"}
+			gh.pulls = append(gh.pulls, pr)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(pr)
+		case r.Method == "PATCH" && r.URL.Path == "/repos/gopher/proj/pulls/7":
+			json.NewEncoder(w).Encode(gh.pulls[0])
+		case r.Method == "POST" && r.URL.Path == "/repos/gopher/proj/pulls/7/requested_reviewers":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	githubAPIURL = gh.URL
+	return gh
+}
+
+func (gh *fakeGitHub) done() {
+	gh.Close()
+	githubAPIURL = "https://api.github.com"
+}
+
+// reset returns the requests received so far and forgets them.
+func (gh *fakeGitHub) reset() (requests []string, bodies []json.RawMessage) {
+	gh.mu.Lock()
+	defer gh.mu.Unlock()
+	requests, bodies = gh.requests, gh.bodies
+	gh.requests, gh.bodies = nil, nil
+	return
+}
+
+// useGitHub makes the client's origin look like a GitHub repository,
+// while still pushing to the test server, and selects the GitHub backend.
+func (gt *gitTest) useGitHub(t *testing.T) {
+	const url = "https://github.com/gopher/proj"
+	trun(t, gt.client, "git", "config", "remote.origin.url", url)
+	trun(t, gt.client, "git", "config", "url."+gt.server+".insteadOf", url)
+	trun(t, gt.client, "git", "config", "codereview.backend", "github")
+}
+
+func TestMailGitHubBackend(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	gt.useGitHub(t)
+
+	gh := newFakeGitHub(t)
+	defer gh.done()
+
+	defer os.Setenv("GITHUB_TOKEN", os.Getenv("GITHUB_TOKEN"))
+	os.Setenv("GITHUB_TOKEN", "TOKEN")
+
+	testMainDied(t, "mail", "-topic", "x")
+	testPrintedStderr(t, "not supported with GitHub")
+
+	// With -n, nothing reaches GitHub, not even a request for a token.
+	os.Unsetenv("GITHUB_TOKEN")
+	testMain(t, "mail", "-n", "-r", "rsc")
+	testPrintedStderr(t, "git push -q --force-with-lease=refs/heads/work: origin",
+		"would open or update the pull request for gopher:work against gopher/proj master",
+		"would request reviews from rsc", "git tag -f work.mailed", "!: https://")
+	if requests, _ := gh.reset(); len(requests) != 0 {
+		t.Fatalf("GitHub requests with -n:\n%s", strings.Join(requests, "\n"))
+	}
+	os.Setenv("GITHUB_TOKEN", "TOKEN")
+
+	// The first mail opens a pull request.
+	testMain(t, "mail", "-wip", "-r", "rsc,adg")
+	testRan(t, "git push -q --force-with-lease=refs/heads/work: origin "+trim(trun(t, gt.client, "git", "rev-parse", "HEAD"))+":refs/heads/work",
+		"git tag -f work.mailed "+CurrentBranch().Pending()[0].ShortHash)
+	testPrintedStderr(t, "mailed ", ": https://github.com/gopher/proj/pull/7")
+	if head := trim(trun(t, gt.server, "git", "rev-parse", "work")); head != CurrentBranch().Pending()[0].Hash {
+		t.Fatalf("server branch work = %s, want pushed commit", head)
+	}
+	requests, bodies := gh.reset()
+	want := []string{
+		"GET /repos/gopher/proj/pulls?head=gopher%3Awork&state=open",
+		"POST /repos/gopher/proj/pulls",
+		"POST /repos/gopher/proj/pulls/7/requested_reviewers",
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("GitHub requests:\n%s\nwant:\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+	var create map[string]interface{}
+	json.Unmarshal(bodies[1], &create)
+	if create["title"] != "msg" || create["head"] != "gopher:work" || create["base"] != "master" || create["draft"] != true {
+		t.Errorf("create pull request with %s", bodies[1])
+	}
+	if string(bodies[2]) != `{"reviewers":["rsc","adg"]}` {
+		t.Errorf("request reviewers with %s", bodies[2])
+	}
+
+	// Mailing an amended change updates the same pull request.
+	write(t, gt.client+"/file", "amended")
+	trun(t, gt.client, "git", "commit", "-q", "-a", "--amend", "-m", "foo: amended\n\nMore detail.")
+	testMain(t, "mail")
+	requests, bodies = gh.reset()
+	if len(requests) != 2 || requests[1] != "PATCH /repos/gopher/proj/pulls/7" {
+		t.Fatalf("GitHub requests:\n%s\nwant GET and PATCH", strings.Join(requests, "\n"))
+	}
+	if string(bodies[1]) != `{"body":"More detail.","title":"foo: amended"}` {
+		t.Errorf("update pull request with %s", bodies[1])
+	}
+
+	// Someone else's push to the branch makes the next mail fail.
+	gh.reset()
+	write(t, gt.client+"/file", "amended again")
+	trun(t, gt.client, "git", "commit", "-q", "-a", "--amend", "--no-edit")
+	trun(t, gt.server, "git", "branch", "-f", "work", "master")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "cannot mail: refs/heads/work on origin has changed since it was last mailed", "mail -force")
+	if requests, _ := gh.reset(); len(requests) != 0 {
+		t.Fatalf("GitHub requests after failed push:\n%s", strings.Join(requests, "\n"))
+	}
+	testMain(t, "mail", "-force")
+	head := CurrentBranch().Pending()[0].Hash
+	testRan(t, "git push -q origin +"+head+":refs/heads/work", "git tag -f work.mailed "+head[:7])
+	if hash := trim(trun(t, gt.server, "git", "rev-parse", "work")); hash != head {
+		t.Fatalf("server branch work = %s, want %s", hash, head)
+	}
+
+	os.Setenv("GITHUB_TOKEN", "WRONG")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "cannot find pull request for work: 401 Unauthorized: Bad credentials")
+}
+
+func TestGitHubBackendSetting(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	testMainDied(t, "mail", "-force")
+	testPrintedStderr(t, "-force applies only when codereview.backend is github")
+
+	trun(t, gt.client, "git", "config", "codereview.backend", "gitlab")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, `invalid codereview.backend setting "gitlab"`)
+
+	trun(t, gt.client, "git", "config", "codereview.backend", "github")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "remote origin ("+gt.server+") is not a GitHub repository")
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// A gitReview is the Gerrit server described by a .gitreview file,
+// which repositories set up for the git-review tool keep at their root.
+type gitReview struct {
+	host    string // "review.opendev.org"
+	port    string // "29418"
+	project string // "openstack/nova.git"
+	user    string // ssh user name, from the gitreview.username setting
+}
+
+// pushURL returns the ssh URL for pushing changes to the server.
+// Without a user name, ssh picks one, by default the local one.
+func (gr *gitReview) pushURL() string {
+	user := ""
+	if gr.user != "" {
+		user = gr.user + "@"
+	}
+	return "ssh://" + user + gr.host + ":" + gr.port + "/" + gr.project
+}
+
+// loadGitReview returns the Gerrit server described by the .gitreview file
+// at the repository root, or nil if there is none.
+// Like git-review, it takes the ssh user name from the git config
+// setting gitreview.username, since the file is shared by everyone.
+// If the file is malformed, it dies.
+func loadGitReview() *gitReview {
+	path := filepath.Join(repoRoot(), ".gitreview")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	gr, err := parseGitReview(string(b))
+	if err != nil {
+		dief("%s: %v", path, err)
+	}
+	if user, err := trimErr(cmdOutputErr("git", "config", "--get", "gitreview.username")); err == nil {
+		gr.user = user
+	}
+	return gr
+}
+
+// parseGitReview parses the contents of a .gitreview file, which is in
+// INI format with the settings in a [gerrit] section:
+//
+//	[gerrit]
+//	host=review.opendev.org
+//	port=29418
+//	project=openstack/nova.git
+//
+// The port defaults to 29418, Gerrit's usual ssh port.
+// Settings that git-codereview does not use are ignored.
+func parseGitReview(raw string) (*gitReview, error) {
+	gr := &gitReview{port: "29418"}
+	section := ""
+	for _, line := range nonBlankLines(raw) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			// comment line
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("bad line, expected 'key=value': %q", line)
+		}
+		if section != "gerrit" {
+			continue
+		}
+		value := strings.TrimSpace(fields[1])
+		switch strings.ToLower(strings.TrimSpace(fields[0])) {
+		case "host":
+			gr.host = value
+		case "port":
+			gr.port = value
+		case "project":
+			gr.project = strings.Trim(value, "/")
+		}
+	}
+	if gr.host == "" || gr.project == "" {
+		return nil, fmt.Errorf("missing host or project in [gerrit] section")
+	}
+	return gr, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+var parseGitReviewTests = []struct {
+	raw  string
+	want *gitReview // nil for error
+}{
+	{
+		"[gerrit]\nhost=review.opendev.org\nport=29418\nproject=openstack/nova.git\n",
+		&gitReview{"review.opendev.org", "29418", "openstack/nova.git", ""},
+	},
+	{
+		"# comment\n; comment\n[Gerrit]\n  host = review.example.com \nproject = /proj/\ndefaultbranch=main\n",
+		&gitReview{"review.example.com", "29418", "proj", ""},
+	},
+	{
+		"[other]\nhost=wrong.example.com\n[gerrit]\nhost=review.example.com\nport=2222\nproject=proj\n",
+		&gitReview{"review.example.com", "2222", "proj", ""},
+	},
+	{"[gerrit]\nhost=review.example.com\n", nil},
+	{"[other]\nhost=review.example.com\nproject=proj\n", nil},
+	{"[gerrit]\nhost review.example.com\nproject=proj\n", nil},
+}
+
+func TestParseGitReview(t *testing.T) {
+	for _, tt := range parseGitReviewTests {
+		gr, err := parseGitReview(tt.raw)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("parseGitReview(%q) = %+v, want error", tt.raw, *gr)
+			}
+			continue
+		}
+		if err != nil || *gr != *tt.want {
+			t.Errorf("parseGitReview(%q) = %+v, %v, want %+v", tt.raw, gr, err, *tt.want)
+		}
+	}
+}
+
+func TestMailGitReview(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	url := "ssh://review.example.com:29418/proj.git"
+	write(t, gt.client+"/.gitreview", "[gerrit]\nhost=review.example.com\nproject=proj.git\n")
+	trun(t, gt.client, "git", "config", "url."+gt.server+".insteadOf", url)
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q "+url+" HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	// An explicit remote still wins.
+	testMain(t, "mail", "-remote", "origin")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	// Like git-review, log in as gitreview.username.
+	userURL := "ssh://gopher@review.example.com:29418/proj.git"
+	trun(t, gt.client, "git", "config", "--add", "url."+gt.server+".insteadOf", userURL)
+	trun(t, gt.client, "git", "config", "gitreview.username", "gopher")
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q "+userURL+" HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	// An origin that is a Gerrit server wins over .gitreview.
+	originURL := "https://go.googlesource.com/proj"
+	trun(t, gt.client, "git", "config", "--add", "url."+gt.server+".insteadOf", originURL)
+	trun(t, gt.client, "git", "config", "remote.origin.url", originURL)
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	write(t, gt.client+"/.gitreview", "[gerrit]\nport=29418\n")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, ".gitreview: missing host or project in [gerrit] section")
+}
+
+func TestLoadGerritOriginGitReview(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	write(t, gt.client+"/.gitreview", "[gerrit]\nhost=review.example.com\nproject=openstack/nova.git\n")
+	if !haveGerrit() {
+		t.Fatalf("haveGerrit() = false with .gitreview, want true")
+	}
+	auth.host = ""
+	defer func() { auth.host = "" }()
+	loadGerritOrigin()
+	if auth.host != "review.example.com" || auth.url != "https://review.example.com" || auth.project != "openstack/nova" {
+		t.Errorf("auth = %q %q %q, want review.example.com https://review.example.com openstack/nova", auth.host, auth.url, auth.project)
+	}
+
+	// An origin that is a Gerrit server wins over .gitreview.
+	trun(t, gt.client, "git", "config", "remote.origin.url", "https://go.googlesource.com/nova")
+	auth.host = ""
+	loadGerritOrigin()
+	if auth.host != "go.googlesource.com" || auth.project != "nova" {
+		t.Errorf("with a Gerrit origin, auth = %q %q, want go.googlesource.com nova", auth.host, auth.project)
+	}
+}
@@ -22,7 +22,7 @@ func cmdGofmt(args []string) {
 	flags.Parse(args)
 	if len(flag.Args()) > 0 {
 		fmt.Fprintf(stderr(), "Usage: %s gofmt %s [-l]\n", os.Args[0], globalFlags)
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 
 	f := gofmtCommand
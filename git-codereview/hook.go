@@ -21,48 +21,139 @@ var hookFiles = []string{
 	"pre-commit",
 }
 
+var (
+	hooksReinstall bool // -reinstall flag, replace existing hooks
+	hooksStatus    bool // -status flag, report hook status
+	hooksUninstall bool // -uninstall flag, remove hooks
+)
+
+func cmdHooks(args []string) {
+	flags.BoolVar(&hooksReinstall, "reinstall", false, "reinstall hooks, replacing modified ones")
+	flags.BoolVar(&hooksStatus, "status", false, "report whether hooks are current, missing, or modified")
+	flags.BoolVar(&hooksUninstall, "uninstall", false, "remove the hooks installed by git-codereview")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s hooks %s [-reinstall | -status | -uninstall]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 0 || countTrue(hooksReinstall, hooksStatus, hooksUninstall) > 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	if hooksUninstall {
+		uninstallHooks()
+		return
+	}
+	installHook(args)
+}
+
+// uninstallHooks removes the hooks installed by git-codereview,
+// refusing to remove hooks that someone else wrote or modified.
+func uninstallHooks() {
+	hooksDir := gitPath("hooks")
+	kept := false
+	for _, hookFile := range hookFiles {
+		filename := filepath.Join(hooksDir, hookFile)
+		data, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			dief("checking hook: %v", err)
+		}
+		switch hookState(hookFile, data) {
+		case hookMissing:
+			continue
+		case hookCurrent, hookStale:
+			// Exactly as installed, so there is nothing to lose.
+		default:
+			colorPrintf(colorError, "not removing modified %s hook; remove %s manually if it is no longer needed.", hookFile, filename)
+			kept = true
+			continue
+		}
+		verbosef("removing %s hook", hookFile)
+		if *noRun {
+			continue
+		}
+		if err := os.Remove(filename); err != nil {
+			dief("removing hook: %v", err)
+		}
+	}
+	if gitConfigBool("autohooks", true) && haveGerrit() {
+		printf("warning: other git-codereview commands will reinstall the hooks; run 'git config codereview.autohooks false' to prevent that.")
+	}
+	if kept {
+		die()
+	}
+}
+
+// Hook states reported by hookState.
+const (
+	hookMissing  = "missing"  // no hook installed
+	hookCurrent  = "current"  // our hook, up to date
+	hookStale    = "stale"    // an out-of-date git-codereview or git-review hook
+	hookModified = "modified" // some other hook
+)
+
+// hookState reports the state of the hook with the given name,
+// given the content of the existing hook file (nil if missing).
+func hookState(hookFile string, data []byte) string {
+	switch {
+	case data == nil:
+		return hookMissing
+	case string(data) == fmt.Sprintf(hookScript, hookFile):
+		return hookCurrent
+	case string(data) == fmt.Sprintf(oldHookScript, hookFile),
+		hookFile == "commit-msg" && string(data) == oldCommitMsgHook:
+		// Old hooks that use 'git-review' and the old commit-msg
+		// shell script, exactly as installed.
+		return hookStale
+	}
+	// Anything else, even an edited copy of our own hook,
+	// may hold someone's work.
+	return hookModified
+}
+
 func installHook(args []string) {
 	flags.Parse(args)
+	// gitPath honors core.hooksPath, which may name a directory
+	// that does not exist yet.
 	hooksDir := gitPath("hooks")
+	if _, err := os.Stat(hooksDir); os.IsNotExist(err) && !hooksStatus {
+		verbosef("creating hooks directory %s", hooksDir)
+		if err := os.MkdirAll(hooksDir, 0777); err != nil {
+			dief("creating hooks directory: %v", err)
+		}
+	}
 	for _, hookFile := range hookFiles {
 		filename := filepath.Join(hooksDir, hookFile)
 		hookContent := fmt.Sprintf(hookScript, hookFile)
 
-		if data, err := ioutil.ReadFile(filename); err == nil {
-			// Special case: remove old hooks that use 'git-review'
-			oldHookContent := fmt.Sprintf(oldHookScript, hookFile)
-			if string(data) == oldHookContent {
-				verbosef("removing old %v hook", hookFile)
-				os.Remove(filename)
-			}
-			// Special case: remove old commit-msg shell script
-			// in favor of invoking the git-codereview hook
-			// implementation, which will be easier to change in
-			// the future.
-			if hookFile == "commit-msg" && string(data) == oldCommitMsgHook {
-				verbosef("removing old commit-msg hook")
-				os.Remove(filename)
-			}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			dief("checking hook: %v", err)
 		}
-
-		// If hook file exists, assume it is okay.
-		_, err := os.Stat(filename)
-		if err == nil {
-			if *verbose > 0 {
-				data, err := ioutil.ReadFile(filename)
-				if err != nil {
-					verbosef("reading hook: %v", err)
-				} else if string(data) != hookContent {
-					verbosef("unexpected hook content in %s", filename)
-				}
-			}
+		state := hookState(hookFile, data)
+		if hooksStatus {
+			fmt.Fprintf(stdout(), "%s: %s\n", hookFile, state)
 			continue
 		}
 
-		if !os.IsNotExist(err) {
-			dief("checking hook: %v", err)
+		switch state {
+		case hookCurrent:
+			continue
+		case hookModified:
+			// Assume someone else's hook is okay, unless asked to replace it.
+			if !hooksReinstall {
+				verbosef("unexpected hook content in %s", filename)
+				continue
+			}
+		}
+		if state != hookMissing {
+			backup := filename + ".bak"
+			verbosef("replacing %s %s hook (old hook saved as %s)", state, hookFile, backup)
+			if err := ioutil.WriteFile(backup, data, 0700); err != nil {
+				dief("saving old hook: %v", err)
+			}
+		} else {
+			verbosef("installing %s hook", hookFile)
 		}
-		verbosef("installing %s hook", hookFile)
 		if err := ioutil.WriteFile(filename, []byte(hookContent), 0700); err != nil {
 			dief("writing hook: %v", err)
 		}
@@ -115,6 +206,24 @@ var (
 	oldFixesRETemplate = `Fixes +(issue +(%s)?#?)?(?P<issueNum>[0-9]+)`
 )
 
+var trailerRE = regexp.MustCompile(`^[A-Za-z0-9-]+: `)
+
+// endsInTrailers reports whether the final paragraph of the commit
+// message data, other than the subject line, consists only of
+// trailer lines like "Signed-off-by: Gopher <gopher@example.com>".
+func endsInTrailers(data []byte) bool {
+	i := bytes.LastIndex(data, []byte("\n\n"))
+	if i < 0 {
+		return false
+	}
+	for _, line := range lines(string(data[i+2:])) {
+		if !trailerRE.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
 // hookCommitMsg is installed as the git commit-msg hook.
 // It adds a Change-Id line to the bottom of the commit message
 // if there is not one already.
@@ -175,8 +284,10 @@ func hookCommitMsg(args []string) {
 			dief("multiple Change-Id lines")
 		}
 
-		// Add Change-Id to commit message if not present.
-		if nChangeId == 0 {
+		// Add Change-Id to commit message if not present,
+		// except for fixup! and squash! commits, which are folded
+		// into an existing commit and keep its Change-Id.
+		if nChangeId == 0 && !isFixup(data) {
 			n := len(data)
 			for n > 0 && data[n-1] == '\n' {
 				n--
@@ -185,14 +296,21 @@ func hookCommitMsg(args []string) {
 			if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
 				dief("generating Change-Id: %v", err)
 			}
-			data = append(data[:n], fmt.Sprintf("\n\nChange-Id: I%x\n", id[:])...)
+			// Join an existing trailer block such as the Signed-off-by
+			// lines added by git commit -s, so that git still sees
+			// all the trailers as one block.
+			sep := "\n\n"
+			if endsInTrailers(data[:n]) {
+				sep = "\n"
+			}
+			data = append(data[:n], fmt.Sprintf("%sChange-Id: I%x\n", sep, id[:])...)
 		}
 
 		// Add branch prefix to commit message if not present and on a
 		// dev or release branch and not a special Git fixup! or
 		// squash! commit message.
 		b := CurrentBranch()
-		branch := strings.TrimPrefix(b.OriginBranch(), "origin/")
+		_, branch := splitOriginBranch(b.OriginBranch())
 		if strings.HasPrefix(branch, "dev.") || strings.HasPrefix(branch, "release-branch.") {
 			prefix := "[" + branch + "] "
 			if !bytes.HasPrefix(data, []byte(prefix)) && !isFixup(data) {
@@ -42,6 +42,27 @@ func TestHookCommitMsgGerrit(t *testing.T) {
 	if got := testStderr.String(); got != multiple {
 		t.Fatalf("unexpected output:\ngot: %q\nwant: %q", got, multiple)
 	}
+
+	// Check that hook adds Change-Id to an existing trailer block.
+	write(t, gt.client+"/msg.txt", "Test message.\n\nSigned-off-by: Gopher <gopher@example.com>\n")
+	testMain(t, "hook-invoke", "commit-msg", gt.client+"/msg.txt")
+	if data := read(t, gt.client+"/msg.txt"); !regexp.MustCompile(`\n\nSigned-off-by: Gopher <gopher@example.com>\nChange-Id: I[0-9a-f]{40}\n\z`).Match(data) {
+		t.Fatalf("after hook-invoke commit-msg, Change-Id not in trailer block:\n%s", data)
+	}
+	write(t, gt.client+"/msg.txt", "Test message.\n\nSee: no trailer here.\nJust text.\n")
+	testMain(t, "hook-invoke", "commit-msg", gt.client+"/msg.txt")
+	if data := read(t, gt.client+"/msg.txt"); !bytes.Contains(data, []byte("Just text.\n\nChange-Id: ")) {
+		t.Fatalf("after hook-invoke commit-msg, Change-Id not in its own paragraph:\n%s", data)
+	}
+
+	// Check that hook leaves fixup! and squash! commits without a Change-Id.
+	for _, magic := range []string{"fixup!", "squash!"} {
+		write(t, gt.client+"/msg.txt", magic+" Test message.\n")
+		testMain(t, "hook-invoke", "commit-msg", gt.client+"/msg.txt")
+		if data := read(t, gt.client+"/msg.txt"); bytes.Contains(data, []byte("Change-Id: ")) {
+			t.Fatalf("after hook-invoke commit-msg with %s, found Change-Id:\n%s", magic, data)
+		}
+	}
 }
 
 func TestHookCommitMsg(t *testing.T) {
@@ -410,6 +431,127 @@ func TestHooks(t *testing.T) {
 	}
 }
 
+func TestHooksStatus(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// The stub hooks installed by newGitTest count as modified.
+	testMain(t, "hooks", "-status")
+	testPrintedStdout(t, "commit-msg: modified\n", "pre-commit: modified\n")
+
+	gt.removeStubHooks()
+	testMain(t, "hooks", "-status")
+	testPrintedStdout(t, "commit-msg: missing\n", "pre-commit: missing\n")
+
+	testMain(t, "hooks")
+	write(t, gt.client+"/.git/hooks/pre-commit", fmt.Sprintf(oldHookScript, "pre-commit"))
+	testMain(t, "hooks", "-status")
+	testPrintedStdout(t, "commit-msg: current\n", "pre-commit: stale\n")
+
+	// An edited copy of our own hook is someone's work.
+	write(t, gt.client+"/.git/hooks/pre-commit", "#!/bin/sh\nmake lint || exit\nexec git-codereview hook-invoke pre-commit\n")
+	testMain(t, "hooks", "-status")
+	testPrintedStdout(t, "pre-commit: modified\n")
+}
+
+func TestHooksRefresh(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	stale := fmt.Sprintf(oldHookScript, "commit-msg")
+	const edited = "#!/bin/sh\nmake lint || exit\nexec git-codereview hook-invoke pre-commit\n"
+	hooks := gt.client + "/.git/hooks/"
+	write(t, hooks+"commit-msg", stale)
+	write(t, hooks+"pre-commit", edited)
+
+	// Stale hooks are replaced, someone else's hooks are left alone,
+	// even if they run git-codereview.
+	testMain(t, "hooks")
+	if data := string(read(t, hooks+"commit-msg")); data != fmt.Sprintf(hookScript, "commit-msg") {
+		t.Fatalf("stale commit-msg hook not replaced:\n%s", data)
+	}
+	if data := string(read(t, hooks+"commit-msg.bak")); data != stale {
+		t.Fatalf("commit-msg.bak = %q, want %q", data, stale)
+	}
+	if data := string(read(t, hooks+"pre-commit")); data != edited {
+		t.Fatalf("modified pre-commit hook replaced:\n%s", data)
+	}
+	if _, err := os.Stat(hooks + "pre-commit.bak"); !os.IsNotExist(err) {
+		t.Fatalf("modified pre-commit hook backed up")
+	}
+
+	// -reinstall replaces modified hooks too.
+	testMain(t, "hooks", "-reinstall")
+	if data := string(read(t, hooks+"pre-commit")); data != fmt.Sprintf(hookScript, "pre-commit") {
+		t.Fatalf("modified pre-commit hook not replaced by -reinstall:\n%s", data)
+	}
+	if data := string(read(t, hooks+"pre-commit.bak")); data != edited {
+		t.Fatalf("pre-commit.bak = %q, want %q", data, edited)
+	}
+}
+
+func TestHooksAutoOff(t *testing.T) {
+	gt := newGitTest(t)
+	gt.enableGerrit(t)
+	defer gt.done()
+
+	gt.removeStubHooks()
+	trun(t, gt.client, "git", "config", "codereview.autohooks", "false")
+	testMain(t, "pending", "-l")
+	if _, err := os.Stat(gt.client + "/.git/hooks/commit-msg"); !os.IsNotExist(err) {
+		t.Fatalf("hooks installed with codereview.autohooks=false")
+	}
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	gt.work(t)
+	testMain(t, "mail")
+	testPrintedStderr(t, "warning: commit-msg hook not installed")
+
+	trun(t, gt.client, "git", "config", "codereview.autohooks", "true")
+	testMain(t, "pending", "-l")
+	if _, err := os.Stat(gt.client + "/.git/hooks/commit-msg"); err != nil {
+		t.Fatalf("hooks not installed with codereview.autohooks=true: %v", err)
+	}
+}
+
+func TestHooksNotInstalledBy(t *testing.T) {
+	gt := newGitTest(t)
+	gt.enableGerrit(t)
+	defer gt.done()
+
+	gt.removeStubHooks()
+	write(t, gt.client+"/msg.txt", "Test message.\n")
+	for _, args := range [][]string{{"help"}, {"version"}, {"completion", "bash"}, {"hook-invoke", "commit-msg", gt.client + "/msg.txt"}} {
+		testMain(t, args...)
+		if _, err := os.Stat(gt.client + "/.git/hooks/commit-msg"); !os.IsNotExist(err) {
+			t.Fatalf("hooks installed by %s", args[0])
+		}
+	}
+}
+
+func TestHooksPath(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	trun(t, gt.client, "git", "config", "core.hooksPath", "myhooks")
+	testMain(t, "hooks") // install hooks
+
+	data, err := ioutil.ReadFile(gt.client + "/myhooks/commit-msg")
+	if err != nil {
+		t.Fatalf("hooks did not write commit-msg hook to core.hooksPath: %v", err)
+	}
+	if string(data) != "#!/bin/sh\nexec git-codereview hook-invoke commit-msg \"$@\"\n" {
+		t.Fatalf("invalid commit-msg hook:\n%s", string(data))
+	}
+}
+
 var worktreeRE = regexp.MustCompile(`\sworktree\s`)
 
 func mustHaveWorktree(t *testing.T) {
@@ -492,3 +634,44 @@ func TestHookCommitMsgFromGit(t *testing.T) {
 		t.Fatalf("did not find Change-Id in git log output:\n%s", log)
 	}
 }
+
+func TestHooksUninstall(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	hooks := gt.client + "/.git/hooks/"
+	gt.removeStubHooks()
+	testMain(t, "hooks")
+	write(t, hooks+"pre-commit", "#!/bin/sh\necho my own hook\n")
+
+	// Our hook is removed, someone else's is kept.
+	testMainDied(t, "hooks", "-v", "-uninstall")
+	testPrintedStderr(t, "removing commit-msg hook", "not removing modified pre-commit hook")
+	if _, err := os.Stat(hooks + "commit-msg"); !os.IsNotExist(err) {
+		t.Errorf("commit-msg hook not removed: %v", err)
+	}
+	if _, err := os.Stat(hooks + "pre-commit"); err != nil {
+		t.Errorf("pre-commit hook removed: %v", err)
+	}
+
+	// -quiet does not hide why the command failed.
+	testMainDied(t, "hooks", "-quiet", "-uninstall")
+	testPrintedStderr(t, "not removing modified pre-commit hook")
+
+	os.Remove(hooks + "pre-commit")
+	testMain(t, "hooks", "-uninstall")
+	testNoStderr(t)
+
+	// An old hook exactly as installed is removed,
+	// an edited copy of our own hook is kept.
+	write(t, hooks+"commit-msg", fmt.Sprintf(oldHookScript, "commit-msg"))
+	write(t, hooks+"pre-commit", "#!/bin/sh\nmake lint || exit\nexec git-codereview hook-invoke pre-commit\n")
+	testMainDied(t, "hooks", "-v", "-uninstall")
+	testPrintedStderr(t, "removing commit-msg hook", "not removing modified pre-commit hook")
+	if _, err := os.Stat(hooks + "commit-msg"); !os.IsNotExist(err) {
+		t.Errorf("old commit-msg hook not removed: %v", err)
+	}
+	if _, err := os.Stat(hooks + "pre-commit"); err != nil {
+		t.Errorf("edited pre-commit hook removed: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var identityRE = regexp.MustCompile(`^\s*([^<>]*[^<>\s])\s*<([^<>\s]+)>$`)
+
+// parseIdentity splits an identity of the form "Name <email>"
+// into its name and email.
+func parseIdentity(id string) (name, email string, err error) {
+	m := identityRE.FindStringSubmatch(id)
+	if m == nil {
+		return "", "", fmt.Errorf("%q is not of the form \"Name <email>\"", id)
+	}
+	return m[1], m[2], nil
+}
+
+// useIdentity sets the author and committer of the commits that the
+// current command makes to the identity given by the -as flag value as,
+// or else by the codereview.identity setting, overriding the user.name
+// and user.email settings. With neither, it changes nothing.
+// It returns a function that restores the previous environment.
+func useIdentity(as string) (restore func()) {
+	id, what := as, "-as"
+	if id == "" {
+		id, what = gitConfig("identity"), "codereview.identity setting"
+	}
+	if id == "" {
+		return func() {}
+	}
+	name, email, err := parseIdentity(id)
+	if err != nil {
+		exitf(exitUsage, "invalid %s: %v", what, err)
+	}
+	verbosef("committing as %s <%s>", name, email)
+	vars := map[string]string{
+		"GIT_AUTHOR_NAME":     name,
+		"GIT_AUTHOR_EMAIL":    email,
+		"GIT_COMMITTER_NAME":  name,
+		"GIT_COMMITTER_EMAIL": email,
+	}
+	var restores []func()
+	for key, value := range vars {
+		key := key
+		if old, ok := os.LookupEnv(key); ok {
+			restores = append(restores, func() { os.Setenv(key, old) })
+		} else {
+			restores = append(restores, func() { os.Unsetenv(key) })
+		}
+		os.Setenv(key, value)
+	}
+	return func() {
+		for _, f := range restores {
+			f()
+		}
+	}
+}
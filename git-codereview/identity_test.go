@@ -0,0 +1,77 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+var parseIdentityTests = []struct {
+	id, name, email string
+}{
+	{"Gopher <gopher@example.com>", "Gopher", "gopher@example.com"},
+	{"Build Bot<bot@example.com>", "Build Bot", "bot@example.com"},
+	{"  Gopher   <gopher@example.com>", "Gopher", "gopher@example.com"},
+	{"gopher@example.com", "", ""},
+	{"<gopher@example.com>", "", ""},
+	{"Gopher <>", "", ""},
+	{"Gopher <gopher@example.com", "", ""},
+	{"Gopher <gopher@example.com> extra", "", ""},
+	{"Gopher <go pher@example.com>", "", ""},
+	{"Go<pher <gopher@example.com>", "", ""},
+}
+
+func TestParseIdentity(t *testing.T) {
+	for _, tt := range parseIdentityTests {
+		name, email, err := parseIdentity(tt.id)
+		if tt.name == "" {
+			if err == nil {
+				t.Errorf("parseIdentity(%q) = %q, %q, want error", tt.id, name, email)
+			}
+			continue
+		}
+		if err != nil || name != tt.name || email != tt.email {
+			t.Errorf("parseIdentity(%q) = %q, %q, %v, want %q, %q, nil", tt.id, name, email, err, tt.name, tt.email)
+		}
+	}
+}
+
+func TestChangeIdentity(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	identity := func() string {
+		return trim(trun(t, gt.client, "git", "log", "-n", "1", "--format=%an <%ae>|%cn <%ce>"))
+	}
+
+	write(t, gt.client+"/file", "new content")
+	trun(t, gt.client, "git", "add", "file")
+	testMain(t, "change", "-as", "Build Bot <bot@example.com>", "-m", "foo: as bot", "work")
+	if id := identity(); id != "Build Bot <bot@example.com>|Build Bot <bot@example.com>" {
+		t.Fatalf("commit identity = %q, want Build Bot as author and committer", id)
+	}
+	if _, ok := os.LookupEnv("GIT_AUTHOR_NAME"); ok {
+		t.Fatalf("GIT_AUTHOR_NAME still set after change -as")
+	}
+
+	// codereview.identity is the default for amends too.
+	trun(t, gt.client, "git", "config", "codereview.identity", "Other Bot <other@example.com>")
+	write(t, gt.client+"/file", "newer content")
+	testMain(t, "change", "-a", "-q")
+	if id := identity(); id != "Build Bot <bot@example.com>|Other Bot <other@example.com>" {
+		t.Fatalf("commit identity = %q, want original author and Other Bot as committer", id)
+	}
+
+	testMainDied(t, "change", "-as", "bot@example.com", "-a", "-q")
+	testPrintedStderr(t, `invalid -as: "bot@example.com" is not of the form "Name <email>"`)
+
+	trun(t, gt.client, "git", "config", "codereview.identity", "Other Bot")
+	testMainDied(t, "change", "-a", "-q")
+	testPrintedStderr(t, `invalid codereview.identity setting: "Other Bot" is not of the form "Name <email>"`)
+
+	testMainDied(t, "config", "identity", "Other Bot")
+	testPrintedStderr(t, `invalid value for codereview.identity: "Other Bot" is not of the form "Name <email>"`)
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func cmdImport(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s import %s commit [branchname]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) < 1 || len(flags.Args()) > 2 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	rev := flags.Arg(0)
+	hash, err := trimErr(cmdOutputErr("git", "rev-parse", "--verify", "-q", rev+"^{commit}"))
+	if err != nil {
+		dief("cannot import: %s is not a commit", rev)
+	}
+	subject := trim(cmdOutput("git", "log", "-n", "1", "--format=%s", hash, "--"))
+	name := flags.Arg(1)
+	if name == "" {
+		name = branchNameFromSubject(subject)
+		if name == "" {
+			dief("cannot import: cannot derive a branch name from %q; give one on the command line", subject)
+		}
+	}
+
+	// Switching branches would carry uncommitted work along.
+	checkStaged("import")
+	checkUnstaged("import")
+
+	current := CurrentBranch()
+	origin := current.OriginBranch()
+	if current.DetachedHead() {
+		origin = "origin/" + upstreamBranch()
+	}
+	createWorkBranchAt(name, origin)
+
+	if err := runErr("git", "cherry-pick", "--allow-empty", hash); err != nil {
+		var files []string
+		if pathExists(gitPath("CHERRY_PICK_HEAD")) {
+			files = nonBlankLines(cmdOutput("git", "diff", "--name-only", "--diff-filter=U"))
+			run("git", "cherry-pick", "--abort")
+		}
+		// Put everything back the way it was.
+		run("git", "checkout", "-q", current.Name)
+		run("git", "branch", "-q", "-D", name)
+		if len(files) == 0 {
+			dieRun(err, "git", "cherry-pick", hash)
+		}
+		dief("cannot import %s: conflicts with %s in:\n"+
+			"\t\t%s\n"+
+			"\tthe branch %s was not created; to resolve the conflicts by hand, run\n"+
+			"\t\t%s change -base %s %s\n"+
+			"\t\tgit cherry-pick %s\n"+
+			"\tthen resolve them and run 'git cherry-pick --continue'",
+			shortHash(hash), origin, strings.Join(files, "\n\t\t"), name, os.Args[0], origin, name, shortHash(hash))
+	}
+
+	// The Change-Id line is kept, so if the commit was mailed before,
+	// the imported commit updates the same change. A commit without
+	// one gets one from the commit-msg hook when the message is amended.
+	c := CurrentBranch().Pending()[0]
+	if c.ChangeID == "" {
+		run("git", "commit", "-q", "--amend", "--no-edit")
+	}
+	printf("imported %s %s into branch %s.", shortHash(hash), subject, name)
+}
+
+// shortHash returns the abbreviated form of the commit hash.
+func shortHash(hash string) string {
+	return trim(cmdOutput("git", "rev-parse", "--short", hash))
+}
+
+// branchNameFromSubject derives a work branch name from a commit subject,
+// such as "fix-parse-error" from "cmd/go: fix parse error".
+// It returns an empty string if the subject has nothing usable.
+func branchNameFromSubject(subject string) string {
+	if i := strings.Index(subject, ": "); i >= 0 {
+		subject = subject[i+2:]
+	}
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(subject), func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+	}) {
+		words = append(words, w)
+		if len(words) == 5 {
+			break
+		}
+	}
+	return strings.Join(words, "-")
+}
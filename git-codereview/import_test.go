@@ -0,0 +1,66 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// A stack of two changes on work.
+	gt.work(t)
+	write(t, gt.client+"/other", "other content")
+	trun(t, gt.client, "git", "add", "other")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "foo: add other file\n\nChange-Id: I0123456789")
+	hash := trim(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+
+	testMainDied(t, "import", "nosuchcommit")
+	testPrintedStderr(t, "cannot import: nosuchcommit is not a commit")
+
+	testMain(t, "import", "HEAD")
+	testPrintedStderr(t, "created branch add-other-file tracking origin/master", "imported ")
+	b := CurrentBranch()
+	if b.Name != "add-other-file" {
+		t.Fatalf("on branch %s after import, want add-other-file", b.Name)
+	}
+	work := b.Pending()
+	if len(work) != 1 || work[0].ChangeID != "I0123456789" || commitTree(work[0]) == commitTree(&Commit{Hash: hash}) {
+		t.Fatalf("after import have %d pending commits; want one new commit with Change-Id I0123456789", len(work))
+	}
+
+	// The first change conflicts with upstream work.
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	write(t, gt.server+"/file", "conflicting content")
+	trun(t, gt.server, "git", "commit", "-q", "-a", "-m", "conflict")
+	trun(t, gt.client, "git", "fetch", "-q")
+	testMainDied(t, "import", "HEAD^", "first")
+	testPrintedStderr(t, "cannot import", "conflicts with origin/master in:\n\t\tfile\n", "change -base origin/master first")
+	if b := CurrentBranch(); b.Name != "work" {
+		t.Fatalf("on branch %s after failed import, want work", b.Name)
+	}
+	for _, b := range LocalBranches() {
+		if b.Name == "first" {
+			t.Fatalf("branch first left behind by failed import")
+		}
+	}
+}
+
+func TestBranchNameFromSubject(t *testing.T) {
+	for _, tt := range []struct {
+		subject, name string
+	}{
+		{"cmd/go: fix parse error", "fix-parse-error"},
+		{"Fix the Build (again)", "fix-the-build-again"},
+		{"all: update one two three four five six", "update-one-two-three-four"},
+		{"x: ???", ""},
+	} {
+		if name := branchNameFromSubject(tt.subject); name != tt.name {
+			t.Errorf("branchNameFromSubject(%q) = %q, want %q", tt.subject, name, tt.name)
+		}
+	}
+}
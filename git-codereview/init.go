@@ -0,0 +1,108 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var initBranch string // -branch flag
+
+func cmdInit(args []string) {
+	flags.StringVar(&initBranch, "branch", "", "use `name` as the upstream branch instead of detecting it")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s init %s [-branch name]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	show := func(key, value string) {
+		fmt.Fprintf(stdout(), "%-8s %s\n", key+":", value)
+	}
+
+	// Hooks. Like the hooks command, keep hooks that someone else wrote.
+	before := initHookStates()
+	installHook(nil)
+	after := initHookStates()
+	switch {
+	case after[hookModified] != nil:
+		show("hooks", "kept modified "+strings.Join(after[hookModified], ", "))
+		printf("warning: git-codereview hooks not installed; use '%s hooks -reinstall' to replace the modified ones", os.Args[0])
+	case len(before[hookCurrent]) == len(hookFiles):
+		show("hooks", "already installed")
+	default:
+		show("hooks", "installed "+strings.Join(hookFiles, ", "))
+	}
+
+	// Remote.
+	remote := gitConfigDefault("remote")
+	url, err := trimErr(cmdOutputErr("git", "remote", "get-url", "--push", remote))
+	if err != nil {
+		dief("cannot find remote %s; add it with 'git remote add %s <url>'", remote, remote)
+	}
+	show("remote", remote+" "+url)
+
+	// Upstream branch.
+	branch, source := initUpstream()
+	if _, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", "refs/remotes/origin/"+branch); err != nil {
+		dief("cannot find upstream branch origin/%s (%s)", branch, source)
+	}
+	if source != "codereview.cfg" && branch != upstreamBranch() {
+		setGitConfig("branch", branch)
+		source += ", saved as codereview.branch"
+	}
+	show("branch", "origin/"+branch+" ("+source+")")
+
+	// Gerrit.
+	if haveGerrit() {
+		loadGerritOrigin()
+		show("gerrit", auth.url+" (project "+auth.project+")")
+	} else {
+		show("gerrit", "(none)")
+		printf("warning: origin is not a Gerrit server; set gerrit in %s to use Gerrit", configPath)
+	}
+
+	printf("ready; use '%s change <branchname>' to start a change.", os.Args[0])
+}
+
+// initHookStates returns the names of the hooks in each hook state.
+func initHookStates() map[string][]string {
+	states := make(map[string][]string)
+	for _, hookFile := range hookFiles {
+		data, err := ioutil.ReadFile(filepath.Join(gitPath("hooks"), hookFile))
+		if err != nil && !os.IsNotExist(err) {
+			dief("checking hook: %v", err)
+		}
+		state := hookState(hookFile, data)
+		states[state] = append(states[state], hookFile)
+	}
+	return states
+}
+
+// initUpstream returns the upstream branch to use for the init command
+// and a description of where it came from: codereview.cfg, the -branch
+// flag, the codereview.branch setting, or the default branch of origin.
+func initUpstream() (branch, source string) {
+	if branch := config()["branch"]; branch != "" {
+		return branch, "codereview.cfg"
+	}
+	if initBranch != "" {
+		return strings.TrimPrefix(strings.TrimPrefix(initBranch, "refs/heads/"), "origin/"), "-branch"
+	}
+	if branch := gitConfig("branch"); branch != "" {
+		return branch, "codereview.branch"
+	}
+	// origin/HEAD names the default branch of origin as of the clone.
+	if head, err := trimErr(cmdOutputErr("git", "symbolic-ref", "-q", "--short", "refs/remotes/origin/HEAD")); err == nil && strings.HasPrefix(head, "origin/") {
+		return strings.TrimPrefix(head, "origin/"), "default branch of origin"
+	}
+	return "master", "default"
+}
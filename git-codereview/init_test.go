@@ -0,0 +1,54 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestInit(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// The test stub hooks count as modified and are kept.
+	testMain(t, "init")
+	testPrintedStdout(t, "hooks:   kept modified", "remote:  origin "+gt.server, "branch:  origin/master (default branch of origin)", "gerrit:  (none)")
+	testPrintedStderr(t, "hooks -reinstall", "origin is not a Gerrit server", "ready;")
+	if value := trim(trun(t, gt.client, "git", "config", "--default", "", "codereview.branch")); value != "" {
+		t.Fatalf("codereview.branch = %q, want unset", value)
+	}
+
+	gt.removeStubHooks()
+	testMain(t, "init")
+	testPrintedStdout(t, "hooks:   installed commit-msg")
+	testMain(t, "init")
+	testPrintedStdout(t, "hooks:   already installed")
+
+	// A different upstream branch is saved and used for new branches.
+	testMainDied(t, "init", "-branch", "nonexistent")
+	testPrintedStderr(t, "cannot find upstream branch origin/nonexistent (-branch)")
+	testMain(t, "init", "-branch", "origin/dev.branch")
+	testPrintedStdout(t, "branch:  origin/dev.branch (-branch, saved as codereview.branch)")
+	testMain(t, "init")
+	testPrintedStdout(t, "branch:  origin/dev.branch (codereview.branch)")
+	testRan(t)
+	if b := upstreamBranch(); b != "dev.branch" {
+		t.Fatalf("upstreamBranch() = %q, want dev.branch", b)
+	}
+
+	// With Gerrit.
+	srv := newGerritServer(t)
+	defer srv.done()
+	write(t, gt.server+"/codereview.cfg", "gerrit: on")
+	trun(t, gt.server, "git", "add", "codereview.cfg")
+	trun(t, gt.server, "git", "commit", "-m", "codereview.cfg on master")
+	trun(t, gt.client, "git", "pull", "-q", "origin", "master")
+	testMain(t, "init")
+	testPrintedStdout(t, "gerrit:  "+auth.url+" (project proj)")
+	testPrintedStderr(t, "!not a Gerrit server")
+
+	// A missing remote fails.
+	trun(t, gt.client, "git", "config", "codereview.remote", "nosuch")
+	testMainDied(t, "init")
+	testPrintedStderr(t, "cannot find remote nosuch")
+}
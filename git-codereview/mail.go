@@ -6,60 +6,209 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
+// mailNoVerify is set by mail -no-verify, which skips the client-side
+// checks before mailing, both mail's own and git's pre-push hook.
+var mailNoVerify bool
+
 func cmdMail(args []string) {
 	var (
-		diff   = flags.Bool("diff", false, "show change commit diff and don't upload or mail")
-		force  = flags.Bool("f", false, "mail even if there are staged changes")
-		topic  = flags.String("topic", "", "set Gerrit topic")
-		trybot = flags.Bool("trybot", false, "run trybots on the uploaded CLs")
-		rList  = new(stringList) // installed below
-		ccList = new(stringList) // installed below
+		diff      = flags.Bool("diff", false, "show change commit diff and don't upload or mail")
+		as        = flags.String("as", "", "amend as `identity` \"Name <email>\" instead of the git user")
+		against   = flags.String("against", "", "with -diff, show the changes since the merge base with `ref`")
+		force     = flags.Bool("f", false, "mail even if there are staged changes")
+		overwrite = flags.Bool("force", false, "with codereview.backend=github, overwrite the remote branch even if it changed")
+		forBranch = flags.String("for", "", "mail for review on the server `branch` instead of the upstream branch")
+		message   = flags.String("m", "", "attach `msg` to the new patch set as a review comment")
+		open      = flags.Bool("open", false, "open the change in a web browser after mailing it")
+		stat      = flags.Bool("stat", false, "with -diff, show only a diffstat")
+		staged    = flags.Bool("staged", false, "with -diff, show the staged changes instead of the change commit")
+		worktree  = flags.Bool("worktree", false, "with -diff, show the unstaged changes instead of the change commit")
+		names     = flags.Bool("name-only", false, "with -diff, show only the names of changed files")
+		wordDiff  = flags.Bool("word-diff", false, "with -diff, show changed words instead of changed lines")
+		colorWord = flags.Bool("color-words", false, "with -diff, show changed words using only color")
+		owners    = flags.Bool("owners", false, "add reviewers from OWNERS files")
+		remote    = flags.String("remote", "", "push to `remote` instead of the codereview.remote setting or origin")
+		topic     = flags.String("topic", "", "set Gerrit topic")
+		trybot    = flags.Bool("trybot", false, "run trybots on the uploaded CLs")
+		wip       = flags.Bool("wip", false, "set the status of a change to Work-in-Progress")
+		ready     = flags.Bool("ready", false, "set the status of a change to Ready-for-Review")
+		rList     = new(stringList) // installed below
+		ccList    = new(stringList) // installed below
+		labels    = new(stringList) // installed below
 	)
 	flags.Var(rList, "r", "comma-separated list of reviewers")
 	flags.Var(ccList, "cc", "comma-separated list of people to CC:")
+	flags.BoolVar(&mailNoVerify, "no-verify", false, "skip the checks before mailing, including the pre-push hook")
+	flags.Var(labels, "label", "comma-separated list of votes to cast, like Code-Review+2 (for automation)")
 
 	flags.Usage = func() {
-		fmt.Fprintf(stderr(), "Usage: %s mail %s [-r reviewer,...] [-cc mail,...] [-topic topic] [-trybot] [commit]\n", os.Args[0], globalFlags)
+		fmt.Fprintf(stderr(), "Usage: %s mail %s [-r reviewer,...] [-cc mail,...] [-as identity] [-for branch] [-label vote,...] [-m msg] [-force] [-no-verify] [-open] [-owners] [-remote remote] [-topic topic] [-trybot] [-wip | -ready] [commit]\n", os.Args[0], globalFlags)
+		fmt.Fprintf(stderr(), "       %s mail %s -diff [-stat | -name-only] [-word-diff | -color-words] [-against ref | -staged | -worktree] [commit] [-- [git-diff-option...] [--] pathspec...]\n", os.Args[0], globalFlags)
+	}
+
+	// Split off any paths after "--", to restrict the -diff output.
+	// Leading options among them, up to an optional second "--",
+	// pass through to git diff.
+	var paths, diffOpts []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, paths = args[:i], args[i+1:]
+			break
+		}
 	}
+	for len(paths) > 0 && strings.HasPrefix(paths[0], "-") {
+		opt := paths[0]
+		paths = paths[1:]
+		if opt == "--" {
+			break
+		}
+		diffOpts = append(diffOpts, opt)
+	}
+
 	flags.Parse(args)
-	if len(flags.Args()) > 1 {
+	if len(flags.Args()) > 1 || (len(paths) > 0 || len(diffOpts) > 0 || *stat || *names || *wordDiff || *colorWord || *against != "" || *staged || *worktree) && !*diff || *wip && *ready || *stat && *names || *wordDiff && *colorWord ||
+		countTrue(*against != "", *staged, *worktree) > 1 || (*staged || *worktree) && len(flags.Args()) > 0 {
 		flags.Usage()
-		os.Exit(2)
+		os.Exit(exitUsage)
+	}
+
+	diffArgs := func() []string {
+		args := []string{"diff"}
+		if *stat {
+			args = append(args, "--stat")
+		}
+		if *names {
+			args = append(args, "--name-only")
+		}
+		if *wordDiff {
+			args = append(args, "--word-diff")
+		}
+		if *colorWord {
+			args = append(args, "--color-words")
+		}
+		return append(args, diffOpts...)
+	}
+	if *staged || *worktree {
+		// These show what the next change command would fold into
+		// the change commit, and so do not need one yet.
+		args := diffArgs()
+		if *staged {
+			args = append(args, "--cached")
+		}
+		run("git", append(append(args, "--"), paths...)...)
+		return
 	}
 
 	b := CurrentBranch()
+	b.checkAttached("mail")
 
 	var c *Commit
 	if len(flags.Args()) == 1 {
 		c = b.CommitByRev("mail", flags.Arg(0))
+	} else if work := b.Pending(); mailNoVerify && !*diff && len(work) > 1 {
+		// Mail all the pending commits, as 'mail HEAD' would.
+		c = work[0]
+		printf("-no-verify: mailing all %d pending commits", len(work))
 	} else {
 		c = b.DefaultCommit("mail", "must specify commit on command line")
 	}
 
 	if *diff {
-		run("git", "diff", b.Branchpoint()[:7]+".."+c.ShortHash, "--")
+		args := diffArgs()
+		if *against != "" {
+			// Three dots: diff from the merge base of ref and c.
+			if _, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", *against+"^{commit}"); err != nil {
+				dief("cannot diff: %s is not a commit", *against)
+			}
+			args = append(args, *against+"..."+c.ShortHash, "--")
+		} else {
+			args = append(args, b.Branchpoint()[:7]+".."+c.ShortHash, "--")
+		}
+		run("git", append(args, paths...)...)
 		return
 	}
 
-	if len(ListFiles(c)) == 0 {
-		dief("cannot mail: commit %s is empty", c.ShortHash)
+	if mailNoVerify {
+		verbosef("-no-verify: skipping the checks for empty commits, staged changes, and a Change-Id line, and the pre-push hook")
+	} else {
+		if len(ListFiles(c)) == 0 {
+			exitf(exitNoChanges, "cannot mail: commit %s is empty", c.ShortHash)
+		}
+		if !*force && HasStagedChanges() {
+			dief("there are staged changes; aborting.\n"+
+				"Use '%s change' to include them or '%s mail -f' to force it.", os.Args[0], os.Args[0])
+		}
 	}
 
-	if !*force && HasStagedChanges() {
-		dief("there are staged changes; aborting.\n"+
-			"Use '%s change' to include them or '%s mail -f' to force it.", os.Args[0], os.Args[0])
+	if reviewBackend() == "github" {
+		if *ccList != "" || *labels != "" || *message != "" || *owners || *ready || *topic != "" || *trybot {
+			dief("cannot mail: -cc, -label, -m, -owners, -ready, -topic, and -trybot are not supported with GitHub")
+		}
+		*remote = mailRemote(*remote)
+		_, base := splitOriginBranch(b.OriginBranch())
+		if *forBranch != "" {
+			base = checkForBranch(*forBranch)
+		}
+		var reviewers []string
+		if *rList != "" {
+			reviewers = strings.Split(string(*rList), ",")
+		}
+		url := mailGitHub(b, c, *remote, base, reviewers, *wip, *overwrite)
+		run("git", "tag", "-f", b.Name+".mailed", c.ShortHash)
+		if *noRun {
+			return
+		}
+		printf("mailed %s: %s", c.ShortHash, url)
+		runPostMailHook(b, c, url)
+		if *open {
+			openBrowser(url)
+		}
+		return
+	}
+
+	if *overwrite {
+		dief("cannot mail: -force applies only when codereview.backend is github")
+	}
+	defer useIdentity(*as)()
+	if c.ChangeID == "" && !mailNoVerify {
+		c = addChangeID(b, c)
 	}
 
 	// for side effect of dying with a good message if origin is GitHub
 	loadGerritOrigin()
 
+	*remote = mailRemote(*remote)
+
+	if !gitConfigBool("autohooks", true) {
+		if data, _ := ioutil.ReadFile(filepath.Join(gitPath("hooks"), "commit-msg")); hookState("commit-msg", data) == hookMissing {
+			printf("warning: commit-msg hook not installed (codereview.autohooks is false); run '%s hooks' to install it", os.Args[0])
+		}
+	}
+
+	if *owners {
+		if list := ownersReviewers(c); len(list) > 0 {
+			rList.Set(strings.Join(list, ","))
+			verbosef("adding reviewers from OWNERS files: %s", strings.Join(list, ", "))
+		} else {
+			printf("no owners found in OWNERS files; mailing without them")
+		}
+	}
+
 	refSpec := b.PushSpec(c)
+	if *forBranch != "" {
+		refSpec = strings.SplitN(refSpec, ":", 2)[0] + ":refs/for/" + checkForBranch(*forBranch)
+	}
 	start := "%"
 	if *rList != "" {
 		refSpec += mailList(start, "r", string(*rList))
@@ -69,6 +218,9 @@ func cmdMail(args []string) {
 		refSpec += mailList(start, "cc", string(*ccList))
 		start = ","
 	}
+	if *topic == "" && gitConfigBool("topicfrombranch", false) {
+		*topic = b.Name
+	}
 	if *topic != "" {
 		// There's no way to escape the topic, but the only
 		// ambiguous character is ',' (though other characters
@@ -76,6 +228,9 @@ func cmdMail(args []string) {
 		if strings.Contains(*topic, ",") {
 			dief("topic may not contain a comma")
 		}
+		if strings.IndexFunc(*topic, unicode.IsSpace) >= 0 {
+			dief("topic may not contain spaces")
+		}
 		refSpec += start + "topic=" + *topic
 		start = ","
 	}
@@ -83,7 +238,33 @@ func cmdMail(args []string) {
 		refSpec += start + "l=Run-TryBot"
 		start = ","
 	}
-	run("git", "push", "-q", "origin", refSpec)
+	if *labels != "" {
+		for _, vote := range strings.Split(string(*labels), ",") {
+			if !voteRE.MatchString(vote) {
+				dief("invalid -label vote %q: must be a label name followed by +N or -N, like Code-Review+2", vote)
+			}
+			refSpec += start + "l=" + vote
+			start = ","
+		}
+	}
+	if *wip {
+		refSpec += start + "wip"
+		start = ","
+	}
+	if *ready {
+		refSpec += start + "ready"
+		start = ","
+	}
+	if *message != "" {
+		refSpec += start + "m=" + pushMessage(*message)
+		start = ","
+	}
+	if parent := b.Parent(); parent != "" {
+		// The push carries the parent's pending changes along,
+		// and Gerrit links the changes into a relation chain.
+		printf("%s is stacked on %s; mailing its changes on top of the ones in %s.", b.Name, parent, parent)
+	}
+	out := mailPush(*remote, refSpec)
 
 	// Create local tag for mailed change.
 	// If in the 'work' branch, this creates or updates work.mailed.
@@ -97,6 +278,267 @@ func cmdMail(args []string) {
 	// for work, because git change rejects any name containing a dot.
 	// The space of names with dots is ours (the Go team's) to define.
 	run("git", "tag", "-f", b.Name+".mailed", c.ShortHash)
+
+	// Repeat the change URL, which is easy to miss in the push output.
+	url := changeURL(out)
+	if url != "" {
+		saveChangeURL(c, url)
+		printf("mailed %s: %s", c.ShortHash, url)
+	} else {
+		printf("mailed %s.", c.ShortHash)
+	}
+	runPostMailHook(b, c, url)
+	if *open {
+		if url == "" {
+			url = changeWebURL(b, c)
+		}
+		openBrowser(url)
+	}
+}
+
+// runPostMailHook runs the shell command set in codereview.postmailhook,
+// if any, after c from branch b has been mailed for review as the change
+// or pull request at url (which is empty if the push did not print one).
+// The command finds out what was mailed from the environment variables
+// CODEREVIEW_BRANCH, CODEREVIEW_COMMIT, CODEREVIEW_CHANGE_URL, and
+// CODEREVIEW_CHANGE, the change or pull request number.
+// The mailing has already succeeded, so a failing command only
+// draws a warning.
+func runPostMailHook(b *Branch, c *Commit, url string) {
+	hook := gitConfig("postmailhook")
+	if hook == "" {
+		return
+	}
+	number := url[strings.LastIndex(url, "/")+1:]
+	if _, err := strconv.Atoi(number); err != nil {
+		number = ""
+	}
+	env := map[string]string{
+		"CODEREVIEW_BRANCH":     b.Name,
+		"CODEREVIEW_COMMIT":     c.Hash,
+		"CODEREVIEW_CHANGE_URL": url,
+		"CODEREVIEW_CHANGE":     number,
+	}
+	for key, value := range env {
+		if old, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		os.Setenv(key, value)
+	}
+	if err := runErr("sh", "-c", hook); err != nil {
+		printf("warning: codereview.postmailhook command failed: %v", err)
+	}
+}
+
+// changeURLRE matches the URL of a change in the output of a Gerrit push,
+// either https://host/c/project/+/NNNN or https://host/NNNN.
+var changeURLRE = regexp.MustCompile(`(?m)^remote:\s+(https?://\S+/\d+)(\s|$)`)
+
+// changeURL returns the last change URL printed by a Gerrit push,
+// or "" if there is none.
+func changeURL(out string) string {
+	m := changeURLRE.FindAllStringSubmatch(out, -1)
+	if m == nil {
+		return ""
+	}
+	return m[len(m)-1][1]
+}
+
+// pushRetryDelay is the delay before the first retry of a failed push.
+// Each later retry waits twice as long as the one before.
+// It is a variable so that tests can shorten it.
+var pushRetryDelay = 2 * time.Second
+
+// transientPushErrors lists fragments of git push errors that suggest
+// that trying again may succeed.
+var transientPushErrors = []string{
+	"Connection reset",
+	"Connection timed out",
+	"Operation timed out",
+	"RPC failed",
+	"The requested URL returned error: 502",
+	"The requested URL returned error: 503",
+	"The requested URL returned error: 504",
+	"early EOF",
+}
+
+// mailRemote returns the remote to push to: the -remote flag value
+// if set, or else the codereview.remote setting, or else the ssh URL
+// of the Gerrit server named in a .gitreview file, or else the Gerrit
+// remote chosen by chooseGerritRemote, or else origin.
+// It dies if there is no such remote.
+func mailRemote(flag string) string {
+	remote := flag
+	if remote == "" {
+		remote = gitConfig("remote")
+	}
+	if remote == "" && reviewBackend() == "gerrit" {
+		// A .gitreview file names the server for projects whose origin
+		// is a plain mirror; an origin that is a Gerrit server is used.
+		if config()["gerrit"] == "" && !isGerritRemote("origin") {
+			if gr := loadGitReview(); gr != nil {
+				verbosef("pushing to %s from .gitreview", gr.pushURL())
+				return gr.pushURL()
+			}
+		}
+		remote = chooseGerritRemote()
+	}
+	if remote == "" {
+		remote = "origin"
+	}
+	checkRemote(remote)
+	return remote
+}
+
+// chooseGerritRemote handles a repository with more than one remote
+// that looks like a Gerrit server (see isGerritRemote), where guessing
+// origin might mail the change to the wrong server. It asks the user
+// which remote to use and saves the answer as codereview.remote,
+// or, if there is no one to ask, dies listing the choices.
+// It returns "" if there are fewer than two Gerrit remotes.
+func chooseGerritRemote() string {
+	var names []string
+	for _, name := range nonBlankLines(cmdOutput("git", "remote")) {
+		if isGerritRemote(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) < 2 {
+		return ""
+	}
+	list := strings.Join(names, ", ")
+	if !stdinIsTerminal() {
+		dief("cannot mail: there are multiple Gerrit remotes (%s); use -remote or set codereview.remote to choose one", list)
+	}
+	fmt.Fprintf(stderr(), "there are multiple Gerrit remotes; mail to which one (%s)? ", list)
+	var answer string
+	fmt.Scan(&answer)
+	for _, name := range names {
+		if answer == name {
+			setGitConfig("remote", name)
+			printf("saved codereview.remote=%s.", name)
+			return name
+		}
+	}
+	dief("cannot mail: %q is not one of the Gerrit remotes (%s)", answer, list)
+	panic("not reached")
+}
+
+// isGerritRemote reports whether the URL of the named remote looks like
+// a Gerrit server: a *.googlesource.com host, an ssh URL using Gerrit's
+// port 29418, or a URL under the gerrit origin set in codereview.cfg.
+func isGerritRemote(name string) bool {
+	u, err := trimErr(cmdOutputErr("git", "config", "--get", "remote."+name+".url"))
+	if err != nil {
+		return false
+	}
+	if origin := config()["gerrit"]; origin != "" && strings.HasPrefix(u, origin) {
+		return true
+	}
+	return strings.Contains(u, ".googlesource.com/") || strings.Contains(u, ":29418/")
+}
+
+// checkRemote dies if there is no git remote with the given name.
+func checkRemote(name string) {
+	if _, err := cmdOutputErr("git", "remote", "get-url", name); err == nil {
+		return
+	}
+	remotes := nonBlankLines(cmdOutput("git", "remote"))
+	if len(remotes) == 0 {
+		dief("cannot mail: no remote named %s (there are no remotes)", name)
+	}
+	dief("cannot mail: no remote named %s; configured remotes are:\n\t%s", name, strings.Join(remotes, "\n\t"))
+}
+
+// addChangeID handles a commit c that has no Change-Id line, which Gerrit
+// would reject. If c is the HEAD commit, it offers to amend c, letting the
+// commit-msg hook (if installed) add the Change-Id line, and returns the
+// amended commit.
+// Otherwise, or if the user declines, it dies explaining how to fix c.
+func addChangeID(b *Branch, c *Commit) *Commit {
+	if c.Hash == trim(cmdOutput("git", "rev-parse", "HEAD")) {
+		if confirm(fmt.Sprintf("commit %s has no Change-Id line; amend it to add one", c.ShortHash)) {
+			run("git", "commit", "-q", "--amend", "--no-edit")
+			c = CurrentBranch().CommitByRev("mail", "HEAD")
+			if c.ChangeID != "" {
+				printf("added Change-Id to %s.", c.ShortHash)
+				return c
+			}
+		}
+	}
+	dief("cannot mail: commit %s has no Change-Id line\n"+
+		"\trun '%s hooks' to install the commit-msg hook, then\n"+
+		"\trun 'git commit --amend --no-edit' (or 'git rebase -i') to add one", c.ShortHash, os.Args[0])
+	panic("not reached")
+}
+
+// checkForBranch checks the -for flag's branch name and returns it
+// without any refs/heads/ or origin/ prefix. It warns if origin
+// does not have the branch, which is likely a typo.
+func checkForBranch(name string) string {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "refs/heads/"), "origin/")
+	if _, err := cmdOutputErr("git", "check-ref-format", "--branch", name); err != nil || strings.HasPrefix(name, "-") {
+		dief("cannot mail: invalid branch name %q", name)
+	}
+	found := false
+	for _, b := range OriginBranches() {
+		if b == "origin/"+name {
+			found = true
+		}
+	}
+	if !found {
+		printf("warning: origin has no branch %s (as of the last fetch)", name)
+	}
+	return name
+}
+
+// mailPush pushes refSpec to remote and returns the push's standard error,
+// where the server's messages appear. Any extra flags are passed to git push.
+// If the push fails in a way that looks transient, mailPush retries it,
+// by default up to 3 times; the git config setting codereview.uploadretries
+// changes the limit.
+func mailPush(remote, refSpec string, extra ...string) string {
+	s := gitConfigDefault("uploadretries")
+	retries, err := strconv.Atoi(s)
+	if err != nil || retries < 0 {
+		dief("invalid codereview.uploadretries setting %q: must be a non-negative integer", s)
+	}
+	args := append([]string{"push", "-q"}, extra...)
+	if mailNoVerify {
+		args = append(args, "--no-verify")
+	}
+	args = append(args, remote, refSpec)
+	delay := pushRetryDelay
+	for try := 0; ; try++ {
+		out, err := runRemoteCaptureErr("git", args...)
+		if err == nil {
+			return out
+		}
+		if strings.Contains(out, "(stale info)") {
+			// A --force-with-lease push found an unexpected remote branch.
+			dief("cannot mail: %s on %s has changed since it was last mailed; someone else may have pushed to it\n"+
+				"\tfetch and inspect it, then use 'mail -force' to overwrite it", strings.SplitN(refSpec, ":", 2)[1], remote)
+		}
+		if try >= retries || !isTransientPushError(out) {
+			dieRun(err, "git", args...)
+		}
+		verbosef("push failed; retrying in %v (retry %d of %d)", delay, try+1, retries)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientPushError reports whether the git push error output
+// looks like a temporary network or server problem.
+func isTransientPushError(out string) bool {
+	for _, s := range transientPushErrors {
+		if strings.Contains(out, s) {
+			return true
+		}
+	}
+	return false
 }
 
 // PushSpec returns the spec for a Gerrit push command to publish the change c in b.
@@ -106,7 +548,31 @@ func (b *Branch) PushSpec(c *Commit) string {
 	if c != nil && (len(b.Pending()) == 0 || b.Pending()[0].Hash != c.Hash) {
 		local = c.ShortHash
 	}
-	return local + ":refs/for/" + strings.TrimPrefix(b.OriginBranch(), "origin/")
+	_, branch := splitOriginBranch(b.OriginBranch())
+	return local + ":refs/for/" + branch
+}
+
+// voteRE matches a vote on a Gerrit label, like Code-Review+2 or Verified-1.
+var voteRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*[+-][0-9]+$`)
+
+// pushMessage encodes msg for the m= option of a Gerrit push,
+// which Gerrit URL-decodes after turning underscores into spaces.
+// Letters, digits, and -.~ stay as they are, spaces become
+// underscores, and all other bytes are percent-encoded, including
+// underscores and the commas that separate push options.
+func pushMessage(msg string) string {
+	var buf strings.Builder
+	for i := 0; i < len(msg); i++ {
+		switch c := msg[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == ' ':
+			buf.WriteByte('_')
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
 }
 
 // mailAddressRE matches the mail addresses we admit. It's restrictive but admits
@@ -124,14 +590,14 @@ func mailList(start, tag string, flagList string) string {
 	for i, addr := range strings.Split(flagList, ",") {
 		m := mailAddressRE.FindStringSubmatch(addr)
 		if m == nil {
-			printf("invalid reviewer mail address: %s", addr)
+			colorPrintf(colorError, "invalid reviewer mail address: %s", addr)
 			errors = true
 			continue
 		}
 		if m[2] == "" {
 			email := mailLookup(addr)
 			if email == "" {
-				printf("unknown reviewer: %s", addr)
+				colorPrintf(colorError, "unknown reviewer: %s", addr)
 				errors = true
 				continue
 			}
@@ -6,7 +6,11 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestMail(t *testing.T) {
@@ -30,6 +34,80 @@ func TestMail(t *testing.T) {
 		"git tag -f work.mailed "+h)
 }
 
+func TestMailNoVerify(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	write(t, gt.client+"/.git/hooks/pre-push", "#!/bin/sh\necho pre-push hook says no >&2\nexit 1\n")
+	if err := os.Chmod(gt.client+"/.git/hooks/pre-push", 0755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, gt.client+"/file", "staged")
+	trun(t, gt.client, "git", "add", "file")
+
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "cannot mail: multiple changes pending")
+	testMainDied(t, "mail", "HEAD")
+	testPrintedStderr(t, "there are staged changes; aborting.")
+	testMainDied(t, "mail", "-f", "HEAD")
+	testPrintedStderr(t, "pre-push hook says no")
+
+	h := CurrentBranch().Pending()[0].ShortHash
+	testMain(t, "mail", "-no-verify")
+	testPrintedStderr(t, "-no-verify: mailing all 2 pending commits", "!-no-verify: skipping the checks")
+	testMain(t, "mail", "-v", "-no-verify")
+	testPrintedStderr(t, "-no-verify: mailing all 2 pending commits", "-no-verify: skipping the checks")
+	testRan(t,
+		"git push -q --no-verify origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	// A commit without a Change-Id is mailed as it is.
+	trun(t, gt.client, "git", "commit", "-q", "--amend", "--no-verify", "-m", "msg")
+	h = CurrentBranch().Pending()[0].ShortHash
+	trun(t, gt.server, "git", "update-ref", "-d", "refs/for/master")
+	testMain(t, "mail", "-no-verify", "HEAD")
+	testRan(t,
+		"git push -q --no-verify origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+}
+
+func TestMailForkUpstream(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	// In a fork, master tracks upstream/master, and so does a work
+	// branch with no upstream of its own. The change is still for master.
+	trun(t, gt.client, "git", "remote", "add", "upstream", gt.server)
+	trun(t, gt.client, "git", "fetch", "-q", "upstream")
+	trun(t, gt.client, "git", "branch", "--set-upstream-to", "upstream/master", "master")
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "work", "--no-track")
+	doWork(t, 1, gt.client, "workfile", "11111111")
+
+	h := CurrentBranch().Pending()[0].ShortHash
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+}
+
 func TestMailGitHub(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -54,6 +132,74 @@ func TestMailAmbiguousRevision(t *testing.T) {
 	testMain(t, "mail", "-diff")
 }
 
+func TestMailDiffPaths(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	b := CurrentBranch()
+	h := b.Pending()[0].ShortHash
+	bp := b.Branchpoint()[:7]
+
+	testMain(t, "mail", "-diff")
+	testRan(t, "git diff "+bp+".."+h+" --")
+
+	testMain(t, "mail", "-diff", "--", "file", "otherfile")
+	testRan(t, "git diff "+bp+".."+h+" -- file otherfile")
+
+	testMain(t, "mail", "-diff", "HEAD", "--", "file")
+	testRan(t, "git diff "+bp+".."+h+" -- file")
+
+	testMain(t, "mail", "-diff", "-stat")
+	testRan(t, "git diff --stat "+bp+".."+h+" --")
+
+	testMain(t, "mail", "-diff", "-name-only", "--", "file")
+	testRan(t, "git diff --name-only "+bp+".."+h+" -- file")
+
+	testMain(t, "mail", "-diff", "-against", "origin/master")
+	testRan(t, "git diff origin/master..."+h+" --")
+
+	testMain(t, "mail", "-diff", "-word-diff")
+	testRan(t, "git diff --word-diff "+bp+".."+h+" --")
+
+	testMain(t, "mail", "-diff", "-color-words", "--", "file")
+	testRan(t, "git diff --color-words "+bp+".."+h+" -- file")
+
+	// Options after "--" pass through to git diff.
+	testMain(t, "mail", "-diff", "--", "--ignore-all-space", "-U1")
+	testRan(t, "git diff --ignore-all-space -U1 "+bp+".."+h+" --")
+
+	testMain(t, "mail", "-diff", "-stat", "--", "--stat-width=40", "--", "file")
+	testRan(t, "git diff --stat --stat-width=40 "+bp+".."+h+" -- file")
+
+	testMain(t, "mail", "-diff", "--", "-w", "--", "-file")
+	testRan(t, "git diff -w "+bp+".."+h+" -- -file")
+
+	testMainDied(t, "mail", "-diff", "-against", "nosuchref")
+	testPrintedStderr(t, "cannot diff: nosuchref is not a commit")
+}
+
+func TestMailDiffStaged(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// No pending change is needed.
+	testMain(t, "change", "work")
+	write(t, gt.client+"/file", "staged content")
+	trun(t, gt.client, "git", "add", "file")
+	write(t, gt.client+"/file", "unstaged content")
+
+	testMain(t, "mail", "-diff", "-staged")
+	testRan(t, "git diff --cached --")
+	testPrintedStdout(t, "+staged content", "!unstaged content")
+
+	testMain(t, "mail", "-diff", "-worktree", "-stat", "--", "file")
+	testRan(t, "git diff --stat -- file")
+
+	testMain(t, "mail", "-diff", "-worktree")
+	testPrintedStdout(t, "-staged content", "+unstaged content")
+}
+
 func TestMailMultiple(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -137,6 +283,80 @@ func TestMailShort(t *testing.T) {
 
 	testMainDied(t, "mail", "-r", "other", "-r", "anon,r1,missing")
 	testPrintedStderr(t, "unknown reviewer: missing")
+	testMainDied(t, "mail", "-quiet", "-r", "missing")
+	testPrintedStderr(t, "unknown reviewer: missing")
+}
+
+func TestMailMessage(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMain(t, "mail", "-m", "addressed review comments")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%m=addressed_review_comments",
+		"git tag -f work.mailed "+h)
+
+	testMain(t, "mail", "-r", "foo@example.com", "-topic", "test-topic", "-m", "rebased, 100% done_ok")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%r=foo@example.com,topic=test-topic,m=rebased%2C_100%25_done%5Fok",
+		"git tag -f work.mailed "+h)
+}
+
+func TestMailLabel(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMain(t, "mail", "-label", "Code-Review+2", "-label", "Verified+1")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%l=Code-Review+2,l=Verified+1",
+		"git tag -f work.mailed "+h)
+
+	testMain(t, "mail", "-trybot", "-label", "Verified-1,Code-Review+1", "-topic", "bot")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%topic=bot,l=Run-TryBot,l=Verified-1,l=Code-Review+1",
+		"git tag -f work.mailed "+h)
+
+	for _, bad := range []string{"Code-Review", "Code-Review+", "+2", "Code Review+2", "Code-Review=2"} {
+		testMainDied(t, "mail", "-label", bad)
+		testPrintedStderr(t, "invalid -label vote")
+	}
+}
+
+func TestPushMessage(t *testing.T) {
+	for _, tt := range []struct{ msg, want string }{
+		{"PTAL", "PTAL"},
+		{"fix typo", "fix_typo"},
+		{"a,b=c%d_e", "a%2Cb%3Dc%25d%5Fe"},
+		{"v1.2-rc~1", "v1.2-rc~1"},
+		{"line\nnext", "line%0Anext"},
+		{"café", "caf%C3%A9"},
+	} {
+		if got := pushMessage(tt.msg); got != tt.want {
+			t.Errorf("pushMessage(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
 }
 
 func TestMailTopic(t *testing.T) {
@@ -157,10 +377,234 @@ func TestMailTopic(t *testing.T) {
 	testMainDied(t, "mail", "-topic", "contains,comma")
 	testPrintedStderr(t, "topic may not contain a comma")
 
+	testMainDied(t, "mail", "-topic", "has space")
+	testPrintedStderr(t, "topic may not contain spaces")
+
 	testMain(t, "mail", "-topic", "test-topic")
 	testRan(t,
 		"git push -q origin HEAD:refs/for/master%topic=test-topic",
 		"git tag -f work.mailed "+h)
+
+	t.Logf("topic from branch")
+	trun(t, gt.client, "git", "config", "codereview.topicfrombranch", "true")
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%topic=work",
+		"git tag -f work.mailed "+h)
+
+	testMain(t, "mail", "-topic", "test-topic")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%topic=test-topic",
+		"git tag -f work.mailed "+h)
+}
+
+func TestMailWIP(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMain(t, "mail", "-wip")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%wip",
+		"git tag -f work.mailed "+h)
+
+	testMain(t, "mail", "-topic", "test-topic", "-ready")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%topic=test-topic,ready",
+		"git tag -f work.mailed "+h)
+}
+
+func TestMailRemote(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMainDied(t, "mail", "-remote", "review")
+	testPrintedStderr(t, "cannot mail: no remote named review; configured remotes are:\n\torigin")
+
+	trun(t, gt.client, "git", "remote", "add", "review", gt.server)
+	testMain(t, "mail", "-remote", "review")
+	testRan(t,
+		"git push -q review HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	trun(t, gt.client, "git", "config", "codereview.remote", "review")
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q review HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	testMain(t, "mail", "-remote", "origin")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+}
+
+func TestMailChooseRemote(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	// Two remotes that look like Gerrit servers, both pushing to the test server.
+	for name, url := range map[string]string{
+		"external": "https://go.googlesource.com/proj",
+		"internal": "ssh://gerrit.example.com:29418/proj",
+	} {
+		trun(t, gt.client, "git", "remote", "add", name, url)
+		trun(t, gt.client, "git", "config", "--add", "url."+gt.server+".insteadOf", url)
+	}
+
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "cannot mail: there are multiple Gerrit remotes (external, internal); use -remote or set codereview.remote")
+
+	// The -remote flag avoids the question.
+	testMain(t, "mail", "-remote", "origin")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+
+	// Answering the question saves the answer.
+	stdinIsTerminal = func() bool { return true }
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(f *os.File) { os.Stdin = f }(os.Stdin)
+	os.Stdin = r
+	w.Write([]byte("internal\n"))
+	w.Close()
+	testMain(t, "mail")
+	testPrintedStderr(t, "mail to which one (external, internal)? ", "saved codereview.remote=internal.")
+	testRan(t,
+		"git config codereview.remote internal",
+		"git push -q internal HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+	if remote := trim(trun(t, gt.client, "git", "config", "codereview.remote")); remote != "internal" {
+		t.Fatalf("codereview.remote = %q, want internal", remote)
+	}
+}
+
+func TestMailPostMailHook(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	h := CurrentBranch().Pending()[0].Hash
+	const hook = `echo "$CODEREVIEW_BRANCH $CODEREVIEW_COMMIT $CODEREVIEW_CHANGE $CODEREVIEW_CHANGE_URL" >hook.out`
+	trun(t, gt.client, "git", "config", "codereview.postmailhook", hook)
+
+	// Make the push print a change URL, as Gerrit does.
+	write(t, gt.server+"/.git/hooks/pre-receive", "#!/bin/sh\necho '  https://go-review.googlesource.com/c/proj/+/123 msg'\n")
+	os.Chmod(gt.server+"/.git/hooks/pre-receive", 0755)
+
+	testMain(t, "mail")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h[:7],
+		"git notes --ref=refs/notes/codereview add -f -m https://go-review.googlesource.com/c/proj/+/123 "+workNotesKey,
+		"sh -c "+hook)
+	want := "work " + h + " 123 https://go-review.googlesource.com/c/proj/+/123\n"
+	if got := read(t, gt.client+"/hook.out"); string(got) != want {
+		t.Fatalf("hook output = %q, want %q", got, want)
+	}
+	if _, ok := os.LookupEnv("CODEREVIEW_BRANCH"); ok {
+		t.Fatalf("mail left CODEREVIEW_BRANCH set")
+	}
+
+	// A failing hook does not make mail fail.
+	trun(t, gt.client, "git", "config", "codereview.postmailhook", "exit 3")
+	testMain(t, "mail")
+	testPrintedStderr(t, "mailed "+h[:7], "warning: codereview.postmailhook command failed: exit status 3")
+}
+
+func TestMailPushRetry(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	defer func(d time.Duration) { pushRetryDelay = d }(pushRetryDelay)
+	pushRetryDelay = time.Millisecond
+
+	// Push to the fake Gerrit server, which fails as told by status.
+	// The server runs the reply function in its own goroutine.
+	trun(t, gt.client, "git", "config", "remote.origin.pushurl", auth.url+"/proj")
+	var mu sync.Mutex
+	status := http.StatusServiceUnavailable
+	pushes := 0
+	srv.setReply("/proj/info/refs", gerritReply{f: func() gerritReply {
+		mu.Lock()
+		defer mu.Unlock()
+		pushes++
+		return gerritReply{status: status}
+	}})
+	checkPushes := func(want int) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if pushes != want {
+			t.Errorf("pushed %d times, want %d", pushes, want)
+		}
+	}
+
+	trun(t, gt.client, "git", "config", "codereview.uploadretries", "2")
+	testMainDied(t, "mail", "-v")
+	testPrintedStderr(t, "push failed; retrying", "(retry 2 of 2)", "!(retry 3")
+	checkPushes(3)
+
+	t.Logf("non-transient failure")
+	mu.Lock()
+	status, pushes = http.StatusForbidden, 0
+	mu.Unlock()
+	testMainDied(t, "mail", "-v")
+	testPrintedStderr(t, "!retrying")
+	checkPushes(1)
+
+	trun(t, gt.client, "git", "config", "codereview.uploadretries", "many")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, `invalid codereview.uploadretries setting "many"`)
+	trun(t, gt.client, "git", "config", "--unset", "codereview.uploadretries")
+
+	trun(t, gt.client, "git", "config", "codereview.timeout", "-1")
+	testMainDied(t, "mail")
+	testPrintedStderr(t, `invalid codereview.timeout setting "-1"`)
 }
 
 func TestMailEmpty(t *testing.T) {
@@ -189,3 +633,138 @@ func TestMailEmpty(t *testing.T) {
 	testMainDied(t, "mail")
 	testPrintedStderr(t, "cannot mail: commit "+h+" is empty")
 }
+
+// workNotesKey is the name of the blob "Change-Id: I123456789\n",
+// the key for the change URL of the first commit made by gt.work.
+const workNotesKey = "6efbe8fe2aa2fccccf9cd00cc503b68812877678"
+
+func TestMailChangeURL(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMain(t, "mail")
+	testPrintedStderr(t, "mailed "+h+".")
+
+	// Have the server print a change URL, as Gerrit does.
+	write(t, gt.server+"/.git/hooks/post-receive", "#!/bin/sh\n"+
+		"echo 'Processing changes: new: 1, done'\n"+
+		"echo\n"+
+		"echo '  https://go-review.example.com/c/proj/+/12345 msg [NEW]'\n")
+	if err := os.Chmod(gt.server+"/.git/hooks/post-receive", 0755); err != nil {
+		t.Fatal(err)
+	}
+	trun(t, gt.server, "git", "update-ref", "-d", "refs/for/master") // so the push does something
+	testMain(t, "mail")
+	testPrintedStderr(t, "mailed "+h+": https://go-review.example.com/c/proj/+/12345\n")
+	testRan(t, "git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h,
+		"git notes --ref=refs/notes/codereview add -f -m https://go-review.example.com/c/proj/+/12345 "+workNotesKey)
+
+	defer os.Setenv("BROWSER", os.Getenv("BROWSER"))
+	os.Setenv("BROWSER", "echo")
+	trun(t, gt.server, "git", "update-ref", "-d", "refs/for/master")
+	testMain(t, "mail", "-open")
+	testRan(t, "git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h,
+		"echo https://go-review.example.com/c/proj/+/12345")
+}
+
+func TestChangeURL(t *testing.T) {
+	for _, tt := range []struct {
+		out, url string
+	}{
+		{"", ""},
+		{"remote: Processing changes: refs: 1, done\n", ""},
+		{"remote:\nremote: New Changes:\nremote:   https://go-review.googlesource.com/c/review/+/2184 git-codereview: fix\nremote:\n", "https://go-review.googlesource.com/c/review/+/2184"},
+		{"remote: Updated Changes:\nremote:   https://go-review.googlesource.com/2184\n", "https://go-review.googlesource.com/2184"},
+		{"remote:   https://h/c/p/+/1 a\nremote:   https://h/c/p/+/2 b\n", "https://h/c/p/+/2"},
+	} {
+		if url := changeURL(tt.out); url != tt.url {
+			t.Errorf("changeURL(%q) = %q, want %q", tt.out, url, tt.url)
+		}
+	}
+}
+
+func TestMailMissingChangeID(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	write(t, gt.client+"/file", "no change id")
+	trun(t, gt.client, "git", "commit", "-q", "-a", "--amend", "--no-verify", "-m", "msg")
+
+	// A commit other than HEAD cannot be fixed automatically.
+	trun(t, gt.client, "git", "commit", "-q", "--allow-empty", "--no-verify", "-m", "foo: newer\n\nChange-Id: I0123456789")
+	testMainDied(t, "mail", "-y", "HEAD^")
+	testPrintedStderr(t, "cannot mail: commit", "has no Change-Id line", "hooks' to install the commit-msg hook")
+	trun(t, gt.client, "git", "reset", "-q", "--hard", "HEAD^")
+
+	// Without a terminal to ask, the amend needs -y.
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "has no Change-Id line; amend it to add one: not confirmed", "cannot mail: commit")
+
+	// Stand in for the usual commit-msg hook, which runs git-codereview.
+	write(t, gt.client+"/.git/hooks/commit-msg", "#!/bin/sh\nprintf '\\nChange-Id: I1111111111\\n' >>\"$1\"\n")
+	if err := os.Chmod(gt.client+"/.git/hooks/commit-msg", 0755); err != nil {
+		t.Fatal(err)
+	}
+	testMain(t, "mail", "-y")
+	testPrintedStderr(t, "added Change-Id to")
+	if c := CurrentBranch().Pending()[0]; c.ChangeID != "I1111111111" {
+		t.Fatalf("Change-Id = %q after mail, want I1111111111", c.ChangeID)
+	}
+}
+
+func TestMailFor(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	testMainDied(t, "mail", "-for", "bad..name")
+	testPrintedStderr(t, `invalid branch name "bad..name"`)
+
+	trun(t, gt.server, "git", "branch", "release-1.2")
+	trun(t, gt.client, "git", "fetch", "-q")
+	testMain(t, "mail", "-for", "origin/release-1.2", "-r", "r@golang.org", "-topic", "backport")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/release-1.2%r=r@golang.org,topic=backport",
+		"git tag -f work.mailed "+h)
+	testPrintedStderr(t, "!warning")
+
+	testMain(t, "mail", "-for", "release-9")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/release-9",
+		"git tag -f work.mailed "+h)
+	testPrintedStderr(t, "warning: origin has no branch release-9")
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+)
+
+// Mailed changes have their Gerrit URL recorded in git notes,
+// so that open and pending can find it without asking Gerrit.
+// Amending a commit changes its hash but not its Change-Id,
+// so the note is attached not to the commit but to the name of
+// a blob holding the Change-Id line, which is the same for every
+// version of the change. Notes are looked up by object name only,
+// so the blob itself is never written.
+const notesRef = "refs/notes/codereview"
+
+// changeIDObject returns the name of the blob used as the notes key for c,
+// computed as git hash-object would, in the repository's object format.
+func changeIDObject(c *Commit) string {
+	var h hash.Hash
+	if format, _ := trimErr(cmdOutputErr("git", "rev-parse", "--show-object-format")); format == "sha256" {
+		h = sha256.New()
+	} else {
+		h = sha1.New()
+	}
+	data := "Change-Id: " + c.ChangeID + "\n"
+	fmt.Fprintf(h, "blob %d\x00%s", len(data), data)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// saveChangeURL records url as the Gerrit URL for c,
+// unless it is already recorded.
+// Failure is only reported, since the note is just a cache.
+func saveChangeURL(c *Commit, url string) {
+	if c.ChangeID == "" || url == "" || savedChangeURL(c) == url {
+		return
+	}
+	if err := runErr("git", "notes", "--ref="+notesRef, "add", "-f", "-m", url, changeIDObject(c)); err != nil {
+		verbosef("cannot record change URL: %v", err)
+	}
+}
+
+// savedChangeURL returns the Gerrit URL recorded for c by saveChangeURL,
+// or the empty string if there is none.
+func savedChangeURL(c *Commit) string {
+	if c.ChangeID == "" {
+		return ""
+	}
+	url, err := trimErr(cmdOutputErr("git", "notes", "--ref="+notesRef, "show", changeIDObject(c)))
+	if err != nil {
+		return ""
+	}
+	return url
+}
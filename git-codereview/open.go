@@ -0,0 +1,72 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+func cmdOpen(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s open %s [commit]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	b := CurrentBranch()
+	var c *Commit
+	if len(flags.Args()) == 1 {
+		c = b.CommitByRev("open", flags.Arg(0))
+	} else {
+		c = b.DefaultCommit("open", "must specify commit on command line")
+	}
+	openBrowser(changeWebURL(b, c))
+}
+
+// changeWebURL returns the URL of the Gerrit page for the change c in b.
+// It dies if c has not been mailed.
+func changeWebURL(b *Branch, c *Commit) string {
+	if c.ChangeID == "" {
+		dief("cannot open %s: commit has no Change-Id line", c.ShortHash)
+	}
+	if url := savedChangeURL(c); url != "" {
+		return url
+	}
+	g, err := b.GerritChange(c)
+	if err != nil {
+		dief("cannot open %s: change not found on Gerrit; run '%s mail' first\n\t%v", c.ShortHash, os.Args[0], err)
+	}
+	loadAuth()
+	url := fmt.Sprintf("%s/%d", auth.url, g.Number)
+	saveChangeURL(c, url)
+	return url
+}
+
+// openBrowser opens url in a web browser. It uses the first command listed
+// in $BROWSER, if set, and otherwise the usual command for the system.
+func openBrowser(url string) {
+	var cmd []string
+	if list := os.Getenv("BROWSER"); list != "" {
+		cmd = strings.Fields(strings.Split(list, string(os.PathListSeparator))[0])
+	}
+	if len(cmd) == 0 {
+		switch runtime.GOOS {
+		case "darwin":
+			cmd = []string{"open"}
+		case "windows":
+			cmd = []string{"cmd", "/c", "start"}
+		default:
+			cmd = []string{"xdg-open"}
+		}
+	}
+	verbosef("opening %s", url)
+	run(cmd[0], append(cmd[1:], url)...)
+}
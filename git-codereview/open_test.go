@@ -0,0 +1,65 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	defer os.Setenv("BROWSER", os.Getenv("BROWSER"))
+	os.Setenv("BROWSER", "echo:firefox")
+
+	// Not yet on Gerrit.
+	testMainDied(t, "open")
+	testPrintedStderr(t, "change not found on Gerrit", "mail' first")
+
+	srv.setJSON("I123456789", `{"_number": 12345}`)
+	testMain(t, "open")
+	testRan(t, "git notes --ref=refs/notes/codereview add -f -m "+auth.url+"/12345 "+workNotesKey,
+		"echo "+auth.url+"/12345")
+}
+
+func TestOpenSavedURL(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	write(t, gt.server+"/.git/hooks/post-receive", "#!/bin/sh\n"+
+		"echo '  https://go-review.example.com/c/proj/+/12345 msg [NEW]'\n")
+	if err := os.Chmod(gt.server+"/.git/hooks/post-receive", 0755); err != nil {
+		t.Fatal(err)
+	}
+	testMain(t, "mail")
+
+	// Amending keeps the Change-Id, so the recorded URL still applies,
+	// and no Gerrit server is needed to find it.
+	write(t, gt.client+"/file", "more")
+	trun(t, gt.client, "git", "commit", "-a", "--amend", "--no-edit")
+
+	defer os.Setenv("BROWSER", os.Getenv("BROWSER"))
+	os.Setenv("BROWSER", "echo")
+	testMain(t, "open")
+	testRan(t, "echo https://go-review.example.com/c/proj/+/12345")
+
+	testMain(t, "pending", "-l")
+	testPrintedStdout(t, "https://go-review.example.com/c/proj/+/12345")
+}
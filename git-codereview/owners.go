@@ -0,0 +1,81 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// maxOwners is the maximum number of reviewers added by mail -owners,
+// to avoid mailing everyone listed in a widely shared OWNERS file.
+const maxOwners = 5
+
+// ownersReviewers returns the email addresses of the owners of the
+// files changed by c, as listed in the OWNERS files of the directories
+// containing those files and their parents, nearest owners first.
+// It omits the current user and returns at most maxOwners addresses.
+func ownersReviewers(c *Commit) []string {
+	me, _ := trimErr(cmdOutputErr("git", "config", "user.email"))
+	seen := map[string]bool{me: true}
+	dirs := map[string]*ownersFile{}
+	var owners []string
+	for _, file := range ListFiles(c) {
+		// Walk up from the file's directory to the repository root,
+		// stopping early at an OWNERS file that says "set noparent".
+		for dir := path.Dir(file); ; dir = path.Dir(dir) {
+			f := dirs[dir]
+			if f == nil {
+				// Read OWNERS from c itself; a missing file reads as empty.
+				text, err := cmdOutputErr("git", "show", c.Hash+":"+path.Join(dir, "OWNERS"))
+				if err != nil {
+					text = ""
+				}
+				f = parseOwners(text)
+				dirs[dir] = f
+			}
+			for _, addr := range f.owners {
+				if !seen[addr] {
+					seen[addr] = true
+					owners = append(owners, addr)
+				}
+			}
+			if f.noparent || dir == "." {
+				break
+			}
+		}
+	}
+	if len(owners) > maxOwners {
+		verbosef("using only the first %d of %d owners", maxOwners, len(owners))
+		owners = owners[:maxOwners]
+	}
+	return owners
+}
+
+// An ownersFile is the parsed content of an OWNERS file.
+type ownersFile struct {
+	owners   []string // email addresses of owners
+	noparent bool     // "set noparent": ignore OWNERS in parent directories
+}
+
+// parseOwners parses the content of an OWNERS file.
+// Comments, wildcards, and per-file and include directives are ignored.
+func parseOwners(text string) *ownersFile {
+	f := new(ownersFile)
+	for _, line := range lines(text) {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "set noparent" {
+			f.noparent = true
+			continue
+		}
+		if m := mailAddressRE.FindStringSubmatch(line); m != nil && m[2] != "" {
+			f.owners = append(f.owners, line)
+		}
+	}
+	return f
+}
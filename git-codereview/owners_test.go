@@ -0,0 +1,74 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOwners(t *testing.T) {
+	f := parseOwners(`# comment
+a@golang.org
+b@golang.org # trailing comment
+*
+per-file *.go=c@golang.org
+file:other/OWNERS
+notanaddress
+set noparent
+`)
+	if want := []string{"a@golang.org", "b@golang.org"}; !reflect.DeepEqual(f.owners, want) {
+		t.Errorf("owners = %v, want %v", f.owners, want)
+	}
+	if !f.noparent {
+		t.Errorf("noparent = false, want true")
+	}
+}
+
+func TestMailOwners(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// fake auth information to avoid Gerrit error
+	auth.host = "gerrit.fake"
+	auth.user = "not-a-user"
+	defer func() {
+		auth.host = ""
+		auth.user = ""
+	}()
+
+	write(t, gt.server+"/OWNERS", "root@golang.org\ngopher@example.com\n")
+	mkdir(t, gt.server+"/sub")
+	write(t, gt.server+"/sub/OWNERS", "sub@golang.org\n")
+	mkdir(t, gt.server+"/top")
+	write(t, gt.server+"/top/OWNERS", "set noparent\ntop@golang.org\n")
+	trun(t, gt.server, "git", "add", "OWNERS", "sub/OWNERS", "top/OWNERS")
+	trun(t, gt.server, "git", "commit", "-m", "add owners")
+	trun(t, gt.client, "git", "pull", "-r")
+
+	testMain(t, "change", "work")
+	write(t, gt.client+"/sub/file", "sub file")
+	write(t, gt.client+"/top/file", "top file")
+	trun(t, gt.client, "git", "add", "sub/file", "top/file")
+	trun(t, gt.client, "git", "commit", "-m", "msg\n\nChange-Id: I123456789\n")
+	h := CurrentBranch().Pending()[0].ShortHash
+
+	testMain(t, "mail", "-owners", "-r", "r@golang.org")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master%r=r@golang.org,r=sub@golang.org,r=root@golang.org,r=top@golang.org",
+		"git tag -f work.mailed "+h)
+
+	t.Logf("no owners")
+	remove(t, gt.client+"/sub")
+	remove(t, gt.client+"/top")
+	trun(t, gt.client, "git", "rm", "-q", "-r", "--cached", "OWNERS", "sub", "top")
+	trun(t, gt.client, "git", "commit", "--amend", "--no-edit")
+	h = CurrentBranch().Pending()[0].ShortHash
+	testMain(t, "mail", "-owners")
+	testPrintedStderr(t, "no owners found in OWNERS files")
+	testRan(t,
+		"git push -q origin HEAD:refs/for/master",
+		"git tag -f work.mailed "+h)
+}
@@ -6,6 +6,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,9 +17,12 @@ import (
 )
 
 var (
-	pendingLocal       bool // -l flag, use only local operations (no network)
-	pendingCurrentOnly bool // -c flag, show only current branch
-	pendingShort       bool // -s flag, short display
+	pendingLocal       bool   // -l flag, use only local operations (no network)
+	pendingCurrentOnly bool   // -c flag, show only current branch
+	pendingJSON        bool   // -json flag, print JSON
+	pendingShort       bool   // -s flag, short display
+	pendingLimit       int    // -limit flag, maximum number of branches to show
+	pendingSort        string // -sort flag, branch order: name or date
 )
 
 // A pendingBranch collects information about a single pending branch.
@@ -55,12 +59,27 @@ func (b *pendingBranch) load() {
 
 func cmdPending(args []string) {
 	flags.BoolVar(&pendingCurrentOnly, "c", false, "show only current branch")
+	flags.BoolVar(&pendingJSON, "json", false, "print JSON for use by other programs")
 	flags.BoolVar(&pendingLocal, "l", false, "use only local information - no network operations")
 	flags.BoolVar(&pendingShort, "s", false, "show short listing")
+	flags.IntVar(&pendingLimit, "limit", 0, "show at most `n` branches (0 means no limit)")
+	flags.StringVar(&pendingSort, "sort", "date", "sort branches by `order`: name or date")
 	flags.Parse(args)
 	if len(flags.Args()) > 0 {
-		fmt.Fprintf(stderr(), "Usage: %s pending %s [-c] [-l] [-s]\n", os.Args[0], globalFlags)
-		os.Exit(2)
+		fmt.Fprintf(stderr(), "Usage: %s pending %s [-c] [-json] [-l] [-limit n] [-s] [-sort name|date]\n", os.Args[0], globalFlags)
+		os.Exit(exitUsage)
+	}
+	var sortKey string
+	switch pendingSort {
+	case "date":
+		sortKey = "-committerdate"
+	case "name":
+		sortKey = "refname"
+	default:
+		dief("invalid -sort %q: must be name or date", pendingSort)
+	}
+	if pendingLimit < 0 {
+		dief("invalid -limit %d: must not be negative", pendingLimit)
 	}
 
 	// Fetch info about remote changes, so that we can say which branches need sync.
@@ -70,14 +89,15 @@ func cmdPending(args []string) {
 	}
 
 	// Build list of pendingBranch structs to be filled in.
-	// The current branch is always first.
+	// The current branch is always first, followed by the other
+	// branches, most recently committed first or sorted by name.
 	var branches []*pendingBranch
 	branches = []*pendingBranch{{Branch: CurrentBranch(), current: true}}
 	if !pendingCurrentOnly {
 		current := CurrentBranch().Name
-		for _, b := range LocalBranches() {
-			if b.Name != current {
-				branches = append(branches, &pendingBranch{Branch: b})
+		for _, ref := range nonBlankLines(cmdOutput("git", "for-each-ref", "--sort="+sortKey, "--format=%(refname)", "refs/heads/")) {
+			if name := strings.TrimPrefix(ref, "refs/heads/"); name != current {
+				branches = append(branches, &pendingBranch{Branch: &Branch{Name: name}})
 			}
 		}
 	}
@@ -86,40 +106,38 @@ func cmdPending(args []string) {
 	// especially run in serial with a lot of branches.
 	// Overlap inspection of multiple branches.
 	// Each branch is only accessed by a single worker.
+	// The configuration is loaded on first use, for example in
+	// OriginBranch for a branch without an upstream, so load it
+	// before starting the workers.
+	config()
+	loadGitConfig()
+	inParallel(len(branches), func(i int) { branches[i].load() })
 
-	// Build work queue.
-	work := make(chan *pendingBranch, len(branches))
-	done := make(chan bool, len(branches))
+	// Keep only the branches to show: those with work on them,
+	// and the current branch, up to the -limit.
+	shown := branches[:0]
 	for _, b := range branches {
-		work <- b
-	}
-	close(work)
-
-	// Kick off goroutines to do work.
-	n := len(branches)
-	if n > 10 {
-		n = 10
+		if b.current || b.commitsAhead > 0 {
+			shown = append(shown, b)
+		}
 	}
-	for i := 0; i < n; i++ {
-		go func() {
-			for b := range work {
-				b.load()
-				done <- true
-			}
-		}()
+	branches = shown
+	hidden := 0
+	if pendingLimit > 0 && len(branches) > pendingLimit {
+		hidden = len(branches) - pendingLimit
+		branches = branches[:pendingLimit]
 	}
 
-	// Wait for goroutines to finish.
-	// Note: Counting work items, not goroutines (there may be fewer goroutines).
-	for range branches {
-		<-done
+	if pendingJSON {
+		printPendingJSON(branches)
+		return
 	}
 
 	// Print output.
 	// If there are multiple changes in the current branch, the output splits them out into separate sections,
 	// in reverse commit order, to match git log output.
 	//
-	//	wbshadow 7a524a1..a496c1e (current branch, all mailed, 23 behind, tracking master)
+	//	wbshadow 7a524a1..a496c1e (current branch, dirty, all mailed, 2 ahead, 23 behind, tracking master)
 	//	+ uncommitted changes
 	//		Files unstaged:
 	//			src/runtime/proc1.go
@@ -162,15 +180,15 @@ func cmdPending(args []string) {
 	//
 	// The first line only gives information that applies to the entire branch:
 	// the name, the commit range, whether this is the current branch, whether
-	// all the commits are mailed/submitted, how far behind, what remote branch
-	// it is tracking.
+	// it has uncommitted changes, whether all the commits are mailed/submitted,
+	// how far ahead and behind, what remote branch it is tracking.
 	// The individual change sections have per-change information: the hash of that
 	// commit, the URL on the Gerrit server, whether it is mailed/submitted, the list of
 	// files in that commit. The uncommitted file modifications are shown as a separate
 	// section, at the beginning, to fit better into the reverse commit order.
 	//
 	// The short view compresses the listing down to two lines per commit:
-	//	wbshadow 7a524a1..a496c1e (current branch, all mailed, 23 behind, tracking master)
+	//	wbshadow 7a524a1..a496c1e (current branch, dirty, all mailed, 2 ahead, 23 behind, tracking master)
 	//	+ uncommitted changes
 	//		Files unstaged:
 	//			src/runtime/proc1.go
@@ -203,17 +221,26 @@ func cmdPending(args []string) {
 		if b.current {
 			tags = append(tags, "current branch")
 		}
+		if len(b.staged)+len(b.unstaged)+len(b.untracked) > 0 {
+			tags = append(tags, "dirty")
+		}
 		if allMailed(work) && len(work) > 0 {
 			tags = append(tags, "all mailed")
 		}
 		if allSubmitted(work) && len(work) > 0 {
 			tags = append(tags, "all submitted")
 		}
+		if b.commitsAhead > 0 {
+			tags = append(tags, fmt.Sprintf("%d ahead", b.commitsAhead))
+		}
 		if b.commitsBehind > 0 {
 			tags = append(tags, fmt.Sprintf("%d behind", b.commitsBehind))
 		}
-		if b.OriginBranch() != "origin/master" {
-			tags = append(tags, "tracking "+strings.TrimPrefix(b.OriginBranch(), "origin/"))
+		if parent := b.Parent(); parent != "" {
+			tags = append(tags, "stacked on "+parent)
+		}
+		if _, upstream := splitOriginBranch(b.OriginBranch()); upstream != upstreamBranch() {
+			tags = append(tags, "tracking "+upstream)
 		}
 		if len(tags) > 0 {
 			fmt.Fprintf(&buf, " (%s)", strings.Join(tags, ", "))
@@ -252,10 +279,85 @@ func cmdPending(args []string) {
 			fmt.Fprintf(&buf, "\n")
 		}
 	}
+	if hidden > 0 {
+		fmt.Fprintf(&buf, "... and %d more\n", hidden)
+	}
 
 	stdout().Write(buf.Bytes())
 }
 
+// A jsonBranch is the JSON form of a pendingBranch printed by pending -json.
+type jsonBranch struct {
+	Name        string
+	Current     bool
+	Upstream    string
+	Parent      string `json:",omitempty"` // work branch this one is stacked on
+	Branchpoint string
+	Ahead       int
+	Behind      int
+	Dirty       bool // uncommitted changes, only if Current
+	Staged      []string
+	Unstaged    []string
+	Untracked   []string
+	Error       string        `json:",omitempty"`
+	Changes     []*jsonChange // newest first
+}
+
+// A jsonChange is the JSON form of a pending Commit printed by pending -json.
+type jsonChange struct {
+	Hash      string
+	Subject   string
+	ChangeID  string
+	Number    int `json:",omitempty"` // Gerrit CL number
+	Mailed    bool
+	Submitted bool
+	Files     []string
+}
+
+// printPendingJSON prints the branches to standard output as a JSON array,
+// omitting branches that pending would not show.
+func printPendingJSON(branches []*pendingBranch) {
+	list := []*jsonBranch{}
+	for _, b := range branches {
+		if !b.current && b.commitsAhead == 0 {
+			continue
+		}
+		_, upstream := splitOriginBranch(b.OriginBranch())
+		jb := &jsonBranch{
+			Name:        b.Name,
+			Current:     b.current,
+			Upstream:    upstream,
+			Parent:      b.Parent(),
+			Branchpoint: b.branchpoint,
+			Ahead:       b.commitsAhead,
+			Behind:      b.commitsBehind,
+			Dirty:       len(b.staged)+len(b.unstaged)+len(b.untracked) > 0,
+			Staged:      b.staged,
+			Unstaged:    b.unstaged,
+			Untracked:   b.untracked,
+			Error:       strings.TrimSpace(b.errors()),
+			Changes:     []*jsonChange{},
+		}
+		for _, c := range b.Pending() {
+			jb.Changes = append(jb.Changes, &jsonChange{
+				Hash:      c.Hash,
+				Subject:   c.Subject,
+				ChangeID:  c.ChangeID,
+				Number:    c.g.Number,
+				Mailed:    c.g.CurrentRevision == c.Hash,
+				Submitted: c.g.Status == "MERGED",
+				Files:     c.committed,
+			})
+		}
+		list = append(list, jb)
+	}
+	js, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		dief("%v", err)
+	}
+	stdout().Write(append(js, '\n'))
+}
+
 // formatCommit writes detailed information about c to w. c.g must
 // have the "CURRENT_REVISION" (or "ALL_REVISIONS") and
 // "DETAILED_LABELS" options set.
@@ -280,6 +382,8 @@ func formatCommit(w io.Writer, c *Commit, short bool) {
 	} else {
 		if g.Number != 0 {
 			fmt.Fprintf(w, " %s/%d", auth.url, g.Number)
+		} else if url := savedChangeURL(c); url != "" {
+			fmt.Fprintf(w, " %s", url)
 		}
 	}
 	if g.CurrentRevision == c.Hash {
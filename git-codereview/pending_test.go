@@ -5,6 +5,8 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -41,7 +43,7 @@ func TestPendingBasic(t *testing.T) {
 	gt.work(t)
 
 	testPending(t, `
-		work REVHASH..REVHASH (current branch)
+		work REVHASH..REVHASH (current branch, 1 ahead)
 		+ REVHASH
 			msg
 			
@@ -53,6 +55,37 @@ func TestPendingBasic(t *testing.T) {
 	`)
 }
 
+func TestPendingJSON(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	write(t, gt.client+"/file", "dirty")
+
+	testMain(t, "pending", "-l", "-json")
+	var list []*jsonBranch
+	if err := json.Unmarshal(testStdout.Bytes(), &list); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, testStdout.Bytes())
+	}
+	if len(list) != 1 {
+		t.Fatalf("got %d branches, want 1", len(list))
+	}
+	b := list[0]
+	if b.Name != "work" || !b.Current || b.Upstream != "master" || b.Ahead != 1 || b.Behind != 0 || !b.Dirty {
+		t.Errorf("bad branch: %+v", b)
+	}
+	if len(b.Unstaged) != 1 || b.Unstaged[0] != "file" {
+		t.Errorf("Unstaged = %v, want [file]", b.Unstaged)
+	}
+	if len(b.Changes) != 1 {
+		t.Fatalf("got %d changes, want 1", len(b.Changes))
+	}
+	c := b.Changes[0]
+	if c.Hash != CurrentBranch().Pending()[0].Hash || c.Subject != "msg" || c.ChangeID != "I123456789" || c.Mailed || c.Submitted {
+		t.Errorf("bad change: %+v", c)
+	}
+	testNoStderr(t)
+}
+
 func TestPendingComplex(t *testing.T) {
 	gt := newGitTest(t)
 	defer gt.done()
@@ -84,7 +117,7 @@ func TestPendingComplex(t *testing.T) {
 	write(t, gt.client+"/bfile", "untracked")
 
 	testPending(t, `
-		work2 REVHASH..REVHASH (current branch)
+		work2 REVHASH..REVHASH (current branch, dirty, 1 ahead)
 		+ uncommitted changes
 			Files untracked:
 				bfile
@@ -102,7 +135,7 @@ func TestPendingComplex(t *testing.T) {
 				file
 				file1
 
-		work REVHASH..REVHASH (3 behind)
+		work REVHASH..REVHASH (1 ahead, 3 behind)
 		+ REVHASH
 			msg
 			
@@ -114,7 +147,7 @@ func TestPendingComplex(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-c"}, `
-		work2 REVHASH..REVHASH (current branch)
+		work2 REVHASH..REVHASH (current branch, dirty, 1 ahead)
 		+ uncommitted changes
 			Files untracked:
 				bfile
@@ -135,7 +168,7 @@ func TestPendingComplex(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-c", "-s"}, `
-		work2 REVHASH..REVHASH (current branch)
+		work2 REVHASH..REVHASH (current branch, dirty, 1 ahead)
 		+ uncommitted changes
 			Files untracked:
 				bfile
@@ -150,6 +183,57 @@ func TestPendingComplex(t *testing.T) {
 	`)
 }
 
+func TestPendingOrder(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Commit to branches whose names sort in the opposite order
+	// to their committer dates, which are a day apart.
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	for i, name := range []string{"bravo", "alpha"} {
+		trun(t, gt.client, "git", "checkout", "-q", "-b", name, "-t", "origin/master")
+		write(t, gt.client+"/file", name)
+		os.Setenv("GIT_COMMITTER_DATE", fmt.Sprintf("%d +0000", 1500000000-i*86400))
+		trun(t, gt.client, "git", "commit", "-q", "-a", "-m", name)
+	}
+	os.Unsetenv("GIT_COMMITTER_DATE")
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+
+	testPendingArgs(t, []string{"-s"}, `
+		master (current branch)
+
+		bravo REVHASH..REVHASH (1 ahead)
+		+ REVHASH bravo
+
+		alpha REVHASH..REVHASH (1 ahead)
+		+ REVHASH alpha
+
+	`)
+
+	testPendingArgs(t, []string{"-s", "-limit", "2"}, `
+		master (current branch)
+
+		bravo REVHASH..REVHASH (1 ahead)
+		+ REVHASH bravo
+
+		... and 1 more
+	`)
+
+	testPendingArgs(t, []string{"-s", "-sort", "name"}, `
+		master (current branch)
+
+		alpha REVHASH..REVHASH (1 ahead)
+		+ REVHASH alpha
+
+		bravo REVHASH..REVHASH (1 ahead)
+		+ REVHASH bravo
+
+	`)
+
+	testMainDied(t, "pending", "-sort", "size")
+	testPrintedStderr(t, `invalid -sort "size": must be name or date`)
+}
+
 func TestPendingErrors(t *testing.T) {
 	gt := newGitTest(t)
 	gt.enableGerrit(t)
@@ -160,7 +244,7 @@ func TestPendingErrors(t *testing.T) {
 	trun(t, gt.client, "git", "commit", "-a", "-m", "v3")
 
 	testPending(t, `
-		master REVHASH..REVHASH (current branch)
+		master REVHASH..REVHASH (current branch, 1 ahead)
 			ERROR: Branch contains 1 commit not on origin/master.
 				Do not commit directly to master branch.
 		
@@ -173,7 +257,7 @@ func TestPendingErrors(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-s"}, `
-		master REVHASH..REVHASH (current branch)
+		master REVHASH..REVHASH (current branch, 1 ahead)
 			ERROR: Branch contains 1 commit not on origin/master.
 				Do not commit directly to master branch.
 		+ REVHASH v3
@@ -196,7 +280,7 @@ func TestPendingMultiChange(t *testing.T) {
 	write(t, gt.client+"/file2", "v6")
 
 	testPending(t, `
-		work REVHASH..REVHASH (current branch)
+		work REVHASH..REVHASH (current branch, dirty, 2 ahead)
 		+ uncommitted changes
 			Files untracked:
 				file2
@@ -222,7 +306,7 @@ func TestPendingMultiChange(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-s"}, `
-		work REVHASH..REVHASH (current branch)
+		work REVHASH..REVHASH (current branch, dirty, 2 ahead)
 		+ uncommitted changes
 			Files untracked:
 				file2
@@ -246,7 +330,7 @@ func TestPendingGerrit(t *testing.T) {
 
 	// Test error from Gerrit server.
 	testPending(t, `
-		work REVHASH..REVHASH (current branch)
+		work REVHASH..REVHASH (current branch, 1 ahead)
 		+ REVHASH
 			msg
 			
@@ -266,7 +350,7 @@ func TestPendingGerrit(t *testing.T) {
 	trun(t, gt.server, "git", "add", "file")
 	trun(t, gt.server, "git", "commit", "-m", "msg")
 	testPendingArgs(t, []string{"-l"}, `
-		work REVHASH..REVHASH (current branch)
+		work REVHASH..REVHASH (current branch, 1 ahead)
 		+ REVHASH
 			msg
 			
@@ -278,14 +362,14 @@ func TestPendingGerrit(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-l", "-s"}, `
-		work REVHASH..REVHASH (current branch)
+		work REVHASH..REVHASH (current branch, 1 ahead)
 		+ REVHASH msg
 
 	`)
 
 	// Without -l, the 1 behind should appear, as should Gerrit information.
 	testPending(t, `
-		work REVHASH..REVHASH (current branch, all mailed, all submitted, 1 behind)
+		work REVHASH..REVHASH (current branch, all mailed, all submitted, 1 ahead, 1 behind)
 		+ REVHASH http://127.0.0.1:PORT/1234 (mailed, submitted)
 			msg
 			
@@ -302,14 +386,14 @@ func TestPendingGerrit(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-s"}, `
-		work REVHASH..REVHASH (current branch, all mailed, all submitted, 1 behind)
+		work REVHASH..REVHASH (current branch, all mailed, all submitted, 1 ahead, 1 behind)
 		+ REVHASH msg (CL 1234 -2 +1, mailed, submitted)
 
 	`)
 
 	// Since pending did a fetch, 1 behind should show up even with -l.
 	testPendingArgs(t, []string{"-l"}, `
-		work REVHASH..REVHASH (current branch, 1 behind)
+		work REVHASH..REVHASH (current branch, 1 ahead, 1 behind)
 		+ REVHASH
 			msg
 			
@@ -320,7 +404,7 @@ func TestPendingGerrit(t *testing.T) {
 
 	`)
 	testPendingArgs(t, []string{"-l", "-s"}, `
-		work REVHASH..REVHASH (current branch, 1 behind)
+		work REVHASH..REVHASH (current branch, 1 ahead, 1 behind)
 		+ REVHASH msg
 
 	`)
@@ -350,7 +434,7 @@ func TestPendingGerritMultiChange(t *testing.T) {
 	testPendingReply(srv, "I2345", hash2, "NEW")
 
 	testPending(t, `
-		work REVHASH..REVHASH (current branch, all mailed)
+		work REVHASH..REVHASH (current branch, dirty, all mailed, 2 ahead)
 		+ uncommitted changes
 			Files untracked:
 				file2
@@ -388,7 +472,7 @@ func TestPendingGerritMultiChange(t *testing.T) {
 	`)
 
 	testPendingArgs(t, []string{"-s"}, `
-		work REVHASH..REVHASH (current branch, all mailed)
+		work REVHASH..REVHASH (current branch, dirty, all mailed, 2 ahead)
 		+ uncommitted changes
 			Files untracked:
 				file2
@@ -0,0 +1,95 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var pruneDryRun bool // -dry-run flag, only list the branches
+
+func cmdPrune(args []string) {
+	flags.BoolVar(&pruneDryRun, "dry-run", false, "list the branches that would be deleted, without deleting them")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s prune %s [-dry-run]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	current := CurrentBranch()
+	branches := LocalBranches()
+	submitted := make([]bool, len(branches))
+	// Load the configuration before the workers can race to do it.
+	config()
+	loadGitConfig()
+	inParallel(len(branches), func(i int) {
+		// Never delete the current branch or a branch tracking
+		// an origin branch directly, such as master.
+		b := branches[i]
+		if b.Name == current.Name || b.DetachedHead() || !b.IsLocalOnly() {
+			return
+		}
+		submitted[i] = b.allSubmitted()
+	})
+	var prune []*Branch
+	for i, b := range branches {
+		if submitted[i] {
+			prune = append(prune, b)
+		}
+	}
+	if len(prune) == 0 {
+		printf("no submitted branches to prune.")
+		return
+	}
+
+	for _, b := range prune {
+		fmt.Fprintf(stdout(), "%s\t%d submitted change%s\n", b.Name, len(b.Pending()), suffix(len(b.Pending()), "s"))
+	}
+	if pruneDryRun {
+		return
+	}
+	if !confirm(fmt.Sprintf("delete %d submitted branch%s", len(prune), suffix(len(prune), "es"))) {
+		dief("prune canceled")
+	}
+	for _, b := range prune {
+		// The branch's commits were cherry-picked or rebased on submit,
+		// so they are not merged as far as 'git branch -d' can tell.
+		run("git", "branch", "-q", "-D", b.Name)
+		tag := b.Name + ".mailed"
+		if _, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", "refs/tags/"+tag); err == nil {
+			run("git", "tag", "-d", tag)
+		}
+		printf("deleted branch %s.", b.Name)
+	}
+}
+
+// allSubmitted reports whether b has pending commits and all of them
+// have been submitted: either an equivalent patch is already on the
+// origin branch, as reported by 'git cherry', or a commit with the same
+// Change-Id is, as when Gerrit edits a change while submitting it.
+// A branch with no pending commits may just be new, so it is kept.
+func (b *Branch) allSubmitted() bool {
+	work := b.Pending()
+	if len(work) == 0 {
+		return false
+	}
+	upstream := map[string]bool{}
+	for _, line := range nonBlankLines(cmdOutput("git", "cherry", b.OriginBranch(), b.FullName())) {
+		if strings.HasPrefix(line, "- ") {
+			upstream[line[2:]] = true
+		}
+	}
+	for _, c := range work {
+		if !upstream[c.Hash] && !b.Submitted(c.ChangeID) {
+			return false
+		}
+	}
+	return true
+}
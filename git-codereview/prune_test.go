@@ -0,0 +1,84 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "prune")
+	testPrintedStderr(t, "no submitted branches to prune")
+
+	// work is submitted as is (but with a new hash).
+	gt.work(t)
+	gt.serverWorkUnrelated(t)
+	gt.serverWork(t)
+	trun(t, gt.client, "git", "tag", "-f", "work.mailed", "HEAD")
+
+	// edited is submitted after an edit on Gerrit.
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "edited", "-t", "origin/master")
+	write(t, gt.client+"/edited", "before review")
+	trun(t, gt.client, "git", "add", "edited")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "foo: edited\n\nChange-Id: I999")
+	write(t, gt.server+"/edited", "after review")
+	trun(t, gt.server, "git", "add", "edited")
+	trun(t, gt.server, "git", "commit", "-q", "-m", "foo: edited\n\nChange-Id: I999")
+
+	// pending is still pending, and empty has no changes at all.
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "pending", "-t", "origin/master")
+	write(t, gt.client+"/pending", "pending")
+	trun(t, gt.client, "git", "add", "pending")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "foo: pending\n\nChange-Id: I888")
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "empty", "-t", "origin/master")
+
+	trun(t, gt.client, "git", "fetch", "-q")
+
+	// The current branch is never pruned.
+	trun(t, gt.client, "git", "checkout", "-q", "edited")
+	testMain(t, "prune", "-dry-run")
+	testPrintedStdout(t, "work\t1 submitted change\n", "!edited", "!pending", "!empty")
+	testRan(t)
+
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+	testMain(t, "prune", "-dry-run")
+	testPrintedStdout(t, "edited\t1 submitted change\n", "work\t1 submitted change\n", "!pending", "!empty")
+	testRan(t)
+
+	testMain(t, "prune", "-y")
+	testRan(t,
+		"git branch -q -D edited",
+		"git branch -q -D work",
+		"git tag -d work.mailed")
+	testPrintedStderr(t, "deleted branch edited.", "deleted branch work.")
+	for _, b := range LocalBranches() {
+		if b.Name == "work" || b.Name == "edited" {
+			t.Errorf("branch %s not deleted", b.Name)
+		}
+	}
+}
+
+func TestPruneNoUpstream(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Branches with no upstream of their own look up the upstream branch
+	// in the configuration, from all of prune's workers at once.
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		trun(t, gt.client, "git", "checkout", "-q", "-b", name, "--no-track", "origin/master")
+		write(t, gt.client+"/"+name, name)
+		trun(t, gt.client, "git", "add", name)
+		trun(t, gt.client, "git", "commit", "-q", "-m", "foo: "+name+"\n\nChange-Id: I"+name)
+	}
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+
+	testMain(t, "prune", "-dry-run")
+	testPrintedStderr(t, "no submitted branches to prune")
+	testMain(t, "pending", "-l")
+	testPrintedStdout(t, "a\n", "f\n")
+}
@@ -0,0 +1,63 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func cmdRename(args []string) {
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s rename %s newname\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	name := flags.Arg(0)
+
+	b := CurrentBranch()
+	b.checkAttached("rename")
+	if !b.IsLocalOnly() {
+		exitf(exitWrongBranch, "cannot rename %s branch (only work branches can be renamed)", b.Name)
+	}
+	checkBranchName(name, true)
+	if _, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+		dief("cannot rename: branch %s already exists", name)
+	}
+
+	run("git", "branch", "-m", b.Name, name)
+
+	// Keep the branches stacked on this one stacked on it.
+	// Git renames this branch's own settings along with it.
+	restack(b.Name, name)
+
+	// Carry along the tag recording the last mailed commit (see cmdMail).
+	oldTag, newTag := b.Name+".mailed", name+".mailed"
+	if hash, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", "refs/tags/"+oldTag); err == nil {
+		run("git", "tag", "-f", newTag, trim(hash))
+		run("git", "tag", "-d", oldTag)
+		printf("renamed branch %s to %s.\n"+
+			"\tThe changes on the branch were already mailed; the Gerrit changes are\n"+
+			"\tunaffected, but you may want to update any topics that use the old name.", b.Name, name)
+		return
+	}
+	printf("renamed branch %s to %s.", b.Name, name)
+}
+
+// restack moves the branches stacked on the work branch old
+// (see 'change -on') to the work branch new.
+func restack(old, new string) {
+	out, _ := cmdOutputErr("git", "config", "--get-regexp", `^branch\..*\.codereviewparent$`)
+	for _, line := range nonBlankLines(out) {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[1] == old {
+			run("git", "config", f[0], new)
+		}
+	}
+}
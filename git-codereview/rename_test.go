@@ -0,0 +1,45 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestRename(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "rename", "work")
+	testPrintedStderr(t, "cannot rename master branch")
+
+	gt.work(t)
+
+	testMainDied(t, "rename", "master")
+	testPrintedStderr(t, "cannot rename: branch master already exists")
+
+	testMainDied(t, "rename", "my.branch")
+	testPrintedStderr(t, "branch names with dots are reserved")
+
+	testMain(t, "rename", "work2")
+	testRan(t, "git branch -m work work2")
+	testPrintedStderr(t, "renamed branch work to work2.", "!already mailed")
+	checkCurrentBranch(t, "work2", "origin/master", true, true, "I123456789", "msg")
+
+	t.Logf("-quiet")
+	testMain(t, "rename", "-quiet", "work4")
+	testNoStderr(t)
+	testMain(t, "rename", "work2")
+	testMainDied(t, "rename", "-quiet", "master")
+	testPrintedStderr(t, "cannot rename: branch master already exists")
+
+	t.Logf("mailed branch")
+	h := CurrentBranch().Pending()[0].ShortHash
+	trun(t, gt.client, "git", "tag", "work2.mailed", h)
+	testMain(t, "rename", "work3")
+	testRan(t,
+		"git branch -m work2 work3",
+		"git tag -f work3.mailed "+CurrentBranch().Pending()[0].Hash,
+		"git tag -d work2.mailed")
+	testPrintedStderr(t, "renamed branch work2 to work3.", "already mailed")
+}
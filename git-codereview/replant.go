@@ -0,0 +1,102 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func cmdReplant(args []string) {
+	del := flags.Bool("d", false, "delete the old branch after replanting onto a new one")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s replant %s [-d] [newname]\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) > 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	b := CurrentBranch()
+	b.checkAttached("replant")
+	if !b.IsLocalOnly() {
+		exitf(exitWrongBranch, "cannot replant %s branch (only work branches can be replanted)", b.Name)
+	}
+	if parent := b.Parent(); parent != "" {
+		dief("cannot replant: branch %s is stacked on %s; use sync instead", b.Name, parent)
+	}
+	name := flags.Arg(0)
+	if name == b.Name {
+		name = ""
+	}
+	if *del && name == "" {
+		dief("cannot replant: -d requires a new branch name")
+	}
+	if name != "" {
+		checkBranchName(name, true)
+		if _, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+			dief("cannot replant: branch %s already exists", name)
+		}
+	}
+	// Dies if there is not exactly one commit.
+	c := b.DefaultCommit("replant", "")
+
+	// Switching branches would carry uncommitted work along.
+	checkStaged("replant")
+	checkUnstaged("replant")
+
+	runRemote("git", "fetch", "-q")
+	origin := b.OriginBranch()
+
+	// The new branch starts at the origin branch, or for replanting
+	// in place, HEAD does, until the cherry-pick succeeds.
+	var undo func()
+	if name != "" {
+		createWorkBranchAt(name, origin)
+		undo = func() {
+			run("git", "checkout", "-q", b.Name)
+			run("git", "branch", "-q", "-D", name)
+		}
+	} else {
+		run("git", "checkout", "-q", "--detach", origin)
+		undo = func() {
+			run("git", "checkout", "-q", b.Name)
+		}
+	}
+
+	if err := runErr("git", "cherry-pick", "--allow-empty", c.Hash); err != nil {
+		var files []string
+		if pathExists(gitPath("CHERRY_PICK_HEAD")) {
+			files = nonBlankLines(cmdOutput("git", "diff", "--name-only", "--diff-filter=U"))
+			run("git", "cherry-pick", "--abort")
+		}
+		// Put everything back the way it was.
+		undo()
+		if len(files) == 0 {
+			dieRun(err, "git", "cherry-pick", c.Hash)
+		}
+		dief("cannot replant %s: conflicts with %s in:\n"+
+			"\t\t%s\n"+
+			"\tthe branch %s is unchanged; run 'git codereview sync' to resolve the conflicts",
+			c.ShortHash, origin, strings.Join(files, "\n\t\t"), b.Name)
+	}
+
+	if name == "" {
+		// Move the branch to the new commit, keeping its settings.
+		run("git", "branch", "-q", "-f", b.Name, "HEAD")
+		run("git", "checkout", "-q", b.Name)
+		printf("replanted %s %s onto %s.", c.ShortHash, c.Subject, origin)
+		return
+	}
+	if *del {
+		restack(b.Name, name)
+		run("git", "branch", "-q", "-D", b.Name)
+		printf("replanted %s %s onto %s as branch %s; deleted branch %s.", c.ShortHash, c.Subject, origin, name, b.Name)
+		return
+	}
+	printf("replanted %s %s onto %s as branch %s.", c.ShortHash, c.Subject, origin, name)
+}
@@ -0,0 +1,66 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestReplant(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "replant")
+	testPrintedStderr(t, "cannot replant master branch")
+
+	gt.work(t)
+	old := CurrentBranch().Pending()[0]
+
+	// The server moves ahead.
+	write(t, gt.server+"/otherfile", "other content")
+	trun(t, gt.server, "git", "add", "otherfile")
+	trun(t, gt.server, "git", "commit", "-q", "-m", "other")
+
+	testMainDied(t, "replant", "-d")
+	testPrintedStderr(t, "-d requires a new branch name")
+
+	// Replant in place.
+	testMain(t, "replant")
+	testPrintedStderr(t, "replanted "+old.ShortHash+" "+old.Subject+" onto origin/master.")
+	b := CurrentBranch()
+	work := b.Pending()
+	if b.Name != "work" || b.OriginBranch() != "origin/master" || len(work) != 1 || work[0].Hash == old.Hash || work[0].ChangeID != old.ChangeID {
+		t.Fatalf("after replant, on %s tracking %s with %d pending; want work tracking origin/master with one new commit with Change-Id %s", b.Name, b.OriginBranch(), len(work), old.ChangeID)
+	}
+	if b.commitsBehind != 0 {
+		t.Fatalf("after replant, %d commits behind origin/master; want 0", b.commitsBehind)
+	}
+
+	// Replant onto a new branch, deleting the old one and
+	// moving a branch stacked on it.
+	testMain(t, "change", "-on", "work", "child")
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	testMain(t, "replant", "-d", "fresh")
+	testPrintedStderr(t, "as branch fresh; deleted branch work.")
+	if b := CurrentBranch(); b.Name != "fresh" || len(b.Pending()) != 1 || b.Pending()[0].ChangeID != old.ChangeID {
+		t.Fatalf("after replant -d, on %s; want fresh with the change", b.Name)
+	}
+	for _, b := range LocalBranches() {
+		if b.Name == "work" {
+			t.Fatalf("replant -d left branch work behind")
+		}
+		if b.Name == "child" && b.Parent() != "fresh" {
+			t.Fatalf("child stacked on %q after replant -d; want fresh", b.Parent())
+		}
+	}
+
+	// A conflict leaves the branch alone.
+	write(t, gt.server+"/file", "conflicting content")
+	trun(t, gt.server, "git", "commit", "-q", "-a", "-m", "conflict")
+	head := trim(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	testMainDied(t, "replant")
+	testPrintedStderr(t, "cannot replant", "conflicts with origin/master in:\n\t\tfile\n", "the branch fresh is unchanged")
+	if b := CurrentBranch(); b.Name != "fresh" || trim(trun(t, gt.client, "git", "rev-parse", "HEAD")) != head {
+		t.Fatalf("after failed replant, on %s; want fresh unchanged", b.Name)
+	}
+}
@@ -16,14 +16,20 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	flags   *flag.FlagSet
 	verbose = new(count) // installed as -v below
 	noRun   = new(bool)
+	noColor = new(bool)
+	quiet   = new(bool)
+	trace   = new(bool)
+	yes     = new(bool)
 )
 
 func initFlags() {
@@ -33,9 +39,13 @@ func initFlags() {
 	}
 	flags.Var(verbose, "v", "report commands")
 	flags.BoolVar(noRun, "n", false, "print but do not run commands")
+	flags.BoolVar(noColor, "no-color", false, "do not use color in output")
+	flags.BoolVar(quiet, "quiet", false, "print only errors")
+	flags.BoolVar(trace, "trace", false, "print the run time of each command")
+	flags.BoolVar(yes, "y", false, "answer yes to confirmation prompts")
 }
 
-const globalFlags = "[-n] [-v]"
+const globalFlags = "[-n] [-v] [-no-color] [-quiet] [-trace] [-y]"
 
 const usage = `Usage: %s <command> ` + globalFlags + `
 Type "%s help" for more information.
@@ -48,12 +58,30 @@ simple interface to the "single-commit feature branch" development model.
 
 See the docs for details: https://godoc.org/golang.org/x/review/git-codereview
 
-The -v flag prints all commands that make changes.
+The -v flag prints all commands that make changes,
+marking their output with a "git| " prefix.
 The -n flag prints all commands that would be run, but does not run them.
+The -no-color flag disables colored output, which is otherwise used when
+standard error is a terminal and $NO_COLOR is not set.
+The -quiet flag suppresses all messages except errors.
+The -trace flag prints a table of all the commands run, including the
+ones that only read state, with how long each took and its exit status.
+The -y flag answers yes to confirmation prompts, which are otherwise
+declined when standard input is not a terminal.
 
 Available commands:
 
-	change [name]
+	abandon [-f] [-gerrit]
+		Delete the current work branch and change to its upstream branch.
+		If -f (or -y) is specified, do not ask for confirmation.
+		If -gerrit is specified, also abandon the pending changes on Gerrit.
+
+	branch-from-change [-b name] [-f] change[/patchset]
+		Fetch a change from Gerrit, by default its latest patch set,
+		into a new local branch named change-<number>, or the name
+		given by -b. If -f is specified, reset an existing branch.
+
+	change [-a] [-q] [-s] [-as identity] [-keep-date | -reset-date] [-m msg | -F file] [name]
 		Create a change commit, or amend an existing change commit,
 		with the staged changes. If a branch name is provided, check
 		out that branch (creating it if it does not exist).
@@ -62,12 +90,56 @@ Available commands:
 		change's commit message.
 		If -a is specified, automatically add any unstaged changes in
 		tracked files during commit.
+		If -m is specified, use the given message as the commit message
+		instead of running the editor.
+		If -F is specified, read the commit message from the given file,
+		or from standard input if the file is -.
+		If -s is specified, add a Signed-off-by trailer to the commit
+		message (the default if codereview.signoff is true).
+		If -as is specified, commit as the given "Name <email>"
+		instead of the git user (the default is codereview.identity).
+		Amending keeps the author date and sets the committer date
+		to now; -keep-date keeps both dates, -reset-date resets both.
+
+	change -base ref name
+		Create the new branch name starting at ref instead of HEAD.
+
+	change -on branch name
+		Create the new branch name stacked on the work branch,
+		for a change that depends on the change in that branch.
+		Sync rebases the new branch onto that branch.
+
+	change -files [-q] [-s] [-as identity] [-keep-date | -reset-date] [-m msg | -F file] path...
+		Stage the changes to the given paths and amend the pending
+		change with them, leaving any other staged changes out.
 
 	change NNNN[/PP]
 		Checkout the commit corresponding to CL number NNNN and
 		patch set PP from Gerrit.
 		If the patch set is omitted, use the current patch set.
 
+	check
+		Check that the current branch is ready to mail: that it is
+		a work branch with exactly one pending commit, which has a
+		Change-Id and is not a merge, and that there are no staged or
+		unstaged changes. Exit with a non-zero status if not.
+
+	config [-list | name [value]]
+		List, show, or set the personal git-codereview settings
+		stored in the git configuration as codereview.<name>.
+
+	edit-message [-m msg]
+		Edit the commit message of the pending change, leaving its
+		files and any staged changes as they are.
+		If -m is specified, use the given message instead of
+		running the editor.
+
+	fixup [-a] [commit]
+		Commit the staged changes as a fixup! commit for the given
+		pending commit, by default the newest one that is not a fixup,
+		to be folded into it later by rebase-work or sync -i.
+		If -a is specified, add changes to any tracked files.
+
 	gofmt [-l]
 		Run gofmt on all tracked files in the staging area and the
 		working tree.
@@ -77,75 +149,238 @@ Available commands:
 	help
 		Show this help text.
 
-	hooks
+	hooks [-reinstall | -status | -uninstall]
 		Install Git commit hooks for Gerrit and gofmt.
 		Every other operation except help also does this,
-		if they are not already installed.
-
-	mail [-f] [-r reviewer,...] [-cc mail,...] [commit]
+		if they are not already installed, unless the git config
+		setting codereview.autohooks is false.
+		If -reinstall is specified, replace hooks that were modified.
+		If -status is specified, only report whether each hook is
+		current, missing, stale, or modified.
+		If -uninstall is specified, remove the hooks installed by
+		git-codereview, leaving modified hooks in place.
+
+	import commit [branchname]
+		Create a new work branch from the upstream branch and
+		cherry-pick the given commit onto it, keeping its Change-Id.
+		If the branch name is omitted, derive it from the commit subject.
+
+	init [-branch name]
+		Set up the repository for code review: install the hooks,
+		check the remote, find the upstream branch (saving it as
+		codereview.branch if needed), and check for a Gerrit server.
+		It is safe to run init more than once.
+
+	mail [-f] [-r reviewer,...] [-cc mail,...] [-as identity] [-for branch] [-label vote,...] [-m msg] [-force] [-no-verify] [-open] [-owners] [-remote remote] [-topic topic] [-trybot] [-wip | -ready] [commit]
 		Upload change commit to the code review server and send mail
 		requesting a code review.
 		If there are multiple commits on this branch, upload commits
 		up to and including the named commit.
 		If -f is specified, upload even if there are staged changes.
+		If -as is specified, amend as the given "Name <email>" when
+		adding a missing Change-Id line.
 		The -r and -cc flags identify the email addresses of people to
 		do the code review and to be CC'ed about the code review.
 		Multiple addresses are given as a comma-separated list.
-
-	mail -diff
+		If -for is specified, send the change for review on that server
+		branch instead of the upstream branch.
+		If -label is specified, cast the given votes, like
+		Code-Review+2, on the change; it is meant for automation.
+		If -m is specified, attach the message to the new patch set
+		as a review comment, such as 'addressed review comments'.
+		If -no-verify is specified, skip the checks for staged changes,
+		an empty commit, and multiple pending commits, and skip the
+		pre-push hook.
+		If -owners is specified, also request review from the owners
+		of the changed files listed in OWNERS files.
+		If -open is specified, open the change in a web browser.
+		If -remote is specified, push to that remote instead of the one
+		named by codereview.remote, by default the Gerrit server
+		in .gitreview if there is one, or else origin.
+		If -topic is specified, set the Gerrit topic of the change; if
+		codereview.topicfrombranch is true, the default is the branch name.
+		If -trybot is specified, run the trybots on the change.
+		If -wip is specified, mark the change as work in progress;
+		if -ready is specified, mark it as ready for review.
+		If codereview.backend is github, open or update a GitHub
+		pull request for the current branch instead, refusing to
+		overwrite unexpected remote commits unless -force is specified.
+		After mailing, run the codereview.postmailhook command, if set.
+
+	mail -diff [-stat | -name-only] [-word-diff | -color-words] [-against ref | -staged | -worktree] [commit] [-- [git-diff-option...] [--] pathspec...]
 		Show the changes but do not send mail or upload.
-
-	pending [-c] [-l] [-s]
+		If paths are given, show only the changes to those paths.
+		Options before the paths are passed through to git diff.
+		If -stat is specified, show only a diffstat.
+		If -name-only is specified, show only the names of changed files.
+		If -word-diff or -color-words is specified, show changed words
+		instead of changed lines.
+		If -against is specified, diff from the merge base with ref,
+		as in 'git diff ref...commit'.
+		If -staged is specified, show the staged changes, which the
+		change command would fold into the change commit, instead;
+		if -worktree is specified, show the unstaged changes.
+
+	open [commit]
+		Open the Gerrit page for the pending change in a web browser.
+
+	pending [-c] [-json] [-l] [-limit n] [-s] [-sort name|date]
 		Show the status of all pending changes and staged, unstaged,
 		and untracked files in the local repository.
 		If -c is specified, show only changes on the current branch.
+		If -json is specified, print the status as JSON.
 		If -l is specified, only use locally available information.
+		If -limit is specified, show at most n branches.
 		If -s is specified, show short output.
+		If -sort is name, list branches by name instead of by date.
+
+	prune [-dry-run]
+		Delete the local work branches whose changes have all been
+		submitted, after asking for confirmation.
+		If -dry-run is specified, only list those branches.
+
+	rename newname
+		Rename the current work branch.
+
+	replant [-d] [newname]
+		Fetch, then copy the pending change of the current work branch,
+		keeping its Change-Id, onto a fresh copy of its upstream branch:
+		the current branch, or a new branch named newname.
+		If -d is specified, delete the old branch.
+
+	squash
+		Combine all pending commits on the current branch into a single
+		change commit, using the commit message of the first one.
 
 	submit [-i | commit...]
 		Push the pending change to the Gerrit server and tell Gerrit to
-		submit it to the master branch.
+		submit it to the upstream branch.
 
-	sync
+	sync [-no-autostash] [-merge | -i] [-stat]
 		Fetch changes from the remote repository and merge them into
-		the current branch, rebasing the change commit on top of them.
+		the current branch, rebasing the change commit on top of them,
+		and report how many new upstream commits there were.
+		If -stat is specified, also list them.
+		Uncommitted changes are stashed during the sync and restored
+		afterward, unless -no-autostash is specified, in which case
+		sync refuses to run with uncommitted changes.
+		If -merge is specified, merge the changes instead of rebasing.
+		If -i is specified, rebase interactively, squashing fixup commits.
+		On a branch created with change -on, rebase onto that branch
+		instead of the upstream branch, until its changes are submitted.
+
+	sync [-i] [-stat] -onto ref
+		Rebase the pending changes onto ref instead of the upstream
+		branch, after fetching changes from the remote repository,
+		and report and list new commits as above.
+
+	sync -all
+		Fetch changes from the remote repository once and rebase every
+		local work branch that is behind its upstream branch.
+
+	sync -preserve-chain
+		Fetch changes from the remote repository once and rebase the
+		current branch and the branches stacked on it, each onto its
+		parent, keeping the chain of stacked branches intact.
+
+	sync -fetch-only
+		Fetch changes from the remote repository and report how many
+		commits the current branch is ahead of and behind its upstream
+		branch, without changing the branch.
+
+	sync -continue | -abort
+		Continue or abort a sync that stopped because of conflicts.
+
+	whoami
+		Show the git identity, the push remote, and the Gerrit
+		account used for code review, checking that signing in works.
+
+	worktree [-remove] branchname
+		Create a new work branch from the upstream branch, checked out
+		in a new worktree in a sibling directory of the repository,
+		to work on several changes at once without switching branches.
+		If -remove is specified, remove the worktree of the branch
+		instead, refusing if it has uncommitted changes.
+
+	version
+		Print the version of git-codereview. Also available as -version.
 
 Environment Variables:
 
+	BROWSER
+		A colon-separated list of web browser commands; open and mail -open
+		use the first one. If unset, the system's default browser is used.
+
 	GIT_ALLOW_PROTOCOL
 		Defined by Git. A colon-separated list of schemes that are allowed to be
 		used with git commands. If set, any scheme not explicitly mentioned will
 		not be allowed.
 
+	GITHUB_TOKEN
+		The GitHub API token used by mail when codereview.backend is
+		github. If unset, the token comes from 'gh auth token'.
+
+	NO_COLOR
+		If set, disables colored output, like the -no-color flag.
+
+Exit Status:
+
+	0	success
+	1	any other failure
+	2	invalid command line
+	3	not in a git repository
+	4	no pending change to operate on
+	5	command not allowed on the current branch
+	6	a git command failed
+
 
 `
 
 func main() {
 	initFlags()
+	traceLog = nil
 
 	if len(os.Args) < 2 {
 		flags.Usage()
 		if dieTrap != nil {
 			dieTrap()
 		}
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 	command, args := os.Args[1], os.Args[2:]
 
-	if command == "help" {
+	// Commands that do not use the repository run before the checks below,
+	// so that they work outside a repository and do not install hooks.
+	// Completion scripts in particular are often generated from a
+	// shell startup file, in whatever directory the shell starts in.
+	switch command {
+	case "help", "-h", "-help", "--help":
 		fmt.Fprintf(stdout(), help, os.Args[0])
 		return
+	case "completion":
+		cmdCompletion(args)
+		return
+	case "version", "-version", "--version":
+		cmdVersion(args)
+		return
 	}
 
-	// Install hooks automatically, but only if this is a Gerrit repo.
-	if haveGerrit() {
+	if _, err := cmdOutputErr("git", "rev-parse", "--git-dir"); err != nil {
+		exitf(exitNotRepo, "not in a git repository")
+	}
+
+	// Install hooks automatically, but only if this is a Gerrit repo
+	// and the user has not turned it off with codereview.autohooks.
+	// The hooks and init commands do their own installation, and hook-invoke
+	// runs from within a hook, while git is in the middle of a command.
+	if haveGerrit() && command != "hooks" && command != "init" && command != "hook-invoke" && gitConfigBool("autohooks", true) {
 		// Don't pass installHook args directly,
 		// since args might contain args meant for other commands.
 		// Filter down to just global flags.
 		var hookArgs []string
 		for _, arg := range args {
 			switch arg {
-			case "-n", "-v":
+			case "-n", "-v", "-no-color", "-quiet", "-trace", "-y":
 				hookArgs = append(hookArgs, arg)
 			}
 		}
@@ -153,61 +388,144 @@ func main() {
 	}
 
 	switch command {
+	case "abandon":
+		cmdAbandon(args)
 	case "branchpoint":
 		cmdBranchpoint(args)
+	case "branch-from-change":
+		cmdBranchFromChange(args)
 	case "change":
 		cmdChange(args)
+	case "check":
+		cmdCheck(args)
+	case "config":
+		cmdConfig(args)
+	case "edit-message":
+		cmdEditMessage(args)
+	case "fixup":
+		cmdFixup(args)
 	case "gofmt":
 		cmdGofmt(args)
 	case "hook-invoke":
 		cmdHookInvoke(args)
 	case "hooks":
-		installHook(args) // in case above was bypassed
+		cmdHooks(args)
+	case "import":
+		cmdImport(args)
+	case "init":
+		cmdInit(args)
 	case "mail", "m":
 		cmdMail(args)
+	case "open":
+		cmdOpen(args)
 	case "pending":
 		cmdPending(args)
+	case "prune":
+		cmdPrune(args)
 	case "rebase-work":
 		cmdRebaseWork(args)
+	case "rename":
+		cmdRename(args)
+	case "replant":
+		cmdReplant(args)
+	case "squash":
+		cmdSquash(args)
 	case "submit":
 		cmdSubmit(args)
 	case "sync":
 		cmdSync(args)
+	case "worktree":
+		cmdWorktree(args)
+	case "whoami":
+		cmdWhoami(args)
 	case "test-loadAuth": // for testing only
 		loadAuth()
 	default:
 		flags.Usage()
 	}
+	printTrace()
 }
 
 func expectZeroArgs(args []string, command string) {
 	flags.Parse(args)
 	if len(flags.Args()) > 0 {
 		fmt.Fprintf(stderr(), "Usage: %s %s %s\n", os.Args[0], command, globalFlags)
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 }
 
 func run(command string, args ...string) {
 	if err := runErr(command, args...); err != nil {
-		if *verbose == 0 {
-			// If we're not in verbose mode, print the command
-			// before dying to give context to the failure.
-			fmt.Fprintf(stderr(), "(running: %s)\n", commandString(command, args))
-		}
-		dief("%v", err)
+		dieRun(err, command, args...)
+	}
+}
+
+// dieRun reports that running the command failed with err and dies.
+func dieRun(err error, command string, args ...string) {
+	if *verbose == 0 && !*quiet {
+		// If we're not in verbose or quiet mode, print the command
+		// before dying to give context to the failure.
+		fmt.Fprintf(stderr(), "(running: %s)\n", commandString(command, args))
 	}
+	exitf(exitGitFailed, "%v", err)
 }
 
 func runErr(command string, args ...string) error {
 	return runDirErr("", command, args...)
 }
 
+// runCaptureErr is like runErr but also returns the command's standard error,
+// which is still copied to stderr() as the command runs.
+func runCaptureErr(command string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	err := runDirTeeErr("", &buf, command, args...)
+	return buf.String(), err
+}
+
+// runRemote is like run, but for commands that contact the remote
+// repository and never wait for the user, such as git fetch and git push.
+// If the command runs longer than the codereview.timeout setting,
+// runRemote kills it and dies.
+func runRemote(command string, args ...string) {
+	if _, err := runRemoteCaptureErr(command, args...); err != nil {
+		dieRun(err, command, args...)
+	}
+}
+
+// runRemoteCaptureErr is like runCaptureErr, with the timeout of runRemote.
+func runRemoteCaptureErr(command string, args ...string) (string, error) {
+	var buf bytes.Buffer
+	err := runCmdErr("", &buf, remoteTimeout(), command, args...)
+	return buf.String(), err
+}
+
+// remoteTimeout returns the time limit for commands run by runRemote,
+// from the codereview.timeout setting, in seconds. Zero means no limit.
+func remoteTimeout() time.Duration {
+	s := gitConfigDefault("timeout")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		dief("invalid codereview.timeout setting %q: must be a non-negative number of seconds", s)
+	}
+	return time.Duration(n) * time.Second
+}
+
 var runLogTrap []string
 
 func runDirErr(dir, command string, args ...string) error {
+	return runDirTeeErr(dir, nil, command, args...)
+}
+
+// runDirTeeErr runs the command, copying its standard error to errCopy if not nil.
+func runDirTeeErr(dir string, errCopy io.Writer, command string, args ...string) error {
+	return runCmdErr(dir, errCopy, 0, command, args...)
+}
+
+// runCmdErr is like runDirTeeErr, but if timeout is not zero,
+// it kills the command once it has run for that long.
+func runCmdErr(dir string, errCopy io.Writer, timeout time.Duration, command string, args ...string) error {
 	if *verbose > 0 || *noRun {
-		fmt.Fprintln(stderr(), commandString(command, args))
+		fmt.Fprintln(stderr(), colorize(colorCommand, commandString(command, args)))
 	}
 	if *noRun {
 		return nil
@@ -219,7 +537,66 @@ func runDirErr(dir, command string, args ...string) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = stdout()
 	cmd.Stderr = stderr()
-	return cmd.Run()
+	if *verbose > 0 && !mayEdit(command, args) {
+		// Mark the command's output, to tell it apart from our own.
+		// Commands that may start an editor keep the terminal.
+		prefix := filepath.Base(command) + "| "
+		cmd.Stdout = &prefixWriter{w: cmd.Stdout, prefix: prefix}
+		cmd.Stderr = &prefixWriter{w: cmd.Stderr, prefix: prefix}
+	}
+	if errCopy != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, errCopy)
+	}
+	start := time.Now()
+	err := runInterruptible(cmd, timeout)
+	traceCommand(start, err, command, args)
+	return err
+}
+
+// mayEdit reports whether the command may run the user's editor,
+// which needs direct access to the terminal.
+func mayEdit(command string, args []string) bool {
+	if command != "git" {
+		return false
+	}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-c":
+			i++ // skip value
+		case !strings.HasPrefix(args[i], "-"):
+			switch args[i] {
+			case "cherry-pick", "commit", "merge", "pull", "rebase", "revert", "tag":
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// A prefixWriter writes to w, starting each line with prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	mid    bool // in the middle of a line
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	var buf []byte
+	for _, c := range p {
+		if !pw.mid {
+			buf = append(buf, pw.prefix...)
+			pw.mid = true
+		}
+		buf = append(buf, c)
+		if c == '\n' {
+			pw.mid = false
+		}
+	}
+	if _, err := pw.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
 // cmdOutput runs the command line, returning its output.
@@ -271,13 +648,15 @@ func cmdOutputDirErr(dir, command string, args ...string) (string, error) {
 	// the git repo" commands, which is confusing if you are just trying to find
 	// out what git sync means.
 	if *verbose > 1 {
-		fmt.Fprintln(stderr(), commandString(command, args))
+		fmt.Fprintln(stderr(), colorize(colorCommand, commandString(command, args)))
 	}
 	cmd := exec.Command(command, args...)
 	if dir != "." {
 		cmd.Dir = dir
 	}
+	start := time.Now()
 	b, err := cmd.CombinedOutput()
+	traceCommand(start, err, command, args)
 	return string(b), err
 }
 
@@ -319,21 +698,46 @@ func commandString(command string, args []string) string {
 
 var dieTrap func()
 
+// Exit codes, so that scripts can tell failures apart.
+// They are listed in the help text.
+const (
+	exitFailure     = 1 // any other failure
+	exitUsage       = 2 // invalid command line
+	exitNotRepo     = 3 // not in a git repository
+	exitNoChanges   = 4 // no pending change to operate on
+	exitWrongBranch = 5 // command not allowed on the current branch
+	exitGitFailed   = 6 // a git command failed
+)
+
 func dief(format string, args ...interface{}) {
-	printf(format, args...)
-	die()
+	exitf(exitFailure, format, args...)
+}
+
+// exitf is like dief but exits with the given status code.
+func exitf(code int, format string, args ...interface{}) {
+	colorPrintf(colorError, format, args...)
+	exit(code)
 }
 
 func die() {
+	exit(exitFailure)
+}
+
+// exitCode is the status of the last call to exit, for testing.
+var exitCode int
+
+func exit(code int) {
+	printTrace()
+	exitCode = code
 	if dieTrap != nil {
 		dieTrap()
 	}
-	os.Exit(1)
+	os.Exit(code)
 }
 
 func verbosef(format string, args ...interface{}) {
 	if *verbose > 0 {
-		printf(format, args...)
+		colorPrintf(colorVerbose, format, args...)
 	}
 }
 
@@ -354,7 +758,78 @@ func stderr() io.Writer {
 }
 
 func printf(format string, args ...interface{}) {
-	fmt.Fprintf(stderr(), "%s: %s\n", os.Args[0], fmt.Sprintf(format, args...))
+	if *quiet {
+		return
+	}
+	colorPrintf("", format, args...)
+}
+
+// colorPrintf is like printf but shows the message in the given color.
+func colorPrintf(color, format string, args ...interface{}) {
+	fmt.Fprintf(stderr(), "%s\n", colorize(color, os.Args[0]+": "+fmt.Sprintf(format, args...)))
+}
+
+// ANSI escape sequences used to color output.
+const (
+	colorError   = "\x1b[31m" // red
+	colorVerbose = "\x1b[2m"  // dim
+	colorCommand = "\x1b[36m" // cyan
+	colorReset   = "\x1b[0m"
+)
+
+// colorize returns text shown in the given color,
+// or text unchanged if color is "" or colored output is disabled.
+func colorize(color, text string) string {
+	if color == "" || !useColor() {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// confirm asks the user to confirm an operation by printing prompt
+// followed by " (y/n)? " and reading the answer from standard input.
+// It reports whether the answer was yes. With the -y flag, it answers
+// yes without asking. If standard input is not a terminal, there is no
+// one to ask, so it declines and says that -y is needed.
+func confirm(prompt string) bool {
+	if *yes {
+		return true
+	}
+	if !stdinIsTerminal() {
+		fmt.Fprintf(stderr(), "%s: not confirmed, because standard input is not a terminal; use -y to confirm\n", prompt)
+		return false
+	}
+	fmt.Fprintf(stderr(), "%s (y/n)? ", prompt)
+	return scanYes()
+}
+
+// stdinIsTerminal reports whether standard input is a terminal.
+// It is a variable so that tests can override it.
+var stdinIsTerminal = func() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too.
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
+
+// useColor reports whether to use colored output on standard error.
+// Color is only used when standard error is a terminal, and it can be
+// disabled with the -no-color flag or by setting $NO_COLOR.
+func useColor() bool {
+	if *noColor || os.Getenv("NO_COLOR") != "" || stderrTrap != nil {
+		return false
+	}
+	return stderrIsTerminal()
+}
+
+// stderrIsTerminal reports whether standard error is a terminal.
+// It is a variable so that tests can override it.
+var stderrIsTerminal = func() bool {
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
 
 // count is a flag.Value that is like a flag.Bool and a flag.Int.
@@ -0,0 +1,181 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestExitCodes(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	check := func(want int) {
+		t.Helper()
+		if exitCode != want {
+			t.Errorf("exit code = %d, want %d", exitCode, want)
+		}
+	}
+
+	testMainDied(t, "change")
+	testPrintedStderr(t, "can't commit to master branch")
+	check(exitWrongBranch)
+
+	testMainDied(t, "mail")
+	testPrintedStderr(t, "cannot mail: no changes pending")
+	check(exitNoChanges)
+
+	testMainDied(t, "rename", "work")
+	check(exitWrongBranch)
+
+	// A failing git command.
+	write(t, gt.server+"/file", "conflicting content")
+	trun(t, gt.server, "git", "commit", "-a", "-m", "conflict")
+	gt.work(t)
+	testMainDied(t, "sync", "-merge")
+	check(exitGitFailed)
+	trun(t, gt.client, "git", "merge", "--abort")
+}
+
+func TestExitNotRepo(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	dir := gt.tmpdir + "/notrepo"
+	mkdir(t, dir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_CEILING_DIRECTORIES", gt.tmpdir)
+	defer os.Unsetenv("GIT_CEILING_DIRECTORIES")
+
+	testMainDied(t, "pending")
+	testPrintedStderr(t, "not in a git repository")
+	if exitCode != exitNotRepo {
+		t.Errorf("exit code = %d, want %d", exitCode, exitNotRepo)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// No repository needed.
+	dir := gt.tmpdir + "/notrepo"
+	mkdir(t, dir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_CEILING_DIRECTORIES", gt.tmpdir)
+	defer os.Unsetenv("GIT_CEILING_DIRECTORIES")
+
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+	version, commit, buildDate = "v1.2.0", "0123456789abcdef", "2015-04-01"
+	for _, arg := range []string{"version", "-version", "--version"} {
+		testMain(t, arg)
+		testPrintedStdout(t, "git-codereview version v1.2.0 (commit 0123456789ab, built 2015-04-01)\n")
+		testNoStderr(t)
+	}
+}
+
+func TestHelpNotRepo(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	dir := gt.tmpdir + "/notrepo"
+	mkdir(t, dir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("GIT_CEILING_DIRECTORIES", gt.tmpdir)
+	defer os.Unsetenv("GIT_CEILING_DIRECTORIES")
+
+	for _, arg := range []string{"help", "-h", "--help"} {
+		testMain(t, arg)
+		testPrintedStdout(t, "Available commands:")
+		testNoStderr(t)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMain(t, "pending", "-l")
+	testPrintedStderr(t, "!status")
+
+	testMain(t, "pending", "-l", "-trace")
+	testPrintedStderr(t, "time  status  command\n", " ok  git status -b --porcelain\n", " commands, ")
+
+	testMainDied(t, "rename", "-trace", "newname")
+	testPrintedStderr(t, "cannot rename", " ok  git symbolic-ref -q --short HEAD\n")
+}
+
+func TestVerbosePrefix(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	stdoutTrap = new(bytes.Buffer)
+	stderrTrap = new(bytes.Buffer)
+	*verbose = 1
+	defer func() {
+		stdoutTrap = nil
+		stderrTrap = nil
+		*verbose = 0
+	}()
+
+	run("git", "rev-parse", "--abbrev-ref", "HEAD")
+	if out := stdoutTrap.String(); out != "git| master\n" {
+		t.Errorf("verbose output = %q, want %q", out, "git| master\n")
+	}
+	if !mayEdit("git", []string{"-c", "rebase.autoSquash=true", "rebase", "-i"}) || mayEdit("git", []string{"-c", "commit", "status"}) {
+		t.Errorf("mayEdit misidentified git commands")
+	}
+
+	var buf bytes.Buffer
+	pw := &prefixWriter{w: &buf, prefix: "> "}
+	for _, s := range []string{"one\ntw", "o\n", "\nthree"} {
+		pw.Write([]byte(s))
+	}
+	if want := "> one\n> two\n> \n> three"; buf.String() != want {
+		t.Errorf("prefixWriter wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestColor(t *testing.T) {
+	defer func(f func() bool) { stderrIsTerminal = f }(stderrIsTerminal)
+	stderrIsTerminal = func() bool { return true }
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	os.Unsetenv("NO_COLOR")
+
+	if !useColor() {
+		t.Fatalf("useColor() = false on a terminal, want true")
+	}
+	if got, want := colorize(colorError, "oops"), "\x1b[31moops\x1b[0m"; got != want {
+		t.Errorf("colorize(colorError, %q) = %q, want %q", "oops", got, want)
+	}
+	if got := colorize("", "plain"); got != "plain" {
+		t.Errorf("colorize(\"\", %q) = %q, want it unchanged", "plain", got)
+	}
+
+	*noColor = true
+	if useColor() || colorize(colorError, "oops") != "oops" {
+		t.Errorf("-no-color does not disable color")
+	}
+	*noColor = false
+
+	os.Setenv("NO_COLOR", "1")
+	if useColor() || colorize(colorError, "oops") != "oops" {
+		t.Errorf("$NO_COLOR does not disable color")
+	}
+	os.Unsetenv("NO_COLOR")
+
+	stderrIsTerminal = func() bool { return false }
+	if useColor() || colorize(colorError, "oops") != "oops" {
+		t.Errorf("color used when standard error is not a terminal")
+	}
+}
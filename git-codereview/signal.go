@@ -0,0 +1,176 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runInterruptible runs cmd, which must not have been started.
+// While cmd runs, an interrupt or termination signal does not kill
+// git-codereview. Instead the signal is passed on to cmd, so that git can
+// clean up after itself. If cmd then fails, runInterruptible explains
+// the state the repository was left in and dies; if cmd finishes its
+// work anyway, runInterruptible returns as usual.
+//
+// If timeout is not zero and cmd is still running after that long,
+// runInterruptible kills it and returns a "timed out" error.
+func runInterruptible(cmd *exec.Cmd, timeout time.Duration) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	var pipes []*outputPipe
+	if timeout > 0 {
+		// Killing git does not kill the helpers it started, such as ssh
+		// or git-remote-https, which may be just as stuck. When cmd's
+		// output is copied through a pipe, they hold it open, and Wait
+		// would wait for them too. Copy the output through pipes of our
+		// own instead, and stop copying soon after git exits. (Killing
+		// git's whole process group instead would need a process group
+		// of its own, which would take ssh out of the terminal's
+		// foreground and stop its prompts.)
+		for _, w := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+			if _, ok := (*w).(*os.File); ok || *w == nil {
+				continue
+			}
+			p, err := newOutputPipe(*w)
+			if err != nil {
+				for _, p := range pipes {
+					p.w.Close()
+				}
+				closePipes(pipes)
+				return err
+			}
+			*w = p.w
+			pipes = append(pipes, p)
+		}
+	}
+	err := cmd.Start()
+	for _, p := range pipes {
+		p.w.Close() // only cmd writes to the pipe now
+	}
+	if err != nil {
+		closePipes(pipes)
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		// git has exited; give whatever it left running
+		// a little longer to finish writing its output.
+		closePipes(pipes)
+		done <- err
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+
+	var caught os.Signal
+	timedOut := false
+	for {
+		select {
+		case <-expired:
+			cmd.Process.Kill()
+			timedOut = true
+		case s := <-sig:
+			// An interrupt typed at the terminal already went to
+			// the whole process group, command included, and git
+			// may not survive a second one while cleaning up.
+			if caught == nil && s != os.Interrupt {
+				cmd.Process.Signal(s)
+			}
+			caught = s
+		case err := <-done:
+			if caught != nil && err != nil {
+				dief("interrupted%s", interruptedState())
+			}
+			if timedOut {
+				return fmt.Errorf("timed out after %v (see codereview.timeout)", timeout)
+			}
+			return err
+		}
+	}
+}
+
+// waitDelay is how long runInterruptible waits, after a command with a
+// timeout exits, for processes it left behind to close its output.
+const waitDelay = 2 * time.Second
+
+// An outputPipe copies what a command writes to w to another writer.
+type outputPipe struct {
+	r, w   *os.File
+	copied chan bool // closed when the copy stops
+}
+
+// newOutputPipe returns a pipe copying to dst.
+func newOutputPipe(dst io.Writer) (*outputPipe, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p := &outputPipe{r: r, w: w, copied: make(chan bool)}
+	go func() {
+		io.Copy(dst, r)
+		close(p.copied)
+	}()
+	return p, nil
+}
+
+// closePipes waits up to waitDelay for the copies through pipes to
+// reach the end of their input and then stops them.
+func closePipes(pipes []*outputPipe) {
+	t := time.NewTimer(waitDelay)
+	defer t.Stop()
+	expired := false
+	for _, p := range pipes {
+		if !expired {
+			select {
+			case <-p.copied:
+			case <-t.C:
+				expired = true
+			}
+		}
+		p.r.Close()
+		<-p.copied
+	}
+}
+
+// interruptedState describes an operation left in progress in the
+// repository, and how to resolve it, for the message printed after an
+// interrupt. It returns an empty string if there is nothing to resolve.
+func interruptedState() string {
+	switch {
+	case rebaseInProgress():
+		return "; a rebase is in progress:\n" +
+			"\trun 'git-codereview sync -continue' to finish it, or\n" +
+			"\trun 'git-codereview sync -abort' to restore the branch"
+	case pathExists(gitPath("MERGE_HEAD")):
+		return "; a merge is in progress:\n" +
+			"\trun 'git commit' to finish it, or\n" +
+			"\trun 'git merge --abort' to restore the branch"
+	case pathExists(gitPath("CHERRY_PICK_HEAD")):
+		return "; a cherry-pick is in progress:\n" +
+			"\trun 'git cherry-pick --continue' to finish it, or\n" +
+			"\trun 'git cherry-pick --abort' to restore the branch"
+	}
+	return ""
+}
+
+// pathExists reports whether the named file or directory exists.
+func pathExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunInterruptible(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("no kill command on %s", runtime.GOOS)
+	}
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Pretend to be stopped in the middle of a rebase.
+	mkdir(t, gitPath("rebase-merge"))
+
+	stderrTrap = new(bytes.Buffer)
+	died := false
+	dieTrap = func() {
+		died = true
+		panic("died")
+	}
+	defer func() {
+		msg := stderrTrap.String()
+		dieTrap = nil
+		stderrTrap = nil
+		recover()
+		if !died {
+			t.Fatalf("did not die after SIGTERM")
+		}
+		if !strings.Contains(msg, "interrupted; a rebase is in progress") || !strings.Contains(msg, "sync -abort") {
+			t.Errorf("wrong message after SIGTERM:\n%s", msg)
+		}
+	}()
+
+	// The command sends git-codereview SIGTERM, which must be
+	// passed on to the command, here the sleep, to end it.
+	cmd := exec.Command("sh", "-c", "kill -TERM $PPID; exec sleep 30")
+	cmd.Stderr = os.Stderr
+	runInterruptible(cmd, 0)
+}
+
+func TestRunInterruptibleSucceeds(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("no kill command on %s", runtime.GOOS)
+	}
+
+	// The command catches the SIGTERM passed on to it and exits 0,
+	// which is not an interruption worth dying over.
+	cmd := exec.Command("sh", "-c", "trap 'exit 0' TERM; kill -TERM $PPID; sleep 5 >/dev/null 2>&1 & wait")
+	cmd.Stderr = os.Stderr
+	if err := runInterruptible(cmd, 0); err != nil {
+		t.Fatalf("runInterruptible = %v, want success", err)
+	}
+}
+
+func TestInterruptedState(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	if s := interruptedState(); s != "" {
+		t.Errorf("interruptedState() = %q, want empty", s)
+	}
+	write(t, gitPath("MERGE_HEAD"), "0000000000000000000000000000000000000000\n")
+	if s := interruptedState(); !strings.Contains(s, "git merge --abort") {
+		t.Errorf("interruptedState() = %q, want merge instructions", s)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	start := time.Now()
+	err := runInterruptible(exec.Command("sleep", "30"), 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("runInterruptible = %v, want timed out error", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("runInterruptible took %v to time out", d)
+	}
+
+	if err := runInterruptible(exec.Command("true"), time.Minute); err != nil {
+		t.Fatalf("runInterruptible(true) = %v", err)
+	}
+}
+
+func TestRunTimeoutHelper(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("no sh command on %s", runtime.GOOS)
+	}
+
+	// Like ssh started by git, the background sleep outlives the
+	// killed shell and holds on to its standard error, a pipe here.
+	cmd := exec.Command("sh", "-c", "sleep 30 & sleep 30")
+	var buf bytes.Buffer
+	cmd.Stderr = &buf
+	start := time.Now()
+	err := runInterruptible(cmd, 100*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("runInterruptible = %v, want timed out error", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("runInterruptible took %v to time out", d)
+	}
+
+	// A command that succeeds but leaves something running
+	// with its standard error still succeeds.
+	cmd = exec.Command("sh", "-c", "sleep 30 &")
+	cmd.Stderr = &buf
+	if err := runInterruptible(cmd, time.Minute); err != nil {
+		t.Fatalf("runInterruptible(sleep &) = %v", err)
+	}
+}
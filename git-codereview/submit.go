@@ -23,10 +23,11 @@ func cmdSubmit(args []string) {
 	flags.Parse(args)
 	if interactive && flags.NArg() > 0 {
 		flags.Usage()
-		os.Exit(2)
+		os.Exit(exitUsage)
 	}
 
 	b := CurrentBranch()
+	b.checkAttached("submit")
 	var cs []*Commit
 	if interactive {
 		hashes := submitHashes(b)
@@ -61,12 +62,12 @@ func cmdSubmit(args []string) {
 
 	// Sync client to revision that Gerrit committed, but only if we can do it cleanly.
 	// Otherwise require user to run 'git sync' themselves (if they care).
-	run("git", "fetch", "-q")
+	runRemote("git", "fetch", "-q")
 	if len(cs) == 1 && len(b.Pending()) == 1 {
 		if err := runErr("git", "checkout", "-q", "-B", b.Name, g.CurrentRevision, "--"); err != nil {
 			dief("submit succeeded, but cannot sync local branch\n"+
 				"\trun 'git sync' to sync, or\n"+
-				"\trun 'git branch -D %s; git change master; git sync' to discard local branch", b.Name)
+				"\trun 'git branch -D %s; git change %s; git sync' to discard local branch", b.Name, upstreamBranch())
 		}
 	} else {
 		printf("submit succeeded; run 'git sync' to sync")
@@ -93,7 +94,7 @@ func submit(b *Branch, c *Commit) *GerritChange {
 	// Upload most recent revision if not already on server.
 
 	if c.Hash != g.CurrentRevision {
-		run("git", "push", "-q", "origin", b.PushSpec(c))
+		runRemote("git", "push", "-q", "origin", b.PushSpec(c))
 
 		// Refetch change information, especially mergeable.
 		g, err = b.GerritChange(c, "LABELS", "CURRENT_REVISION")
@@ -4,22 +4,104 @@
 
 package main
 
-import "strings"
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	syncAll         bool   // -all flag, rebase all work branches
+	syncFetchOnly   bool   // -fetch-only flag, fetch and report but do not rebase
+	syncMerge       bool   // -merge flag, merge instead of rebase
+	syncInteractive bool   // -i flag, interactive rebase
+	syncContinue    bool   // -continue flag, continue after resolving conflicts
+	syncAbort       bool   // -abort flag, abort a conflicted sync
+	syncNoAutostash bool   // -no-autostash flag, refuse to sync uncommitted changes
+	syncOnto        string // -onto flag, rebase onto this commit instead
+	syncChainFlag   bool   // -preserve-chain flag, also rebase the branches stacked on this one
+	syncStat        bool   // -stat flag, list the upstream commits that the sync brought in
+	syncStashed     bool   // uncommitted changes were stashed for this sync
+)
+
+// syncStashMessage is the message of the stash entries created by sync,
+// by which sync -continue and -abort recognize them.
+const syncStashMessage = "git-codereview sync autostash"
 
 func cmdSync(args []string) {
-	expectZeroArgs(args, "sync")
+	flags.BoolVar(&syncAll, "all", false, "rebase all local work branches")
+	flags.BoolVar(&syncFetchOnly, "fetch-only", false, "fetch and report how far behind the branch is, without rebasing")
+	flags.BoolVar(&syncMerge, "merge", false, "merge upstream changes instead of rebasing")
+	flags.BoolVar(&syncInteractive, "i", false, "rebase interactively")
+	flags.BoolVar(&syncContinue, "continue", false, "continue sync after resolving conflicts")
+	flags.BoolVar(&syncAbort, "abort", false, "abort sync with conflicts")
+	flags.BoolVar(&syncNoAutostash, "no-autostash", false, "refuse to sync with uncommitted changes instead of stashing them")
+	flags.BoolVar(&syncChainFlag, "preserve-chain", false, "also rebase the branches stacked on this one, keeping them stacked")
+	flags.BoolVar(&syncStat, "stat", false, "list the new upstream commits")
+	flags.StringVar(&syncOnto, "onto", "", "rebase the pending changes onto `ref` instead of the upstream branch")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s sync %s [-no-autostash] [-merge | -i | -continue | -abort] [-onto ref] [-stat]\n"+
+			"       %s sync %s -all\n"+
+			"       %s sync %s -preserve-chain\n"+
+			"       %s sync %s -fetch-only\n", os.Args[0], globalFlags, os.Args[0], globalFlags, os.Args[0], globalFlags, os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	syncStashed = false
+	if len(flags.Args()) > 0 || countTrue(syncMerge, syncInteractive, syncContinue, syncAbort) > 1 ||
+		syncOnto != "" && countTrue(syncMerge, syncContinue, syncAbort) > 0 ||
+		syncAll && (syncOnto != "" || countTrue(syncMerge, syncInteractive, syncContinue, syncAbort, syncNoAutostash) > 0) ||
+		syncFetchOnly && (syncOnto != "" || countTrue(syncAll, syncMerge, syncInteractive, syncContinue, syncAbort, syncNoAutostash) > 0) ||
+		syncChainFlag && (syncOnto != "" || countTrue(syncAll, syncFetchOnly, syncMerge, syncInteractive, syncContinue, syncAbort, syncNoAutostash) > 0) ||
+		syncStat && countTrue(syncAll, syncFetchOnly, syncChainFlag, syncContinue, syncAbort) > 0 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+
+	if syncAll {
+		syncAllBranches()
+		return
+	}
+	if syncFetchOnly {
+		syncFetch()
+		return
+	}
+	if syncChainFlag {
+		syncChain()
+		return
+	}
+
+	if syncContinue || syncAbort {
+		if !rebaseInProgress() {
+			dief("cannot sync: no rebase in progress")
+		}
+		if syncContinue {
+			syncRun("git", "rebase", "--continue")
+		} else {
+			run("git", "rebase", "--abort")
+		}
+		popSyncStash()
+		return
+	}
 
 	// Get current branch and commit ID for fixup after pull.
 	b := CurrentBranch()
+	b.checkAttached("sync")
 	var id string
 	if work := b.Pending(); len(work) > 0 {
 		id = work[0].ChangeID
 	}
 
-	// Don't sync with staged or unstaged changes.
-	// rebase is going to complain if we don't, and we can give a nicer error.
-	checkStaged("sync")
-	checkUnstaged("sync")
+	// Rebase refuses to run with staged or unstaged changes,
+	// so set them aside for the duration of the sync.
+	// With -no-autostash, refuse too, but with a nicer error.
+	if syncNoAutostash {
+		checkStaged("sync")
+		checkUnstaged("sync")
+	} else if HasStagedChanges() || HasUnstagedChanges() {
+		verbosef("stashing uncommitted changes")
+		run("git", "stash", "push", "-q", "-u", "-m", syncStashMessage)
+		syncStashed = true
+	}
 
 	// Pull remote changes into local branch.
 	// We do this in one command so that people following along with 'git sync -v'
@@ -27,7 +109,60 @@ func cmdSync(args []string) {
 	// We want to pull in the remote changes from the upstream branch
 	// and rebase the current pending commit (if any) on top of them.
 	// If there is no pending commit, the pull will do a fast-forward merge.
-	run("git", "pull", "-q", "-r", "origin", strings.TrimPrefix(b.OriginBranch(), "origin/"))
+	// The -merge and -i flags select a merge or an interactive rebase instead.
+	// With -onto, fetch instead, so that an onto ref naming an origin branch
+	// is up to date, and then rebase the pending changes onto that ref.
+	// Either way, the report counts the commits since the old branchpoint.
+	old := b.Branchpoint()
+	if syncOnto != "" {
+		runRemote("git", "fetch", "-q")
+		if _, err := cmdOutputErr("git", "rev-parse", "--verify", "-q", syncOnto+"^{commit}"); err != nil {
+			dief("cannot sync: %s is not a commit", syncOnto)
+		}
+		rebase := []string{"rebase", "-q"}
+		if syncInteractive {
+			rebase = append(rebase, "-i", "--autosquash")
+		}
+		syncRun("git", append(rebase, "--onto", syncOnto, old)...)
+		reportUpstream(syncOnto, syncOnto, old)
+	} else if parent := b.Parent(); parent != "" {
+		// A stacked branch follows its parent branch, not the origin branch,
+		// until the parent's changes are submitted.
+		// Fetching finds out about those and keeps the reports about
+		// the origin branch current.
+		if syncMerge {
+			dief("cannot sync: -merge does not work on a branch stacked on %s", parent)
+		}
+		runRemote("git", "fetch", "-q")
+		rebase := []string{"rebase", "-q"}
+		if syncInteractive {
+			rebase = append(rebase, "-i", "--autosquash")
+		}
+		name, onto := parent, "refs/heads/"+parent
+		if b.parentSubmitted() {
+			name, onto = b.OriginBranch(), b.OriginBranch()
+			unstack(b)
+		}
+		syncRun("git", append(rebase, "--onto", onto, old)...)
+		reportUpstream(name, onto, old)
+	} else {
+		pull := []string{"pull", "-q", "-r"}
+		switch {
+		case syncMerge:
+			pull = []string{"pull", "-q", "--no-rebase", "--no-edit"}
+		case syncInteractive:
+			pull = []string{"-c", "rebase.autoSquash=true", "pull", "-q", "--rebase=interactive"}
+		}
+		origin := b.OriginBranch()
+		remote, branch := splitOriginBranch(origin)
+		if syncInteractive {
+			// The rebase waits for the user in an editor.
+			syncRun("git", append(pull, remote, branch)...)
+		} else {
+			syncRunRemote("git", append(pull, remote, branch)...)
+		}
+		reportUpstream(origin, origin, old)
+	}
 
 	// If the change commit has been submitted,
 	// roll back change leaving any changes unstaged.
@@ -36,6 +171,252 @@ func cmdSync(args []string) {
 	if len(b.Pending()) == 1 && b.Submitted(id) {
 		run("git", "reset", b.Branchpoint())
 	}
+
+	if syncStashed {
+		popSyncStash()
+	}
+}
+
+// reportUpstream reports how many commits the sync brought in from onto,
+// the ref that the branch was rebased onto or merged, shown as name,
+// counting from old, the branchpoint before the sync.
+// With -stat, it lists the commits too.
+func reportUpstream(name, onto, old string) {
+	log := nonBlankLines(cmdOutput("git", "log", "--oneline", old+".."+onto, "--"))
+	verb := "rebased onto"
+	if syncMerge {
+		verb = "merged"
+	}
+	printf("%s %s (%d new upstream commit%s)", verb, name, len(log), suffix(len(log), "s"))
+	if syncStat {
+		for _, line := range log {
+			fmt.Fprintf(stdout(), "\t%s\n", line)
+		}
+	}
+}
+
+// syncFetch fetches changes from the remote repository and reports
+// how the current branch compares with its upstream branch,
+// without changing the branch.
+func syncFetch() {
+	b := CurrentBranch()
+	b.checkAttached("sync -fetch-only")
+	runRemote("git", "fetch", "-q")
+	b = CurrentBranch() // discard any cached information
+	b.loadPending()
+	origin := b.OriginBranch()
+	if b.commitsBehind == 0 {
+		fmt.Fprintf(stdout(), "%s: %d commit%s ahead of %s; up to date.\n", b.Name, b.commitsAhead, suffix(b.commitsAhead, "s"), origin)
+		return
+	}
+	fmt.Fprintf(stdout(), "%s: %d commit%s ahead of and %d commit%s behind %s; run 'git sync' to update.\n",
+		b.Name, b.commitsAhead, suffix(b.commitsAhead, "s"), b.commitsBehind, suffix(b.commitsBehind, "s"), origin)
+}
+
+// syncAllBranches rebases every local work branch that is behind
+// its upstream branch and then returns to the current branch.
+// It stops at the first branch whose rebase conflicts.
+func syncAllBranches() {
+	current := CurrentBranch()
+	current.checkAttached("sync -all")
+	// Visiting the other branches would carry uncommitted work along.
+	checkStaged("sync -all")
+	checkUnstaged("sync -all")
+
+	runRemote("git", "fetch", "-q")
+	syncBranches("sync -all", current, stackOrder(LocalBranches()))
+}
+
+// syncChain rebases the current branch and the branches stacked on it,
+// directly or through other stacked branches, keeping each one stacked
+// on its parent, and then returns to the current branch.
+// It stops at the first branch whose rebase conflicts.
+func syncChain() {
+	current := CurrentBranch()
+	current.checkAttached("sync -preserve-chain")
+	checkStaged("sync -preserve-chain")
+	checkUnstaged("sync -preserve-chain")
+
+	runRemote("git", "fetch", "-q")
+	inChain := map[string]bool{current.Name: true}
+	var chain []*Branch
+	for _, b := range stackOrder(LocalBranches()) {
+		if b.Name == current.Name || inChain[b.Parent()] {
+			inChain[b.Name] = true
+			chain = append(chain, b)
+		}
+	}
+	syncBranches("sync -preserve-chain", current, chain)
+}
+
+// syncBranches rebases each of the given local work branches that is
+// behind its upstream branch, or its parent branch for a stacked branch,
+// and then returns to the current branch. The branches must be in
+// stackOrder, so that each parent branch is synced before the branches
+// stacked on it. A stacked branch whose parent's changes have all been
+// submitted is rebased onto its upstream branch and no longer stacked.
+// If a rebase conflicts, syncBranches dies, explaining how to continue
+// by running cmd again.
+func syncBranches(cmd string, current *Branch, branches []*Branch) {
+	for _, b := range branches {
+		if !b.IsLocalOnly() {
+			continue
+		}
+		onto := b.OriginBranch()
+		rebase := []string{"rebase", "-q", onto}
+		if parent := b.Parent(); parent != "" {
+			onto = parent
+			rebase = []string{"rebase", "-q", "--onto", "refs/heads/" + parent, b.parentForkPoint()}
+			if b.parentSubmitted() {
+				onto = b.OriginBranch()
+				rebase = []string{"rebase", "-q", "--onto", onto, b.parentForkPoint()}
+				unstack(b)
+			} else if trim(cmdOutput("git", "rev-list", "--count", b.FullName()+"..refs/heads/"+parent)) == "0" {
+				printf("%s: up to date", b.Name)
+				continue
+			}
+		} else if b.loadPending(); b.commitsBehind == 0 {
+			printf("%s: up to date", b.Name)
+			continue
+		}
+		run("git", "checkout", "-q", b.Name)
+		if err := runErr("git", rebase...); err != nil {
+			if !rebaseInProgress() {
+				dieRun(err, "git", rebase...)
+			}
+			dief("cannot sync %s: conflicts with upstream changes\n"+
+				"\tresolve the conflicts and run 'git add' to mark them resolved, then\n"+
+				"\trun 'git-codereview sync -continue' to finish this branch, and\n"+
+				"\trun 'git-codereview %s' again to sync the remaining branches;\n"+
+				"\tor run 'git-codereview sync -abort' to give up on this branch\n"+
+				"\t(you were on branch %s)", b.Name, cmd, current.Name)
+		}
+		printf("%s: rebased onto %s", b.Name, onto)
+	}
+	if CurrentBranch().Name != current.Name {
+		run("git", "checkout", "-q", current.Name)
+	}
+}
+
+// unstack records that b is no longer stacked on its parent branch,
+// whose changes have been submitted.
+func unstack(b *Branch) {
+	printf("%s: the changes in parent branch %s have been submitted; %s is no longer stacked on it", b.Name, b.Parent(), b.Name)
+	run("git", "config", "--unset", "branch."+b.Name+".codereviewparent")
+}
+
+// stackOrder returns branches reordered so that each branch stacked on
+// another comes after the branch it is stacked on, keeping the order of
+// the branches otherwise.
+func stackOrder(branches []*Branch) []*Branch {
+	var ordered []*Branch
+	done := make(map[string]bool)
+	for len(ordered) < len(branches) {
+		progress := false
+		for _, b := range branches {
+			if done[b.Name] || b.Parent() != "" && !done[b.Parent()] {
+				continue
+			}
+			ordered = append(ordered, b)
+			done[b.Name] = true
+			progress = true
+		}
+		if !progress {
+			// A cycle of parents; keep the remaining branches in order.
+			for _, b := range branches {
+				if !done[b.Name] {
+					ordered = append(ordered, b)
+					done[b.Name] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// popSyncStash restores the uncommitted changes stashed by sync,
+// if the most recent stash entry is one that sync created.
+func popSyncStash() {
+	if subject, _ := cmdOutputErr("git", "stash", "list", "-n", "1", "--format=%gs"); !strings.HasSuffix(trim(subject), syncStashMessage) {
+		return
+	}
+	verbosef("restoring uncommitted changes")
+	if err := runErr("git", "stash", "pop", "-q", "--index"); err != nil {
+		dief("cannot restore uncommitted changes: %v\n"+
+			"\tthe changes are still saved in the stash (see 'git stash list');\n"+
+			"\tresolve the conflicts in the working tree, then\n"+
+			"\trun 'git stash drop' to discard the saved copy", err)
+	}
+}
+
+// syncRun is like run, but if the command leaves a rebase stopped
+// on conflicts, syncRun explains how to resolve them before dying.
+func syncRun(command string, args ...string) {
+	syncCheck(runErr(command, args...), command, args...)
+}
+
+// syncRunRemote is like syncRun, but with the timeout of runRemote,
+// for a git pull that does not wait for the user.
+func syncRunRemote(command string, args ...string) {
+	_, err := runRemoteCaptureErr(command, args...)
+	syncCheck(err, command, args...)
+}
+
+// syncCheck dies if err, the result of running the command, is not nil,
+// explaining how to resolve a rebase or merge left stopped on conflicts.
+func syncCheck(err error, command string, args ...string) {
+	if err == nil {
+		return
+	}
+	conflicts := func() string {
+		files := nonBlankLines(cmdOutput("git", "diff", "--name-only", "--diff-filter=U"))
+		return "cannot sync: conflicts with upstream changes in:\n\t\t" + strings.Join(files, "\n\t\t") + "\n" +
+			"\tresolve the conflicts and run 'git add' to mark them resolved, then\n"
+	}
+	if rebaseInProgress() {
+		var stashed string
+		if syncStashed {
+			stashed = "\n\tuncommitted changes were stashed; sync -continue or -abort restores them"
+		}
+		dief("%s"+
+			"\trun 'git-codereview sync -continue' to continue the sync, or\n"+
+			"\trun 'git-codereview sync -abort' to give up and restore the branch%s", conflicts(), stashed)
+	}
+	if pathExists(gitPath("MERGE_HEAD")) {
+		var stashed string
+		if syncStashed {
+			stashed = "\n\tuncommitted changes were stashed; run 'git stash pop' afterward to restore them"
+		}
+		// Keep the exit status of the failed git pull.
+		exitf(exitGitFailed, "%s"+
+			"\trun 'git commit' to finish the merge, or\n"+
+			"\trun 'git merge --abort' to give up and restore the branch%s", conflicts(), stashed)
+	}
+	if syncStashed {
+		printf("uncommitted changes are saved in the stash; run 'git stash pop' to restore them.")
+	}
+	dieRun(err, command, args...)
+}
+
+// rebaseInProgress reports whether a git rebase is stopped in the current repo.
+func rebaseInProgress() bool {
+	for _, dir := range []string{"rebase-merge", "rebase-apply"} {
+		if _, err := os.Stat(gitPath(dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// countTrue returns the number of its arguments that are true.
+func countTrue(list ...bool) int {
+	n := 0
+	for _, b := range list {
+		if b {
+			n++
+		}
+	}
+	return n
 }
 
 func checkStaged(cmd string) {
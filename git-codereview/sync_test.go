@@ -4,7 +4,12 @@
 
 package main
 
-import "testing"
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
 
 func TestSync(t *testing.T) {
 	gt := newGitTest(t)
@@ -16,14 +21,14 @@ func TestSync(t *testing.T) {
 	write(t, gt.client+"/file1", "")
 	trun(t, gt.client, "git", "add", "file1")
 	write(t, gt.client+"/file1", "actual content")
-	testMainDied(t, "sync")
+	testMainDied(t, "sync", "-no-autostash")
 	testPrintedStderr(t, "cannot sync: unstaged changes exist",
 		"git status", "git stash", "git add", "git-codereview change")
 	testNoStdout(t)
 
 	// check for error with staged changes
 	trun(t, gt.client, "git", "add", "file1")
-	testMainDied(t, "sync")
+	testMainDied(t, "sync", "-no-autostash")
 	testPrintedStderr(t, "cannot sync: staged changes exist",
 		"git status", "!git stash", "!git add", "git-codereview change")
 	testNoStdout(t)
@@ -32,7 +37,7 @@ func TestSync(t *testing.T) {
 	trun(t, gt.client, "git", "stash")
 	testMain(t, "sync")
 	testNoStdout(t)
-	testNoStderr(t)
+	testPrintedStderr(t, "rebased onto origin/master (0 new upstream commits)")
 
 	// make server 1 step ahead of client
 	write(t, gt.server+"/file", "new content")
@@ -42,7 +47,137 @@ func TestSync(t *testing.T) {
 	// check for success
 	testMain(t, "sync")
 	testNoStdout(t)
-	testNoStderr(t)
+	testPrintedStderr(t, "rebased onto origin/master (1 new upstream commit)")
+
+	// -stat lists the new upstream commits
+	write(t, gt.server+"/file", "newer content")
+	trun(t, gt.server, "git", "commit", "-q", "-a", "-m", "newer msg")
+	write(t, gt.server+"/file", "newest content")
+	trun(t, gt.server, "git", "commit", "-q", "-a", "-m", "newest msg")
+	testMain(t, "sync", "-stat")
+	testPrintedStderr(t, "rebased onto origin/master (2 new upstream commits)")
+	testPrintedStdout(t, "newer msg\n", "newest msg\n")
+
+	// nothing new, nothing to list
+	testMain(t, "sync", "-stat")
+	testNoStdout(t)
+	testPrintedStderr(t, "rebased onto origin/master (0 new upstream commits)")
+}
+
+func TestSyncAutostash(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+
+	// make server 1 step ahead of client
+	write(t, gt.server+"/otherfile", "new content")
+	trun(t, gt.server, "git", "add", "otherfile")
+	trun(t, gt.server, "git", "commit", "-m", "msg")
+
+	write(t, gt.client+"/staged", "staged")
+	trun(t, gt.client, "git", "add", "staged")
+	write(t, gt.client+"/file", "unstaged")
+	write(t, gt.client+"/untracked", "untracked")
+
+	testMain(t, "sync")
+	testRan(t,
+		"git stash push -q -u -m "+syncStashMessage,
+		"git pull -q -r origin master",
+		"git stash pop -q --index")
+	if got := string(read(t, gt.client+"/otherfile")); got != "new content" {
+		t.Errorf("otherfile = %q after sync, want %q", got, "new content")
+	}
+	staged, unstaged, untracked := LocalChanges()
+	if strings.Join(staged, ",") != "staged" || strings.Join(unstaged, ",") != "file" || strings.Join(untracked, ",") != "untracked" {
+		t.Errorf("after sync: staged %v, unstaged %v, untracked %v; want [staged], [file], [untracked]", staged, unstaged, untracked)
+	}
+
+	t.Logf("conflict restoring changes")
+	trun(t, gt.client, "git", "reset", "-q", "--hard")
+	trun(t, gt.client, "git", "clean", "-q", "-f")
+	write(t, gt.server+"/otherfile", "server content")
+	trun(t, gt.server, "git", "commit", "-a", "-m", "msg2")
+	write(t, gt.client+"/otherfile", "local content")
+	testMainDied(t, "sync")
+	testPrintedStderr(t, "cannot restore uncommitted changes", "git stash drop")
+	if out := trun(t, gt.client, "git", "stash", "list"); !strings.Contains(out, syncStashMessage) {
+		t.Errorf("stash dropped after failed pop:\n%s", out)
+	}
+}
+
+func TestSyncOnto(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// base branch with one change, work branch with another on master
+	testMain(t, "change", "base")
+	doWork(t, 1, gt.client, "basefile", "11111111")
+	base := CurrentBranch().Pending()[0].Hash
+	trun(t, gt.client, "git", "checkout", "-q", "master")
+	testMain(t, "change", "top")
+	doWork(t, 2, gt.client, "topfile", "22222222")
+	bp := CurrentBranch().Branchpoint()
+
+	testMainDied(t, "sync", "-onto", "nosuchref")
+	testPrintedStderr(t, "cannot sync: nosuchref is not a commit")
+
+	testMain(t, "sync", "-stat", "-onto", "refs/heads/base")
+	testRan(t,
+		"git fetch -q",
+		"git rebase -q --onto refs/heads/base "+bp)
+	testPrintedStderr(t, "rebased onto refs/heads/base (1 new upstream commit)")
+	testPrintedStdout(t, "msg\n")
+	b := CurrentBranch()
+	work := b.Pending()
+	if len(work) != 2 || work[1].Hash != base || b.OriginBranch() != "origin/master" {
+		t.Fatalf("after sync -onto, pending %d on %s; want 2 on origin/master with base change", len(work), b.OriginBranch())
+	}
+}
+
+func TestSyncForkUpstream(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// In a fork, master tracks a remote other than origin.
+	trun(t, gt.client, "git", "remote", "add", "upstream", gt.server)
+	trun(t, gt.client, "git", "fetch", "-q", "upstream")
+	trun(t, gt.client, "git", "branch", "--set-upstream-to", "upstream/master", "master")
+	if b := CurrentBranch(); b.OriginBranch() != "upstream/master" || b.IsLocalOnly() {
+		t.Fatalf("master tracks %s, local only %v; want upstream/master, false", b.OriginBranch(), b.IsLocalOnly())
+	}
+
+	// A work branch that tracks nothing follows master's upstream branch.
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "work", "--no-track")
+	doWork(t, 1, gt.client, "workfile", "11111111")
+	if b := CurrentBranch(); b.OriginBranch() != "upstream/master" || !b.IsLocalOnly() {
+		t.Fatalf("work tracks %s, local only %v; want upstream/master, true", b.OriginBranch(), b.IsLocalOnly())
+	}
+
+	write(t, gt.server+"/file", "new content")
+	trun(t, gt.server, "git", "add", "file")
+	trun(t, gt.server, "git", "commit", "-m", "msg")
+
+	testMain(t, "sync")
+	testRan(t, "git pull -q -r upstream master")
+	if b := CurrentBranch(); len(b.Pending()) != 1 || b.commitsBehind != 0 {
+		t.Fatalf("after sync, %d pending and %d behind; want 1 and 0", len(b.Pending()), b.commitsBehind)
+	}
+}
+
+func TestSyncTimeout(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+
+	// The pull runs with the time limit of codereview.timeout,
+	// unless an interactive rebase may wait for the user.
+	trun(t, gt.client, "git", "config", "codereview.timeout", "-1")
+	testMainDied(t, "sync")
+	testPrintedStderr(t, `invalid codereview.timeout setting "-1"`)
+	trun(t, gt.client, "git", "config", "codereview.timeout", "0")
+	testMain(t, "sync")
+	testRan(t, "git pull -q -r origin master")
 }
 
 func TestSyncRebase(t *testing.T) {
@@ -63,7 +198,7 @@ func TestSyncRebase(t *testing.T) {
 	// check for success for sync no-op
 	testMain(t, "sync")
 	testNoStdout(t)
-	testNoStderr(t)
+	testPrintedStderr(t, "rebased onto origin/master (0 new upstream commits)")
 
 	b = CurrentBranch()
 	if len(b.Pending()) != 3 {
@@ -81,7 +216,7 @@ func TestSyncRebase(t *testing.T) {
 
 	testMain(t, "sync")
 	testNoStdout(t)
-	testNoStderr(t)
+	testPrintedStderr(t, "rebased onto origin/master (3 new upstream commits)")
 
 	// there should be one left, and it should be a different hash
 	b = CurrentBranch()
@@ -97,7 +232,7 @@ func TestSyncRebase(t *testing.T) {
 
 	testMain(t, "sync")
 	testNoStdout(t)
-	testNoStderr(t)
+	testPrintedStderr(t, "rebased onto origin/master (1 new upstream commit)")
 
 	// there should be none left
 	b = CurrentBranch()
@@ -105,3 +240,284 @@ func TestSyncRebase(t *testing.T) {
 		t.Fatalf("have %d pending CLs after final sync, want 0", len(b.Pending()))
 	}
 }
+
+func TestSyncMerge(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.serverWorkUnrelated(t)
+
+	testMain(t, "sync", "-merge")
+	testRan(t, "git pull -q --no-rebase --no-edit origin master")
+
+	parents := strings.Fields(trun(t, gt.client, "git", "log", "-n", "1", "--format=%P"))
+	if len(parents) != 2 {
+		t.Fatalf("HEAD has %d parents after sync -merge, want 2", len(parents))
+	}
+}
+
+func TestSyncInteractive(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	gt.serverWorkUnrelated(t)
+
+	// Accept the rebase script unchanged.
+	os.Setenv("GIT_SEQUENCE_EDITOR", "true")
+	defer os.Unsetenv("GIT_SEQUENCE_EDITOR")
+
+	testMain(t, "sync", "-i")
+	testRan(t, "git -c rebase.autoSquash=true pull -q --rebase=interactive origin master")
+
+	b := CurrentBranch()
+	if len(b.Pending()) != 1 || b.commitsBehind != 0 {
+		t.Fatalf("after sync -i have %d pending, %d behind; want 1, 0", len(b.Pending()), b.commitsBehind)
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	testMainDied(t, "sync", "-continue")
+	testPrintedStderr(t, "cannot sync: no rebase in progress")
+
+	gt.work(t)
+	write(t, gt.server+"/file", "conflicting content")
+	trun(t, gt.server, "git", "commit", "-a", "-m", "conflict")
+
+	write(t, gt.client+"/extra", "keep me")
+	trun(t, gt.client, "git", "add", "extra")
+	testMainDied(t, "sync")
+	testPrintedStderr(t, "cannot sync: conflicts with upstream changes in:\n\t\tfile\n",
+		"git-codereview sync -continue", "git-codereview sync -abort", "uncommitted changes were stashed")
+
+	testMain(t, "sync", "-abort")
+	testRan(t, "git rebase --abort", "git stash pop -q --index")
+	if got := string(read(t, gt.client+"/extra")); got != "keep me" || !HasStagedChanges() {
+		t.Fatalf("extra = %q (staged %v) after sync -abort, want %q (staged)", got, HasStagedChanges(), "keep me")
+	}
+	if rebaseInProgress() {
+		t.Fatalf("rebase still in progress after sync -abort")
+	}
+	if got := string(read(t, gt.client+"/file")); got != "new content 1" {
+		t.Fatalf("file = %q after sync -abort, want %q", got, "new content 1")
+	}
+}
+
+func TestSyncMergeConflict(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	write(t, gt.server+"/file", "conflicting content")
+	trun(t, gt.server, "git", "commit", "-a", "-m", "conflict")
+
+	testMainDied(t, "sync", "-merge")
+	testPrintedStderr(t, "cannot sync: conflicts with upstream changes in:\n\t\tfile\n",
+		"run 'git commit' to finish the merge", "run 'git merge --abort'", "!sync -continue")
+
+	trun(t, gt.client, "git", "merge", "--abort")
+	if got := string(read(t, gt.client+"/file")); got != "new content 1" {
+		t.Fatalf("file = %q after git merge --abort, want %q", got, "new content 1")
+	}
+}
+
+func TestSyncFetchOnly(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+	gt.work(t)
+	head := trim(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+
+	testMain(t, "sync", "-fetch-only")
+	testPrintedStdout(t, "work: 1 commit ahead of origin/master; up to date.")
+
+	gt.serverWorkUnrelated(t)
+	gt.serverWorkUnrelated(t)
+	testMain(t, "sync", "-fetch-only")
+	testPrintedStdout(t, "work: 1 commit ahead of and 2 commits behind origin/master; run 'git sync' to update.")
+	testRan(t, "git fetch -q")
+	if hash := trim(trun(t, gt.client, "git", "rev-parse", "HEAD")); hash != head {
+		t.Fatalf("sync -fetch-only moved HEAD from %s to %s", head, hash)
+	}
+}
+
+func TestSyncAll(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "work2", "-t", "origin/master")
+	write(t, gt.client+"/file2", "work2 content")
+	trun(t, gt.client, "git", "add", "file2")
+	trun(t, gt.client, "git", "commit", "-q", "-m", "work2")
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+
+	write(t, gt.server+"/other", "new upstream content")
+	trun(t, gt.server, "git", "add", "other")
+	trun(t, gt.server, "git", "commit", "-q", "-m", "upstream")
+
+	testMain(t, "sync", "-all")
+	testRan(t, "git fetch -q",
+		"git checkout -q work", "git rebase -q origin/master",
+		"git checkout -q work2", "git rebase -q origin/master",
+		"git checkout -q work")
+	testPrintedStderr(t, "work: rebased onto origin/master", "work2: rebased onto origin/master")
+	for _, name := range []string{"work", "work2"} {
+		if b := (&Branch{Name: name}); b.Branchpoint() != trim(trun(t, gt.client, "git", "rev-parse", "origin/master")) {
+			t.Errorf("%s not rebased onto origin/master", name)
+		}
+	}
+
+	// Now everything is up to date.
+	testMain(t, "sync", "-all")
+	testRan(t, "git fetch -q")
+	testPrintedStderr(t, "work: up to date", "work2: up to date")
+
+	// A conflict stops the sync on the conflicting branch.
+	write(t, gt.server+"/file2", "conflicting content")
+	trun(t, gt.server, "git", "add", "file2")
+	trun(t, gt.server, "git", "commit", "-q", "-m", "conflict")
+	testMainDied(t, "sync", "-all")
+	testPrintedStderr(t, "cannot sync work2: conflicts with upstream changes",
+		"sync -all' again", "you were on branch work")
+	if !rebaseInProgress() {
+		t.Fatalf("no rebase in progress after conflicting sync -all")
+	}
+}
+
+func TestSyncStacked(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Branch a-child (listed first) stacked on work.
+	gt.work(t)
+	testMain(t, "change", "-on", "work", "a-child")
+	doWork(t, 2, gt.client, "childfile", "22222222")
+	child := CurrentBranch().Pending()[0].Hash
+	oldWork := CurrentBranch().Branchpoint()
+
+	// Amend the change in work.
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	write(t, gt.client+"/file", "amended content")
+	trun(t, gt.client, "git", "commit", "-q", "-a", "--amend", "--no-edit")
+	newWork := trim(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+
+	trun(t, gt.client, "git", "checkout", "-q", "a-child")
+	testMainDied(t, "sync", "-merge")
+	testPrintedStderr(t, "cannot sync: -merge does not work on a branch stacked on work")
+
+	// The stacked branch still has only its own change pending.
+	if b := CurrentBranch(); len(b.Pending()) != 1 || b.Branchpoint() != oldWork {
+		t.Fatalf("before sync, a-child has %d pending from %s; want 1 from %s", len(b.Pending()), b.Branchpoint(), oldWork)
+	}
+	testMain(t, "sync")
+	testRan(t,
+		"git fetch -q",
+		"git rebase -q --onto refs/heads/work "+oldWork)
+	testPrintedStderr(t, "rebased onto work (1 new upstream commit)")
+	b := CurrentBranch()
+	if work := b.Pending(); len(work) != 1 || work[0].Parent != newWork || work[0].Hash == child {
+		t.Fatalf("after sync, a-child has %d pending; want 1 rebased onto %s", len(work), newWork)
+	}
+
+	// sync -all syncs work before the branch stacked on it.
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	write(t, gt.server+"/other", "new upstream content")
+	trun(t, gt.server, "git", "add", "other")
+	trun(t, gt.server, "git", "commit", "-q", "-m", "upstream")
+	testMain(t, "sync", "-all")
+	testRan(t, "git fetch -q",
+		"git checkout -q work", "git rebase -q origin/master",
+		"git checkout -q a-child", "git rebase -q --onto refs/heads/work "+newWork,
+		"git checkout -q work")
+	testPrintedStderr(t, "work: rebased onto origin/master", "a-child: rebased onto work")
+	parent := trim(trun(t, gt.client, "git", "rev-parse", "a-child^"))
+	if work := trim(trun(t, gt.client, "git", "rev-parse", "refs/heads/work")); parent != work {
+		t.Fatalf("after sync -all, a-child is on %s, want work at %s", parent, work)
+	}
+
+	testMain(t, "sync", "-all")
+	testPrintedStderr(t, "work: up to date", "a-child: up to date")
+}
+
+func TestSyncPreserveChain(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Chain work <- a-child <- b-grandchild, and unrelated work branch other.
+	gt.work(t)
+	testMain(t, "change", "-on", "work", "a-child")
+	doWork(t, 2, gt.client, "childfile", "22222222")
+	oldWork := CurrentBranch().Branchpoint()
+	oldChild := trim(trun(t, gt.client, "git", "rev-parse", "HEAD"))
+	testMain(t, "change", "-on", "a-child", "b-grandchild")
+	doWork(t, 3, gt.client, "grandchildfile", "33333333")
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "other", "-t", "origin/master")
+	doWork(t, 4, gt.client, "otherfile", "44444444")
+
+	trun(t, gt.client, "git", "checkout", "-q", "work")
+	write(t, gt.client+"/file", "amended content")
+	trun(t, gt.client, "git", "commit", "-q", "-a", "--amend", "--no-edit")
+
+	testMain(t, "sync", "-preserve-chain")
+	testRan(t, "git fetch -q",
+		"git checkout -q a-child", "git rebase -q --onto refs/heads/work "+oldWork,
+		"git checkout -q b-grandchild", "git rebase -q --onto refs/heads/a-child "+oldChild,
+		"git checkout -q work")
+	testPrintedStderr(t, "work: up to date", "a-child: rebased onto work", "b-grandchild: rebased onto a-child", "!other")
+	for _, name := range []string{"a-child", "b-grandchild"} {
+		b := &Branch{Name: name}
+		parent := trim(trun(t, gt.client, "git", "rev-parse", "refs/heads/"+b.Parent()))
+		if work := b.Pending(); len(work) != 1 || work[0].Parent != parent {
+			t.Errorf("after sync -preserve-chain, %s has %d pending; want 1 on top of %s", name, len(work), b.Parent())
+		}
+	}
+}
+
+func TestSyncParentSubmitted(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	gt.work(t)
+	testMain(t, "change", "-on", "work", "a-child")
+	doWork(t, 2, gt.client, "childfile", "22222222")
+	fork := CurrentBranch().Branchpoint()
+
+	// The change in work is submitted.
+	gt.serverWork(t)
+
+	testMain(t, "sync")
+	testRan(t, "git fetch -q",
+		"git config --unset branch.a-child.codereviewparent",
+		"git rebase -q --onto origin/master "+fork)
+	// The submitted commit is new unless it got the same hash as work's.
+	n := len(nonBlankLines(trun(t, gt.client, "git", "log", "--oneline", fork+"..origin/master")))
+	testPrintedStderr(t, "a-child: the changes in parent branch work have been submitted; a-child is no longer stacked on it",
+		fmt.Sprintf("rebased onto origin/master (%d new upstream commit%s)", n, suffix(n, "s")))
+	b := CurrentBranch()
+	origin := trim(trun(t, gt.client, "git", "rev-parse", "origin/master"))
+	if work := b.Pending(); b.Parent() != "" || len(work) != 1 || work[0].Parent != origin {
+		t.Fatalf("after sync, a-child stacked on %q with %d pending; want 1 on origin/master", b.Parent(), len(work))
+	}
+
+	// sync -all unstacks too, after syncing the parent.
+	trun(t, gt.client, "git", "checkout", "-q", "-b", "b-child")
+	trun(t, gt.client, "git", "config", "branch.b-child.codereviewparent", "a-child")
+	doWork(t, 3, gt.client, "childfile2", "33333333")
+	trun(t, gt.client, "git", "checkout", "-q", "a-child")
+	// a-child's change is submitted as is. Give the server commit the
+	// same dates, so that it is the very same commit and a-child is up to date.
+	dates := lines(trun(t, gt.client, "git", "log", "-n", "1", "--date=raw", "--format=%ad%n%cd"))
+	os.Setenv("GIT_AUTHOR_DATE", dates[0])
+	os.Setenv("GIT_COMMITTER_DATE", dates[1])
+	doWork(t, 2, gt.server, "childfile", "22222222")
+	os.Unsetenv("GIT_AUTHOR_DATE")
+	os.Unsetenv("GIT_COMMITTER_DATE")
+	testMain(t, "sync", "-all")
+	testPrintedStderr(t, "a-child: up to date",
+		"b-child: the changes in parent branch a-child have been submitted",
+		"b-child: rebased onto origin/master")
+}
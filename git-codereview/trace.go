@@ -0,0 +1,60 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// A traceEntry records one command run with the -trace flag.
+type traceEntry struct {
+	cmd    string        // command line
+	d      time.Duration // wall-clock run time
+	status string        // "ok", "exit N", or an error
+}
+
+var (
+	traceMu  sync.Mutex // guards traceLog, for commands run in parallel
+	traceLog []traceEntry
+)
+
+// traceCommand records, if -trace is set, that the command line
+// started at start and finished with err.
+func traceCommand(start time.Time, err error, command string, args []string) {
+	if !*trace {
+		return
+	}
+	status := "ok"
+	if e, ok := err.(*exec.ExitError); ok {
+		status = fmt.Sprintf("exit %d", e.ExitCode())
+	} else if err != nil {
+		status = err.Error()
+	}
+	traceMu.Lock()
+	traceLog = append(traceLog, traceEntry{commandString(command, args), time.Since(start), status})
+	traceMu.Unlock()
+}
+
+// printTrace prints the commands recorded by traceCommand,
+// with their run times and exit statuses, and their total run time.
+func printTrace() {
+	if !*trace || len(traceLog) == 0 {
+		return
+	}
+	var total time.Duration
+	w := tabwriter.NewWriter(stderr(), 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "time\tstatus\t\tcommand\n")
+	for _, e := range traceLog {
+		fmt.Fprintf(w, "%v\t%s\t\t%s\n", e.d.Round(100*time.Microsecond), e.status, e.cmd)
+		total += e.d
+	}
+	w.Flush()
+	fmt.Fprintf(stderr(), "%d commands, %v total\n", len(traceLog), total.Round(100*time.Microsecond))
+	traceLog = nil
+}
@@ -64,6 +64,8 @@ func (gt *gitTest) done() {
 	resetReadOnlyFlagAll(gt.tmpdir)
 	os.RemoveAll(gt.tmpdir)
 	cachedConfig = nil
+	cachedGitConfig = nil
+	gitConfigOnce = sync.Once{}
 }
 
 // doWork simulates commit 'n' touching 'file' in 'dir'
@@ -112,7 +114,7 @@ func newGitTest(t *testing.T) (gt *gitTest) {
 	// but make sure the tests don't fail.
 	_, err := exec.LookPath("git")
 	if err != nil {
-		t.Skip("cannot find git in path: %v", err)
+		t.Skipf("cannot find git in path: %v", err)
 	}
 
 	tmpdir, err := ioutil.TempDir("", "git-codereview-test")
@@ -152,6 +154,9 @@ func newGitTest(t *testing.T) (gt *gitTest) {
 	trun(t, client, "git", "clone", server, ".")
 	trun(t, client, "git", "config", "user.name", "gopher")
 	trun(t, client, "git", "config", "user.email", "gopher@example.com")
+	// Newer versions of git print hints when rebase skips cherry-picked
+	// commits, which confuses the tests that expect no output from sync.
+	trun(t, client, "git", "config", "advice.skippedCherryPicks", "false")
 
 	// write stub hooks to keep installHook from installing its own.
 	// If it installs its own, git will look for git-codereview on the current path
@@ -277,6 +282,8 @@ func testMain(t *testing.T, args ...string) {
 	*noRun = false
 	*verbose = 0
 	cachedConfig = nil
+	cachedGitConfig = nil
+	gitConfigOnce = sync.Once{}
 
 	t.Logf("git-codereview %s", strings.Join(args, " "))
 
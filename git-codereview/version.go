@@ -0,0 +1,64 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, normally set at link time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)"
+//
+// If left unset, versionString fills them in from the information
+// that the go command records in the binary, when available.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// versionString returns a description of this build of git-codereview,
+// such as "v1.2.0 (commit 0123456789ab, built 2015-04-01)".
+func versionString() string {
+	v, c, d := version, commit, buildDate
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && bi.Main.Version != "" {
+			v = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	if v == "" {
+		v = "(devel)"
+	}
+	if len(c) > 12 {
+		c = c[:12]
+	}
+	var extra []string
+	if c != "" {
+		extra = append(extra, "commit "+c)
+	}
+	if d != "" {
+		extra = append(extra, "built "+d)
+	}
+	if len(extra) > 0 {
+		v += " (" + strings.Join(extra, ", ") + ")"
+	}
+	return v
+}
+
+func cmdVersion(args []string) {
+	expectZeroArgs(args, "version")
+	fmt.Fprintf(stdout(), "git-codereview version %s\n", versionString())
+}
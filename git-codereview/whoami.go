@@ -0,0 +1,75 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// A gerritAccount is the account information returned by
+// Gerrit's /accounts/self endpoint.
+type gerritAccount struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+func cmdWhoami(args []string) {
+	expectZeroArgs(args, "whoami")
+
+	// Gather everything before failing, so that one command
+	// shows all the problems at once.
+	ok := true
+	show := func(key, value string) {
+		fmt.Fprintf(stdout(), "%-11s %s\n", key+":", value)
+	}
+	for _, key := range []string{"user.name", "user.email"} {
+		value, _ := trimErr(cmdOutputErr("git", "config", key))
+		if value == "" {
+			value, ok = "(not set; run 'git config --global "+key+" ...')", false
+		}
+		show(key, value)
+	}
+
+	remote := gitConfigDefault("remote")
+	url, err := trimErr(cmdOutputErr("git", "remote", "get-url", "--push", remote))
+	if err != nil {
+		url, ok = "(no such remote)", false
+	}
+	show("remote", remote+" "+url)
+
+	if !haveGerrit() {
+		show("gerrit", "(none; this repository is not reviewed on Gerrit)")
+		if !ok {
+			die()
+		}
+		return
+	}
+	loadGerritOrigin()
+	show("gerrit", auth.url+" (project "+auth.project+")")
+	if err := loadAuthErr(); err != nil {
+		show("auth", err.Error())
+		die()
+	}
+	if auth.cookieName != "" {
+		show("auth", "cookie "+auth.cookieName+" from http.cookiefile")
+	} else {
+		show("auth", "user "+auth.user+" from "+netrcName())
+	}
+
+	// Check that the credentials work, without changing anything.
+	http.DefaultClient.Timeout = 60 * time.Second
+	var acct gerritAccount
+	if err := gerritAPI("/a/accounts/self", nil, &acct); err != nil {
+		show("account", fmt.Sprintf("cannot sign in to Gerrit: %v", err))
+		die()
+	}
+	show("account", fmt.Sprintf("%s <%s> (username %s)", acct.Name, acct.Email, acct.Username))
+	if !ok {
+		die()
+	}
+}
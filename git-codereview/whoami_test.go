@@ -0,0 +1,33 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestWhoami(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	// Without Gerrit, only the local identity is shown.
+	testMain(t, "whoami")
+	testPrintedStdout(t, "user.name:  gopher", "user.email: gopher@example.com", "remote:     origin "+gt.server, "gerrit:     (none")
+
+	srv := newGerritServer(t)
+	defer srv.done()
+
+	write(t, gt.server+"/codereview.cfg", "gerrit: on")
+	trun(t, gt.server, "git", "add", "codereview.cfg")
+	trun(t, gt.server, "git", "commit", "-m", "codereview.cfg on master")
+	trun(t, gt.client, "git", "pull")
+
+	srv.setReply("/a/accounts/self", gerritReply{json: gerritAccount{Name: "Gopher", Email: "gopher@example.com", Username: "gopher"}})
+	testMain(t, "whoami")
+	testPrintedStdout(t, "gerrit:     "+auth.url+" (project proj)", "account:    Gopher <gopher@example.com> (username gopher)")
+	testNoStderr(t)
+
+	srv.setReply("/a/accounts/self", gerritReply{status: 401})
+	testMainDied(t, "whoami")
+	testPrintedStdout(t, "account:    cannot sign in to Gerrit")
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func cmdWorktree(args []string) {
+	remove := flags.Bool("remove", false, "remove the worktree of the branch instead of creating one")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), "Usage: %s worktree %s [-remove] branchname\n", os.Args[0], globalFlags)
+	}
+	flags.Parse(args)
+	if len(flags.Args()) != 1 {
+		flags.Usage()
+		os.Exit(exitUsage)
+	}
+	name := flags.Arg(0)
+	if *remove {
+		removeWorktree(name)
+		return
+	}
+
+	checkBranchName(name, true)
+	if _, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/"+name); err == nil {
+		dief("cannot create worktree: branch %s already exists", name)
+	}
+	dir := worktreeDir(name)
+	if _, err := os.Stat(dir); err == nil {
+		dief("cannot create worktree: %s already exists", dir)
+	}
+
+	current := CurrentBranch()
+	origin := current.OriginBranch()
+	if current.DetachedHead() {
+		origin = "origin/" + upstreamBranch()
+	}
+	run("git", "worktree", "add", "-q", "--track", "-b", name, dir, origin)
+	printf("created worktree %s on new branch %s tracking %s.", dir, name, origin)
+}
+
+// A worktree is a working tree of the repository, as listed by git worktree list.
+type worktree struct {
+	dir    string // directory of the worktree
+	branch string // branch checked out in it ("" if HEAD is detached)
+}
+
+// worktrees returns the working trees of the repository,
+// starting with the main one.
+func worktrees() []worktree {
+	var list []worktree
+	for _, line := range lines(cmdOutput("git", "worktree", "list", "--porcelain")) {
+		if strings.HasPrefix(line, "worktree ") {
+			list = append(list, worktree{dir: strings.TrimPrefix(line, "worktree ")})
+		} else if strings.HasPrefix(line, "branch ") && len(list) > 0 {
+			list[len(list)-1].branch = strings.TrimPrefix(line, "branch refs/heads/")
+		}
+	}
+	return list
+}
+
+// worktreeDir returns the directory for the worktree of the work branch
+// name, a sibling of the main worktree: for the branch fix-parse in
+// the repository /home/gopher/go, it is /home/gopher/go-fix-parse.
+func worktreeDir(name string) string {
+	main := filepath.Clean(worktrees()[0].dir)
+	return filepath.Join(filepath.Dir(main), filepath.Base(main)+"-"+name)
+}
+
+// removeWorktree removes the linked worktree in which the branch name is
+// checked out, refusing if it has uncommitted changes, which would be lost.
+// The branch itself is kept, along with any pending changes on it.
+func removeWorktree(name string) {
+	dir := ""
+	for i, wt := range worktrees() {
+		if wt.branch == name {
+			if i == 0 {
+				dief("cannot remove worktree: branch %s is checked out in the main worktree", name)
+			}
+			dir = wt.dir
+		}
+	}
+	if dir == "" {
+		dief("cannot remove worktree: branch %s is not checked out in a worktree", name)
+	}
+	if CurrentBranch().Name == name {
+		dief("cannot remove worktree %s: it is the current worktree\n"+
+			"\trun '%s worktree -remove %s' from another worktree", dir, os.Args[0], name)
+	}
+	if trim(cmdOutput("git", "-C", dir, "status", "--porcelain")) != "" {
+		dief("cannot remove worktree %s: uncommitted changes exist\n"+
+			"\trun 'git -C %s status' to see changes", dir, dir)
+	}
+	run("git", "worktree", "remove", dir)
+	printf("removed worktree %s; branch %s is kept.", dir, name)
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorktree(t *testing.T) {
+	gt := newGitTest(t)
+	defer gt.done()
+
+	dir := filepath.Join(gt.tmpdir, "git-client-fix")
+	testMain(t, "worktree", "fix")
+	testPrintedStderr(t, "created worktree "+dir+" on new branch fix tracking origin/master.")
+	if b := CurrentBranch(); b.Name != "master" {
+		t.Fatalf("on branch %s after worktree, want master", b.Name)
+	}
+
+	testMainDied(t, "worktree", "fix")
+	testPrintedStderr(t, "cannot create worktree: branch fix already exists")
+
+	// Work in the new worktree, which shares the hooks.
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b := CurrentBranch(); b.Name != "fix" || b.OriginBranch() != "origin/master" || !b.IsLocalOnly() {
+		t.Fatalf("in worktree, on %s tracking %s; want work branch fix tracking origin/master", b.Name, b.OriginBranch())
+	}
+	if got, want := gitPath("hooks"), filepath.Join(gt.client, ".git", "hooks"); got != want {
+		t.Fatalf("in worktree, hooks in %s, want %s", got, want)
+	}
+	write(t, dir+"/file", "fixed")
+	trun(t, dir, "git", "add", "file")
+	testMain(t, "change", "-m", "foo: fix")
+	if work := CurrentBranch().Pending(); len(work) != 1 {
+		t.Fatalf("in worktree, change made %d commits; want one", len(work))
+	}
+	testMainDied(t, "worktree", "-remove", "fix")
+	testPrintedStderr(t, "it is the current worktree")
+
+	// Remove it from the main worktree, once it is clean.
+	write(t, dir+"/file", "more")
+	if err := os.Chdir(gt.client); err != nil {
+		t.Fatal(err)
+	}
+	testMainDied(t, "worktree", "-remove", "fix")
+	testPrintedStderr(t, "cannot remove worktree "+dir+": uncommitted changes exist")
+	trun(t, dir, "git", "checkout", "file")
+
+	testMainDied(t, "worktree", "-remove", "master")
+	testPrintedStderr(t, "branch master is checked out in the main worktree")
+	testMainDied(t, "worktree", "-remove", "nosuchbranch")
+	testPrintedStderr(t, "branch nosuchbranch is not checked out in a worktree")
+
+	testMain(t, "worktree", "-remove", "fix")
+	testPrintedStderr(t, "removed worktree "+dir+"; branch fix is kept.")
+	if _, err := os.Stat(dir); err == nil {
+		t.Fatalf("worktree %s still exists after -remove", dir)
+	}
+	if _, err := cmdOutputErr("git", "show-ref", "--verify", "--quiet", "refs/heads/fix"); err != nil {
+		t.Fatalf("branch fix deleted by worktree -remove")
+	}
+}